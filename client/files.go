@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"terminal-chat/client/state"
+	"terminal-chat/client/ui"
+)
+
+// pendingUpload is a file being uploaded, to be posted with a message once
+// the server has it
+type pendingUpload struct {
+	path    string
+	channel string
+	message string
+}
+
+// uploadSentMsg reports that an upload's chunks have been sent, or why not
+type uploadSentMsg struct {
+	id  string
+	err error
+}
+
+// downloadMsg reports where a download was saved, or why it failed
+type downloadMsg struct {
+	name string
+	path string
+	err  error
+}
+
+// previewMsg carries an image decoded for previewing, or why it could not
+// be
+type previewMsg struct {
+	file state.File
+	img  image.Image
+	err  error
+}
+
+// maxPreviewSize bounds the images fetched for previews, in bytes
+const maxPreviewSize = 10 << 20
+
+// runLocalCommand handles the commands the client runs itself because they
+// touch local files, reporting false for anything else
+func (m *Model) runLocalCommand(content string) (tea.Cmd, bool) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(content, "/"), " ")
+	args = strings.TrimSpace(args)
+	switch name {
+	case "upload":
+		m.startUpload(args)
+	case "files":
+		m.wsClient.ListFiles(m.chatState.ActiveChannel)
+	case "download":
+		return m.download(args), true
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// startUpload asks the server to receive the file named by args, which is
+// a path optionally followed by a message to post with it. Paths with
+// spaces are quoted.
+func (m *Model) startUpload(args string) {
+	path, message := splitPath(args)
+	if path == "" {
+		m.chatState.AddSystemMessage("Usage: /upload <path> [message]")
+		return
+	}
+	path = expandHome(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		m.chatState.AddSystemMessage(fmt.Sprintf("Cannot upload %s: %v", path, err))
+		return
+	}
+	if !info.Mode().IsRegular() {
+		m.chatState.AddSystemMessage("Cannot upload " + path + ": not a regular file")
+		return
+	}
+	if limit := m.chatState.MaxFileSize; limit > 0 && info.Size() > limit {
+		m.chatState.AddSystemMessage(fmt.Sprintf("Cannot upload %s: files are limited to %s", path, ui.FormatSize(limit)))
+		return
+	}
+
+	id := m.wsClient.StartUpload(m.chatState.ActiveChannel, filepath.Base(path), info.Size())
+	m.uploads[id] = &pendingUpload{path: path, channel: m.chatState.ActiveChannel, message: message}
+	m.chatState.AddSystemMessage(fmt.Sprintf("Uploading %s (%s)…", filepath.Base(path), ui.FormatSize(info.Size())))
+}
+
+// sendChunks sends an upload's contents once the server is ready for them
+func (m *Model) sendChunks(id string, chunkSize int) tea.Cmd {
+	upload := m.uploads[id]
+	if upload == nil || chunkSize <= 0 {
+		return nil
+	}
+	client := m.wsClient
+	return func() tea.Msg {
+		return uploadSentMsg{id: id, err: client.SendChunks(id, upload.path, chunkSize)}
+	}
+}
+
+// postUpload posts a finished upload to its channel
+func (m *Model) postUpload(id string, file state.File) {
+	upload := m.uploads[id]
+	if upload == nil {
+		return
+	}
+	delete(m.uploads, id)
+
+	msgID := m.wsClient.SendFiles(upload.channel, upload.message, []string{file.ID})
+	m.chatState.AddMessage(upload.channel, state.Message{
+		ID:        msgID,
+		ChannelID: upload.channel,
+		Username:  m.chatState.Username,
+		Content:   upload.message,
+		Timestamp: time.Now(),
+		Files:     []state.File{file},
+	})
+}
+
+// download saves the file named by args, a file name or the start of its
+// ID optionally followed by a directory, in the background
+func (m *Model) download(args string) tea.Cmd {
+	ref, dir := splitPath(args)
+	if ref == "" {
+		m.chatState.AddSystemMessage("Usage: /download <file or id> [directory]")
+		return nil
+	}
+	file, ok := m.chatState.FindFile(m.chatState.ActiveChannel, ref)
+	if !ok {
+		m.chatState.AddSystemMessage("No file " + ref + " in #" + m.chatState.ActiveChannel + "; /files lists them")
+		return nil
+	}
+	if dir == "" {
+		dir = m.downloadDir
+	}
+	dir = expandHome(dir)
+
+	m.chatState.AddSystemMessage("Downloading " + file.Name + "…")
+	client := m.wsClient
+	return func() tea.Msg {
+		path, err := client.Download(file.ID, file.Name, dir)
+		return downloadMsg{name: file.Name, path: path, err: err}
+	}
+}
+
+// fetchPreviews fetches the images among files that have not been
+// fetched yet, in the background
+func (m *Model) fetchPreviews(files []state.File) tea.Cmd {
+	if !m.layout.PreviewsImages() {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, f := range files {
+		if !ui.CanPreview(f) || f.Size > maxPreviewSize || m.previews[f.ID] {
+			continue
+		}
+		m.previews[f.ID] = true
+		client := m.wsClient
+		cmds = append(cmds, func() tea.Msg {
+			data, err := client.FetchFile(f.ID, maxPreviewSize)
+			if err != nil {
+				return previewMsg{file: f, err: err}
+			}
+			img, err := ui.DecodePreview(data)
+			return previewMsg{file: f, img: img, err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// showFiles lists the files of a channel as a notice
+func (m *Model) showFiles(channel string, files []state.File) {
+	m.chatState.ChannelFiles[channel] = files
+	if len(files) == 0 {
+		m.chatState.AddSystemMessage("No files have been shared in #" + channel)
+		return
+	}
+	lines := []string{fmt.Sprintf("%d files in #%s:", len(files), channel)}
+	for _, f := range files {
+		lines = append(lines, "  "+ui.FileSummary(f))
+	}
+	m.chatState.AddSystemMessage(strings.Join(lines, "\n"))
+}
+
+// filesFromData decodes a list of files from event data
+func filesFromData(value interface{}) []state.File {
+	items, _ := value.([]interface{})
+	var files []state.File
+	for _, item := range items {
+		f, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var file state.File
+		file.ID, _ = f["id"].(string)
+		file.Name, _ = f["name"].(string)
+		file.MIME, _ = f["mime"].(string)
+		file.Uploader, _ = f["uploader"].(string)
+		size, _ := f["size"].(float64)
+		file.Size = int64(size)
+		files = append(files, file)
+	}
+	return files
+}
+
+// splitPath splits off the first word of args, which may be quoted to
+// contain spaces, from the rest
+func splitPath(args string) (string, string) {
+	if quote := args[:min(len(args), 1)]; quote == `"` || quote == "'" {
+		if end := strings.Index(args[1:], quote); end >= 0 {
+			return args[1 : end+1], strings.TrimSpace(args[end+2:])
+		}
+	}
+	first, rest, _ := strings.Cut(args, " ")
+	return first, strings.TrimSpace(rest)
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// defaultDownloadDir is ~/Downloads if it exists, or the working directory
+func defaultDownloadDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		dir := filepath.Join(home, "Downloads")
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return "."
+}
@@ -2,11 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	// Profile cards show local time in any timezone, whatever zone data
+	// the system has
+	_ "time/tzdata"
+
 	tea "github.com/charmbracelet/bubbletea"
 
 	"terminal-chat/client/network"
@@ -21,8 +28,32 @@ type Model struct {
 	wsClient  *network.WSClient
 	ready     bool
 	msgs      chan []byte
+
+	// listening is set once the message polling loop has been started
+	listening bool
+
+	// reconnectAttempts counts failed connects since the last success
+	reconnectAttempts int
+
+	// uploads holds the files being uploaded, by upload ID
+	uploads map[string]*pendingUpload
+
+	// downloadDir is where /download saves files by default
+	downloadDir string
+
+	// previews holds the IDs of the images fetched for previews
+	previews map[string]bool
 }
 
+// Reconnect backoff bounds
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// closeBanned is the close code the server sends when the user is banned
+const closeBanned = 4003
+
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
 	// Focus the input field so it can receive keyboard input
@@ -40,25 +71,42 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.handleProfileKey(msg.String()) {
+			return m, nil
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return m, tea.Quit
 		case "enter", "\r", "\n", "return", "ctrl+m":
 			// Send message
 			content := m.layout.GetInputValue()
-			if content != "" && m.chatState.Connected {
-				// Add message to local chat state immediately for instant UI feedback
-				localMsg := state.Message{
-					ChannelID: m.chatState.ActiveChannel,
-					Username:  m.chatState.Username,
-					Content:   content,
-					Timestamp: time.Now(),
+			if content != "" && m.chatState.Connected && isCommand(content) {
+				// Commands touching local files are run here
+				if cmd, ok := m.runLocalCommand(content); ok {
+					m.layout.ClearInput()
+					m.layout.UpdateMessageView()
+					return m, cmd
 				}
-				m.chatState.AddMessage(m.chatState.ActiveChannel, localMsg)
-				m.layout.UpdateMessageView()
-
+			}
+			if content != "" && m.chatState.Connected {
 				// Send to server
-				m.wsClient.SendMessage(m.chatState.ActiveChannel, content)
+				id := m.wsClient.SendMessage(m.chatState.ActiveChannel, content)
+
+				// Add message to local chat state immediately for instant UI feedback;
+				// the server's echo carries the same ID and is skipped. Slash
+				// commands are run by the server, which posts whatever they
+				// produce, and a doubled slash is posted with one.
+				if !isCommand(content) {
+					localMsg := state.Message{
+						ID:        id,
+						ChannelID: m.chatState.ActiveChannel,
+						Username:  m.chatState.Username,
+						Content:   strings.TrimPrefix(content, "/"),
+						Timestamp: time.Now(),
+					}
+					m.chatState.AddMessage(m.chatState.ActiveChannel, localMsg)
+					m.layout.UpdateMessageView()
+				}
 				m.layout.ClearInput()
 			}
 		case "tab":
@@ -71,6 +119,21 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.layout.PrevChannel()
 			channel := m.chatState.ActiveChannel
 			m.wsClient.JoinChannel(channel)
+		case "ctrl+n":
+			// Show or hide usernames next to display names
+			m.chatState.ShowHandles = !m.chatState.ShowHandles
+			m.layout.UpdateMessageView()
+		case "ctrl+r":
+			// Show messages as their Markdown source, or rendered again
+			m.chatState.ShowRaw = !m.chatState.ShowRaw
+			m.layout.UpdateMessageView()
+		case "ctrl+p":
+			// Pick a message whose author's profile to open
+			m.chatState.ProfileCard = nil
+			if !m.chatState.StartSelecting() {
+				m.chatState.AddSystemMessage("No messages to pick an author from")
+			}
+			m.layout.UpdateMessageView()
 		case "up":
 			m.layout.ScrollMessageViewUp()
 		case "down":
@@ -87,9 +150,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			log.Printf("Error parsing incoming message: %v", err)
 			break
 		}
-		m.handleEvent(event)
+		cmd := m.handleEvent(event)
 		// Restart message polling and trigger re-render
 		return m, tea.Batch(
+			cmd,
 			listenForMessages(m.wsClient),
 			tea.Tick(time.Millisecond*10, func(time.Time) tea.Msg {
 				return nil // This should trigger another update
@@ -105,11 +169,47 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case connectMsg:
 		if msg.err != nil {
 			log.Printf("Failed to connect: %v", msg.err)
+			delay := m.nextReconnectDelay()
+			m.chatState.AddSystemMessage(fmt.Sprintf("Failed to connect: %v. Retrying in %s", msg.err, delay))
+			cmds = append(cmds, reconnectAfter(m.wsClient, delay))
 		} else {
+			if m.reconnectAttempts > 0 {
+				m.chatState.AddSystemMessage("Reconnected")
+			}
+			m.reconnectAttempts = 0
 			m.chatState.Connected = true
-			cmds = append(cmds, listenForMessages(m.wsClient))
+			m.wsClient.JoinChannel(m.chatState.ActiveChannel)
+			if !m.listening {
+				m.listening = true
+				cmds = append(cmds, listenForMessages(m.wsClient))
+			}
+		}
+
+	case uploadSentMsg:
+		if msg.err != nil {
+			delete(m.uploads, msg.id)
+			m.chatState.AddSystemMessage(fmt.Sprintf("Upload failed: %v", msg.err))
+			m.layout.UpdateMessageView()
 		}
 
+	case downloadMsg:
+		if msg.err != nil {
+			m.chatState.AddSystemMessage(fmt.Sprintf("Downloading %s failed: %v", msg.name, msg.err))
+		} else {
+			m.chatState.AddSystemMessage("Saved " + msg.name + " to " + msg.path)
+		}
+		m.layout.UpdateMessageView()
+
+	case previewMsg:
+		if msg.err != nil {
+			// The file line is still shown, so a preview failing is not
+			// worth a notice
+			log.Printf("Previewing %s: %v", msg.file.Name, msg.err)
+			break
+		}
+		m.layout.SetPreview(msg.file.ID, msg.img)
+		m.layout.UpdateMessageView()
+
 	case checkForMessagesMsg:
 		// Trigger another check for messages
 		return m, listenForMessages(msg.client)
@@ -121,39 +221,328 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// handleProfileKey handles keys while picking a message author or viewing
+// a profile card, reporting whether the key was used
+func (m *Model) handleProfileKey(key string) bool {
+	switch {
+	case m.chatState.Selecting:
+		switch key {
+		case "up":
+			m.chatState.MoveSelection(-1)
+		case "down":
+			m.chatState.MoveSelection(1)
+		case "enter", "\r", "\n", "return", "ctrl+m":
+			if username := m.chatState.SelectedAuthor(); username != "" && m.chatState.Connected {
+				m.chatState.PendingProfile = username
+				m.wsClient.RequestProfile(username)
+			}
+		case "esc":
+			m.chatState.Selecting = false
+		default:
+			return false
+		}
+	case m.chatState.ProfileCard != nil:
+		if key != "esc" {
+			return false
+		}
+		m.chatState.ProfileCard = nil
+	default:
+		return false
+	}
+	m.layout.UpdateMessageView()
+	return true
+}
+
 // handleEvent processes incoming events from the server
-func (m *Model) handleEvent(event map[string]interface{}) {
+func (m *Model) handleEvent(event map[string]interface{}) tea.Cmd {
 	eventType, ok := event["type"].(string)
 	if !ok {
-		return
+		return nil
 	}
 
 	switch eventType {
 	case "send_message":
+		msg := messageFromEvent(event)
+
+		// Skip unidentified messages from ourselves to avoid duplicates (we add them locally when sending)
+		if msg.ID == "" && msg.Username == m.chatState.Username {
+			return nil
+		}
+
+		// Live messages carry the sender's current display name and color
+		m.chatState.SetDisplayName(msg.Username, msg.DisplayName)
+		m.chatState.SetNameColor(msg.Username, msg.Color)
+		m.chatState.AddMessage(msg.ChannelID, msg)
+		m.layout.UpdateMessageView()
+		return m.fetchPreviews(msg.Files)
+
+	case "user_renamed":
+		// Existing messages are shown with the new name; each shared
+		// channel also gets a notice
+		data, _ := event["data"].(map[string]interface{})
+		username, _ := data["username"].(string)
+		name, _ := data["display_name"].(string)
+		m.chatState.SetDisplayName(username, name)
+		if channel, _ := event["channel"].(string); channel != "" {
+			msg := messageFromEvent(event)
+			msg.Username = ""
+			msg.System = true
+			m.chatState.AddMessage(channel, msg)
+		}
+		m.layout.UpdateMessageView()
+
+	case "upload_ready":
+		// The server is ready for the contents of a file we are uploading
+		data, _ := event["data"].(map[string]interface{})
+		id, _ := data["upload_id"].(string)
+		chunkSize, _ := data["chunk_size"].(float64)
+		return m.sendChunks(id, int(chunkSize))
+
+	case "upload_complete":
+		// The server stored the file; post it
+		data, _ := event["data"].(map[string]interface{})
+		id, _ := data["upload_id"].(string)
+		files := filesFromData([]interface{}{data["file"]})
+		if len(files) == 1 {
+			m.postUpload(id, files[0])
+		}
+		m.layout.UpdateMessageView()
+		return m.fetchPreviews(files)
+
+	case "files":
+		// The files shared in a channel, as asked for with /files
 		channel, _ := event["channel"].(string)
-		from, _ := event["from"].(string)
+		data, _ := event["data"].(map[string]interface{})
+		m.showFiles(channel, filesFromData(data["files"]))
+		m.layout.UpdateMessageView()
+
+	case "profile":
+		// A profile we asked for, shown as a card
+		profile := profileFromEvent(event)
+		if !strings.EqualFold(profile.Username, m.chatState.PendingProfile) {
+			return nil
+		}
+		m.chatState.PendingProfile = ""
+		m.chatState.ProfileCard = &profile
+		m.layout.UpdateMessageView()
+
+	case "profile_updated":
+		// Someone we share a channel with, or we ourselves, changed their
+		// profile
+		m.chatState.UpdateProfile(profileFromEvent(event))
+		m.layout.UpdateMessageView()
+
+	case "history":
+		// Recent messages replayed by the server after joining a channel
+		channel, _ := event["channel"].(string)
+		data, _ := event["data"].(map[string]interface{})
+		items, _ := data["messages"].([]interface{})
+
+		var (
+			msgs  []state.Message
+			files []state.File
+		)
+		for _, item := range items {
+			if e, ok := item.(map[string]interface{}); ok {
+				msg := messageFromEvent(e)
+				msgs = append(msgs, msg)
+				files = append(files, msg.Files...)
+			}
+		}
+		m.chatState.MergeHistory(channel, msgs)
+		m.layout.UpdateMessageView()
+		return m.fetchPreviews(files)
+
+	case "resync":
+		// The server dropped messages for us; refetch what we missed
 		content, _ := event["content"].(string)
-		timestamp, _ := event["timestamp"].(float64)
+		m.chatState.AddSystemMessage(content)
+		if m.chatState.Connected {
+			m.wsClient.RequestHistory(m.chatState.ActiveChannel)
+		}
+		m.layout.UpdateMessageView()
 
-		// Skip messages from ourselves to avoid duplicates (we add them locally when sending)
-		if from == m.chatState.Username {
-			return
+	case "disconnected":
+		// Synthesized by the network client when the connection ends
+		reason, _ := event["content"].(string)
+		m.chatState.Connected = false
+		data, _ := event["data"].(map[string]interface{})
+		if code, _ := data["code"].(float64); int(code) == closeBanned {
+			// Reconnecting would only be refused again
+			m.chatState.AddSystemMessage(fmt.Sprintf("Disconnected: %s", reason))
+			m.layout.UpdateMessageView()
+			return nil
 		}
+		delay := m.nextReconnectDelay()
+		m.chatState.AddSystemMessage(fmt.Sprintf("Disconnected: %s. Reconnecting in %s", reason, delay))
+		m.layout.UpdateMessageView()
+		return reconnectAfter(m.wsClient, delay)
+
+	case "welcome":
+		// The server advertises its limits on connect
+		data, _ := event["data"].(map[string]interface{})
+		if limit, ok := data["max_content_length"].(float64); ok && limit > 0 {
+			m.chatState.MaxContentLength = int(limit)
+			m.layout.SetInputCharLimit(int(limit))
+		}
+		if limit, ok := data["max_file_size"].(float64); ok {
+			m.chatState.MaxFileSize = int64(limit)
+		}
+		m.updateChannels(data)
+
+	case "channels_updated":
+		// An admin created or archived a channel
+		data, _ := event["data"].(map[string]interface{})
+		m.updateChannels(data)
+
+	case "channel_archived":
+		content, _ := event["content"].(string)
+		m.chatState.AddSystemMessage(content)
+		m.layout.UpdateMessageView()
+
+	case "announcement":
+		content, _ := event["content"].(string)
+		m.chatState.AddSystemMessage("Announcement: " + content)
+		m.layout.UpdateMessageView()
 
-		msg := state.Message{
-			ChannelID: channel,
-			Username:  from,
-			Content:   content,
+	case "notice":
+		// A reply to a slash command, seen only by us
+		content, _ := event["content"].(string)
+		m.chatState.AddSystemMessage(content)
+		data, _ := event["data"].(map[string]interface{})
+		if joined, ok := data["joined"].(string); ok {
+			m.chatState.SetActiveChannel(joined)
+			m.layout.UpdateSidebar()
 		}
+		m.layout.UpdateMessageView()
 
-		if timestamp > 0 {
-			// Convert Unix timestamp to time.Time
-			msg.Timestamp = time.Unix(int64(timestamp), 0)
+	case "system_message":
+		// Something changed in a channel, such as its topic
+		msg := messageFromEvent(event)
+		msg.System = true
+		m.chatState.AddMessage(msg.ChannelID, msg)
+		data, _ := event["data"].(map[string]interface{})
+		if topic, ok := data["topic"].(string); ok {
+			description, _ := data["description"].(string)
+			m.chatState.SetTopic(msg.ChannelID, topic, description)
 		}
+		m.layout.UpdateMessageView()
 
-		m.chatState.AddMessage(channel, msg)
+	case "error":
+		// Show rejections from the server in the active channel
+		content, _ := event["content"].(string)
+		m.chatState.AddSystemMessage(content)
+		data, _ := event["data"].(map[string]interface{})
+		if id, ok := data["upload_id"].(string); ok {
+			delete(m.uploads, id)
+		}
 		m.layout.UpdateMessageView()
 	}
+	return nil
+}
+
+// updateChannels replaces the channel list and topics with data["channels"]
+// and data["topics"], moving to another channel if the active one is no
+// longer offered
+func (m *Model) updateChannels(data map[string]interface{}) {
+	channels, ok := data["channels"].([]interface{})
+	if !ok || len(channels) == 0 {
+		return
+	}
+
+	var names []string
+	for _, ch := range channels {
+		if name, ok := ch.(string); ok {
+			names = append(names, name)
+		}
+	}
+	if m.chatState.SetChannels(names) && m.chatState.Connected {
+		// The channel we joined is not offered by this server
+		m.wsClient.JoinChannel(m.chatState.ActiveChannel)
+	}
+	topics, _ := data["topics"].(map[string]interface{})
+	for _, name := range names {
+		t, _ := topics[name].(map[string]interface{})
+		topic, _ := t["topic"].(string)
+		description, _ := t["description"].(string)
+		m.chatState.SetTopic(name, topic, description)
+	}
+	m.layout.UpdateSidebar()
+}
+
+// messageFromEvent converts a send_message event into a chat message
+func messageFromEvent(event map[string]interface{}) state.Message {
+	id, _ := event["id"].(string)
+	channel, _ := event["channel"].(string)
+	from, _ := event["from"].(string)
+	content, _ := event["content"].(string)
+	timestamp, _ := event["timestamp"].(float64)
+
+	msg := state.Message{
+		ID:        id,
+		ChannelID: channel,
+		Username:  from,
+		Content:   content,
+	}
+
+	if timestamp > 0 {
+		// Convert Unix timestamp to time.Time
+		msg.Timestamp = time.Unix(int64(timestamp), 0)
+	}
+
+	data, _ := event["data"].(map[string]interface{})
+	msg.Bot, _ = data["bot"].(bool)
+	msg.Action, _ = data["action"].(bool)
+	msg.DisplayName, _ = data["display_name"].(string)
+	msg.Color, _ = data["color"].(string)
+	attachments, _ := data["attachments"].([]interface{})
+	for _, item := range attachments {
+		a, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		title, _ := a["title"].(string)
+		url, _ := a["url"].(string)
+		text, _ := a["text"].(string)
+		color, _ := a["color"].(string)
+		msg.Attachments = append(msg.Attachments, state.Attachment{Title: title, URL: url, Text: text, Color: color})
+	}
+	msg.Files = filesFromData(data["files"])
+	return msg
+}
+
+// profileFromEvent converts a profile or profile_updated event into a
+// profile
+func profileFromEvent(event map[string]interface{}) state.Profile {
+	data, _ := event["data"].(map[string]interface{})
+	fields, _ := data["profile"].(map[string]interface{})
+
+	var p state.Profile
+	p.Username, _ = data["username"].(string)
+	p.Online, _ = data["online"].(bool)
+	p.Bot, _ = data["bot"].(bool)
+	p.DisplayName, _ = fields["display_name"].(string)
+	p.Pronouns, _ = fields["pronouns"].(string)
+	p.StatusEmoji, _ = fields["status_emoji"].(string)
+	p.StatusText, _ = fields["status_text"].(string)
+	p.Timezone, _ = fields["timezone"].(string)
+	p.Color, _ = fields["color"].(string)
+	return p
+}
+
+// isCommand reports whether input is a slash command rather than a message
+func isCommand(content string) bool {
+	return strings.HasPrefix(content, "/") && !strings.HasPrefix(content, "//")
+}
+
+// nextReconnectDelay returns an exponential backoff delay and counts the attempt
+func (m *Model) nextReconnectDelay() time.Duration {
+	delay := minReconnectDelay << m.reconnectAttempts
+	if delay > maxReconnectDelay || delay <= 0 {
+		delay = maxReconnectDelay
+	}
+	m.reconnectAttempts++
+	return delay
 }
 
 // View renders the UI
@@ -161,6 +550,19 @@ func (m Model) View() string {
 	return m.layout.View()
 }
 
+// terminalOutput writes to the terminal one write at a time, so images sent
+// to it between frames never land in the middle of one
+type terminalOutput struct {
+	*os.File
+	mu sync.Mutex
+}
+
+func (o *terminalOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.File.Write(p)
+}
+
 // Messages for handling async operations
 type connectMsg struct {
 	err error
@@ -178,6 +580,13 @@ func connectToServer(client *network.WSClient) tea.Cmd {
 	}
 }
 
+// reconnectAfter waits for delay and then connects again
+func reconnectAfter(client *network.WSClient, delay time.Duration) tea.Cmd {
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		return connectMsg{err: client.Connect()}
+	})
+}
+
 // listenForMessages creates a command that checks for WebSocket messages
 func listenForMessages(client *network.WSClient) tea.Cmd {
 	return tea.Tick(time.Millisecond*10, func(t time.Time) tea.Msg {
@@ -197,24 +606,58 @@ type checkForMessagesMsg struct {
 }
 
 func main() {
+	serverURL := flag.String("server", "ws://localhost:8080/ws", "server WebSocket URL (ws:// or wss://)")
+	caFile := flag.String("ca", "", "PEM CA bundle to trust for wss://")
+	tofu := flag.Bool("tofu", false, "trust the server certificate on first use and pin its fingerprint")
+	knownHosts := flag.String("known-hosts", "", "file storing pinned fingerprints for -tofu")
+	downloadDir := flag.String("download-dir", defaultDownloadDir(), "directory /download saves files to")
+	images := flag.String("images", "blocks", "how to preview images: blocks, inline (kitty or iTerm2 protocol, if the terminal has one) or off")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [username]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// A pinned certificate is trusted without checking its chain, so -ca
+	// would have no effect
+	if *tofu && *caFile != "" {
+		fmt.Fprintln(os.Stderr, "-tofu and -ca cannot be used together")
+		os.Exit(2)
+	}
+
+	imageMode, err := ui.ParseImageMode(*images)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	username := "user"
-	if len(os.Args) > 1 {
-		username = os.Args[1]
+	if flag.NArg() > 0 {
+		username = flag.Arg(0)
 	}
 
 	chatState := state.NewChatState(username)
 	layout := ui.NewLayout(chatState)
-	wsClient := network.NewWSClient("ws://localhost:8080/ws", username)
+	output := &terminalOutput{File: os.Stdout}
+	layout.SetImageMode(imageMode, output)
+	wsClient := network.NewWSClient(*serverURL, username, network.TLSOptions{
+		CAFile:         *caFile,
+		TOFU:           *tofu,
+		KnownHostsFile: *knownHosts,
+	})
 
 	model := Model{
-		chatState: chatState,
-		layout:    layout,
-		wsClient:  wsClient,
-		ready:     false,
-		msgs:      make(chan []byte, 100),
+		chatState:   chatState,
+		layout:      layout,
+		wsClient:    wsClient,
+		ready:       false,
+		msgs:        make(chan []byte, 100),
+		uploads:     make(map[string]*pendingUpload),
+		downloadDir: *downloadDir,
+		previews:    make(map[string]bool),
 	}
 
-	p := tea.NewProgram(model, tea.WithAltScreen())
+	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithOutput(output))
 
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running program: %v", err)
@@ -0,0 +1,201 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// send queues an event for the server
+func (c *WSClient) send(event map[string]interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling %v: %v", event["type"], err)
+		return
+	}
+	c.outgoing <- data
+}
+
+// StartUpload asks the server to receive a file for channel and returns the
+// upload's ID. The server answers with upload_ready, after which the
+// contents are sent with SendChunks.
+func (c *WSClient) StartUpload(channel, name string, size int64) string {
+	id := newMessageID()
+	c.send(map[string]interface{}{
+		"type":    "upload_start",
+		"channel": channel,
+		"data":    map[string]interface{}{"upload_id": id, "name": name, "size": size},
+	})
+	return id
+}
+
+// SendChunks sends the file at path for an upload in chunks of chunkSize
+// bytes, then asks the server to finish it. The server answers with
+// upload_complete.
+func (c *WSClient) SendChunks(id, path string, chunkSize int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			c.send(map[string]interface{}{
+				"type": "upload_chunk",
+				"data": map[string]interface{}{
+					"upload_id": id,
+					"offset":    offset,
+					"data":      base64.StdEncoding.EncodeToString(buf[:n]),
+				},
+			})
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			c.send(map[string]interface{}{"type": "upload_cancel", "data": map[string]interface{}{"upload_id": id}})
+			return err
+		}
+	}
+
+	c.send(map[string]interface{}{
+		"type": "upload_finish",
+		"data": map[string]interface{}{"upload_id": id, "sha256": hex.EncodeToString(hash.Sum(nil))},
+	})
+	return nil
+}
+
+// SendFiles posts a message carrying uploaded files and returns the ID the
+// server will echo it with
+func (c *WSClient) SendFiles(channel, content string, fileIDs []string) string {
+	id := newMessageID()
+	c.send(map[string]interface{}{
+		"type":    "send_message",
+		"id":      id,
+		"channel": channel,
+		"content": content,
+		"data":    map[string]interface{}{"files": fileIDs},
+	})
+	return id
+}
+
+// ListFiles asks the server for the files uploaded to a joined channel
+func (c *WSClient) ListFiles(channel string) {
+	c.send(map[string]interface{}{
+		"type":    "list_files",
+		"channel": channel,
+	})
+}
+
+// Download saves a file into dir under name, adding a number to the name
+// if it is taken, and returns the path written
+func (c *WSClient) Download(id, name, dir string) (string, error) {
+	resp, err := c.getFile(id)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	out, path, err := createUnique(dir, filepath.Base(name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, out.Close()
+}
+
+// FetchFile returns a file's contents, if they are at most limit bytes
+func (c *WSClient) FetchFile(id string, limit int64) ([]byte, error) {
+	resp, err := c.getFile(id)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("file is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// getFile requests a file from the server's HTTP side, which shares the
+// WebSocket's address and TLS settings
+func (c *WSClient) getFile(id string) (*http.Response, error) {
+	u, err := url.Parse(c.serverURL)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{DisableKeepAlives: true}
+	if u.Scheme == "wss" {
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		if transport.TLSClientConfig, err = buildTLSConfig(host, c.tlsOpts); err != nil {
+			return nil, err
+		}
+	}
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	u.Path = strings.TrimSuffix(u.Path, "/ws") + "/files/" + url.PathEscape(id)
+	u.RawQuery = ""
+
+	resp, err := (&http.Client{Transport: transport}).Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// createUnique creates name in dir, or "name (2).ext" and so on if it
+// already exists
+func createUnique(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		path := filepath.Join(dir, name)
+		if i > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return f, path, err
+	}
+}
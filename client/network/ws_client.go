@@ -1,28 +1,38 @@
 package network
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/url"
 
 	"github.com/gorilla/websocket"
 )
 
+// Subprotocol is the protocol version requested from the server
+const Subprotocol = "terminal-chat.v1"
+
 // WSClient handles WebSocket communication with the server
 type WSClient struct {
 	conn      *websocket.Conn
 	serverURL string
 	username  string
+	tlsOpts   TLSOptions
 	incoming  chan []byte
 	outgoing  chan []byte
 	done      chan struct{}
 }
 
-// NewWSClient creates a new WebSocket client
-func NewWSClient(serverURL, username string) *WSClient {
+// NewWSClient creates a new WebSocket client. serverURL may use ws:// or
+// wss://; tlsOpts only applies to wss://.
+func NewWSClient(serverURL, username string, tlsOpts TLSOptions) *WSClient {
 	return &WSClient{
 		serverURL: serverURL,
 		username:  username,
+		tlsOpts:   tlsOpts,
 		incoming:  make(chan []byte, 256),
 		outgoing:  make(chan []byte, 256),
 		done:      make(chan struct{}),
@@ -41,25 +51,35 @@ func (c *WSClient) Connect() error {
 	q.Set("username", c.username)
 	u.RawQuery = q.Encode()
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{Subprotocol}
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		tlsConfig, err := buildTLSConfig(host, c.tlsOpts)
+		if err != nil {
+			return err
+		}
+		dialer.TLSClientConfig = tlsConfig
+	default:
+		return fmt.Errorf("unsupported server URL scheme %q, use ws:// or wss://", u.Scheme)
+	}
+
+	conn, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		return err
 	}
 
 	c.conn = conn
 
-	// Join the default channel
-	joinMsg := map[string]interface{}{
-		"type":    "join_channel",
-		"channel": "general",
-	}
-	if data, err := json.Marshal(joinMsg); err == nil {
-		c.outgoing <- data
-	}
-
-	// Start goroutines
-	go c.readPump()
-	go c.writePump()
+	// Start goroutines; they stop together when either fails
+	connDone := make(chan struct{})
+	go c.readPump(conn, connDone)
+	go c.writePump(conn, connDone)
 
 	return nil
 }
@@ -72,10 +92,12 @@ func (c *WSClient) Close() {
 	}
 }
 
-// SendMessage sends a chat message
-func (c *WSClient) SendMessage(channel, content string) {
+// SendMessage sends a chat message and returns the ID the server will echo it with
+func (c *WSClient) SendMessage(channel, content string) string {
+	id := newMessageID()
 	msg := map[string]interface{}{
 		"type":    "send_message",
+		"id":      id,
 		"channel": channel,
 		"content": content,
 	}
@@ -83,10 +105,18 @@ func (c *WSClient) SendMessage(channel, content string) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
-		return
+		return id
 	}
 
 	c.outgoing <- data
+	return id
+}
+
+// newMessageID returns a random ID for an outgoing message
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // JoinChannel joins a channel
@@ -105,49 +135,105 @@ func (c *WSClient) JoinChannel(channel string) {
 	c.outgoing <- data
 }
 
-// readPump reads messages from the WebSocket connection
-func (c *WSClient) readPump() {
-	defer func() {
-		close(c.incoming)
-	}()
+// RequestHistory asks the server to replay a joined channel's recent messages
+func (c *WSClient) RequestHistory(channel string) {
+	msg := map[string]interface{}{
+		"type":    "get_history",
+		"channel": channel,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling history request: %v", err)
+		return
+	}
+
+	c.outgoing <- data
+}
+
+// RequestProfile asks the server for a user's profile
+func (c *WSClient) RequestProfile(username string) {
+	msg := map[string]interface{}{
+		"type": "get_profile",
+		"data": map[string]interface{}{"username": username},
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling profile request: %v", err)
+		return
+	}
+
+	c.outgoing <- data
+}
+
+// readPump reads messages from the WebSocket connection. When the
+// connection ends it delivers a synthetic "disconnected" event carrying the
+// server's close reason, if any.
+func (c *WSClient) readPump(conn *websocket.Conn, connDone chan struct{}) {
+	defer close(connDone)
 
 	for {
-		select {
-		case <-c.done:
-			return
-		default:
-			_, message, err := c.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
-				}
-				return
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseServiceRestart) {
+				log.Printf("WebSocket error: %v", err)
 			}
+			c.deliver(disconnectedEvent(err))
+			return
+		}
 
-			select {
-			case c.incoming <- message:
-			case <-c.done:
-				return
-			}
+		if !c.deliver(message) {
+			return
+		}
+	}
+}
+
+// deliver queues an incoming message, returning false if the client was closed
+func (c *WSClient) deliver(message []byte) bool {
+	select {
+	case c.incoming <- message:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// disconnectedEvent describes why a connection ended
+func disconnectedEvent(err error) []byte {
+	event := map[string]interface{}{
+		"type":    "disconnected",
+		"content": "connection lost",
+	}
+	if closeErr, ok := err.(*websocket.CloseError); ok {
+		event["data"] = map[string]interface{}{"code": closeErr.Code}
+		if closeErr.Text != "" {
+			event["content"] = closeErr.Text
 		}
 	}
+
+	data, _ := json.Marshal(event)
+	return data
 }
 
 // writePump writes messages to the WebSocket connection
-func (c *WSClient) writePump() {
+func (c *WSClient) writePump(conn *websocket.Conn, connDone chan struct{}) {
 	defer func() {
-		c.conn.Close()
+		conn.Close()
 	}()
 
 	for {
 		select {
 		case message := <-c.outgoing:
-			err := c.conn.WriteMessage(websocket.TextMessage, message)
+			err := conn.WriteMessage(websocket.TextMessage, message)
 			if err != nil {
 				log.Printf("Error writing message: %v", err)
 				return
 			}
 
+		case <-connDone:
+			return
+
 		case <-c.done:
 			return
 		}
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -12,12 +14,64 @@ type Message struct {
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	System    bool      `json:"system,omitempty"` // server notice rather than a user message
+	Bot       bool      `json:"bot,omitempty"`    // sent by a bot account or webhook
+	Action    bool      `json:"action,omitempty"` // posted with /me
+
+	// DisplayName and Color are the sender's chosen name and name color
+	// when the message was sent
+	DisplayName string `json:"display_name,omitempty"`
+	Color       string `json:"color,omitempty"`
+
+	// Attachments are cards posted with the message, such as by a webhook
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Files are uploaded files the message carries
+	Files []File `json:"files,omitempty"`
 }
 
+// File is an uploaded file that can be downloaded from the server
+type File struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MIME     string `json:"mime"`
+	Uploader string `json:"uploader,omitempty"`
+}
+
+// Attachment is a simple card shown below a message
+type Attachment struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Color string `json:"color,omitempty"` // "good", "warning", "danger" or a hex color
+}
+
+// Profile is what a user tells others about themselves, as shown on their
+// profile card
+type Profile struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Pronouns    string `json:"pronouns,omitempty"`
+	StatusEmoji string `json:"status_emoji,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+	Timezone    string `json:"timezone,omitempty"` // IANA name such as Europe/Berlin
+	Color       string `json:"color,omitempty"`    // preferred name color as #rrggbb
+	Online      bool   `json:"online,omitempty"`
+	Bot         bool   `json:"bot,omitempty"`
+}
+
+// DefaultMaxContentLength is the message length limit used until the server advertises its own
+const DefaultMaxContentLength = 500
+
 // Channel represents a chat channel/room
 type Channel struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+
+	// Topic is shown in the channel header; Description is its longer form
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 // ChatState holds the entire application state
@@ -29,6 +83,39 @@ type ChatState struct {
 	Username      string
 	Connected     bool
 	InputBuffer   string
+
+	// MaxContentLength is the message length limit advertised by the server
+	MaxContentLength int
+
+	// MaxFileSize is the upload size limit advertised by the server, or 0
+	// if it has not said
+	MaxFileSize int64
+
+	// DisplayNames holds the current display name of users who set one,
+	// by username
+	DisplayNames map[string]string
+
+	// ShowHandles shows usernames next to display names
+	ShowHandles bool
+
+	// ShowRaw shows messages as their Markdown source instead of rendered
+	ShowRaw bool
+
+	// NameColors holds the name color of users who chose one, by username
+	NameColors map[string]string
+
+	// Selecting is set while picking the message whose author's profile to
+	// open; Selected indexes the active channel's messages
+	Selecting bool
+	Selected  int
+
+	// ChannelFiles holds the files last listed for each channel
+	ChannelFiles map[string][]File
+
+	// PendingProfile names the user whose profile was asked for, and
+	// ProfileCard is the profile shown in place of the messages, if any
+	PendingProfile string
+	ProfileCard    *Profile
 }
 
 // NewChatState creates a new chat state
@@ -40,26 +127,80 @@ func NewChatState(username string) *ChatState {
 			{ID: "random", Name: "random"},
 			{ID: "dev", Name: "dev"},
 		},
-		Messages:    make(map[string][]Message),
-		TypingUsers: make(map[string][]string),
-		Username:    username,
-		Connected:   false,
+		Messages:         make(map[string][]Message),
+		TypingUsers:      make(map[string][]string),
+		DisplayNames:     make(map[string]string),
+		NameColors:       make(map[string]string),
+		ChannelFiles:     make(map[string][]File),
+		Username:         username,
+		Connected:        false,
+		MaxContentLength: DefaultMaxContentLength,
 	}
 }
 
-// AddMessage adds a message to a channel
+// AddMessage adds a message to a channel. Messages with an ID already in
+// the channel are ignored, so server echoes of local messages are not shown twice.
 func (s *ChatState) AddMessage(channelID string, msg Message) {
 	if s.Messages[channelID] == nil {
 		s.Messages[channelID] = []Message{}
 	}
+	if msg.ID != "" && s.hasMessage(channelID, msg.ID) {
+		return
+	}
 	s.Messages[channelID] = append(s.Messages[channelID], msg)
 
 	// Keep only last 1000 messages per channel to prevent memory issues
 	if len(s.Messages[channelID]) > 1000 {
 		s.Messages[channelID] = s.Messages[channelID][1:]
+		if s.Selecting && channelID == s.ActiveChannel {
+			s.Selected--
+		}
 	}
 }
 
+// MergeHistory adds messages replayed by the server, skipping ones already
+// present and keeping the channel ordered by time
+func (s *ChatState) MergeHistory(channelID string, msgs []Message) {
+	added := false
+	for _, msg := range msgs {
+		if msg.ID != "" && s.hasMessage(channelID, msg.ID) {
+			continue
+		}
+		s.Messages[channelID] = append(s.Messages[channelID], msg)
+		added = true
+	}
+	if !added {
+		return
+	}
+
+	sort.SliceStable(s.Messages[channelID], func(i, j int) bool {
+		return s.Messages[channelID][i].Timestamp.Before(s.Messages[channelID][j].Timestamp)
+	})
+	if excess := len(s.Messages[channelID]) - 1000; excess > 0 {
+		s.Messages[channelID] = s.Messages[channelID][excess:]
+	}
+}
+
+// AddSystemMessage adds a notice to the active channel
+func (s *ChatState) AddSystemMessage(content string) {
+	s.AddMessage(s.ActiveChannel, Message{
+		ChannelID: s.ActiveChannel,
+		Content:   content,
+		Timestamp: time.Now(),
+		System:    true,
+	})
+}
+
+// hasMessage reports whether a channel already holds a message with id
+func (s *ChatState) hasMessage(channelID, id string) bool {
+	for _, msg := range s.Messages[channelID] {
+		if msg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // GetMessages returns messages for a channel
 func (s *ChatState) GetMessages(channelID string) []Message {
 	return s.Messages[channelID]
@@ -75,6 +216,191 @@ func (s *ChatState) AddChannel(channel Channel) {
 	s.Channels = append(s.Channels, channel)
 }
 
+// SetChannels replaces the channel list. If the active channel is no longer
+// listed the first channel becomes active and true is returned.
+func (s *ChatState) SetChannels(names []string) bool {
+	channels := make([]Channel, 0, len(names))
+	active := false
+	for _, name := range names {
+		channel := Channel{ID: name, Name: name}
+		if old := s.channel(name); old != nil {
+			channel.Topic, channel.Description = old.Topic, old.Description
+		}
+		channels = append(channels, channel)
+		if name == s.ActiveChannel {
+			active = true
+		}
+	}
+	if len(channels) == 0 {
+		return false
+	}
+
+	s.Channels = channels
+	if !active {
+		s.ActiveChannel = channels[0].ID
+		return true
+	}
+	return false
+}
+
+// SetTopic sets a channel's topic and description
+func (s *ChatState) SetTopic(channelID, topic, description string) {
+	if ch := s.channel(channelID); ch != nil {
+		ch.Topic, ch.Description = topic, description
+	}
+}
+
+// Topic returns a channel's topic, or "" if it has none
+func (s *ChatState) Topic(channelID string) string {
+	if ch := s.channel(channelID); ch != nil {
+		return ch.Topic
+	}
+	return ""
+}
+
+// channel returns the listed channel with id, or nil
+func (s *ChatState) channel(id string) *Channel {
+	for i := range s.Channels {
+		if s.Channels[i].ID == id {
+			return &s.Channels[i]
+		}
+	}
+	return nil
+}
+
+// SetDisplayName records a user's current display name; an empty name
+// clears it
+func (s *ChatState) SetDisplayName(username, name string) {
+	if name == "" {
+		delete(s.DisplayNames, username)
+		return
+	}
+	s.DisplayNames[username] = name
+}
+
+// AuthorName returns the name to show for a message's sender: their current
+// display name, the one the message was sent with, or their username. With
+// ShowHandles set the username follows a display name.
+func (s *ChatState) AuthorName(msg Message) string {
+	name, ok := s.DisplayNames[msg.Username]
+	if !ok {
+		name = msg.DisplayName
+	}
+	if name == "" || name == msg.Username {
+		return msg.Username
+	}
+	if s.ShowHandles {
+		return name + " (@" + msg.Username + ")"
+	}
+	return name
+}
+
+// SetNameColor records a user's current name color; an empty color clears
+// it
+func (s *ChatState) SetNameColor(username, color string) {
+	if color == "" {
+		delete(s.NameColors, username)
+		return
+	}
+	s.NameColors[username] = color
+}
+
+// AuthorColor returns the color to show a message's sender in: their
+// current one or the one the message was sent with. It is "" for the
+// default color.
+func (s *ChatState) AuthorColor(msg Message) string {
+	if color, ok := s.NameColors[msg.Username]; ok {
+		return color
+	}
+	return msg.Color
+}
+
+// UpdateProfile records a user's new profile, refreshing their name, color
+// and open profile card
+func (s *ChatState) UpdateProfile(p Profile) {
+	s.SetDisplayName(p.Username, p.DisplayName)
+	s.SetNameColor(p.Username, p.Color)
+	if s.ProfileCard != nil && s.ProfileCard.Username == p.Username {
+		p.Online, p.Bot = s.ProfileCard.Online, s.ProfileCard.Bot
+		s.ProfileCard = &p
+	}
+}
+
+// StartSelecting begins picking a message in the active channel, starting
+// from the newest one with an author. It reports false if there is none.
+func (s *ChatState) StartSelecting() bool {
+	messages := s.Messages[s.ActiveChannel]
+	for i := len(messages) - 1; i >= 0; i-- {
+		if !messages[i].System && messages[i].Username != "" {
+			s.Selecting, s.Selected = true, i
+			return true
+		}
+	}
+	return false
+}
+
+// MoveSelection moves the selection to the next message with an author in
+// direction delta, staying put at either end
+func (s *ChatState) MoveSelection(delta int) {
+	messages := s.Messages[s.ActiveChannel]
+	for i := s.Selected + delta; i >= 0 && i < len(messages); i += delta {
+		if !messages[i].System && messages[i].Username != "" {
+			s.Selected = i
+			return
+		}
+	}
+}
+
+// SelectedAuthor ends selecting and returns the username of the selected
+// message's author, or "" if the selection is gone
+func (s *ChatState) SelectedAuthor() string {
+	s.Selecting = false
+	messages := s.Messages[s.ActiveChannel]
+	if s.Selected < 0 || s.Selected >= len(messages) {
+		return ""
+	}
+	return messages[s.Selected].Username
+}
+
+// FindFile looks for a file in a channel's messages and last listing by
+// name or by the start of its ID, preferring the newest. An ambiguous ID
+// prefix matches nothing.
+func (s *ChatState) FindFile(channelID, ref string) (File, bool) {
+	var byName, byID []File
+	seen := make(map[string]bool)
+	consider := func(f File) {
+		if seen[f.ID] {
+			return
+		}
+		seen[f.ID] = true
+		if f.Name == ref {
+			byName = append(byName, f)
+		}
+		if len(ref) >= 4 && strings.HasPrefix(f.ID, ref) {
+			byID = append(byID, f)
+		}
+	}
+
+	messages := s.Messages[channelID]
+	for i := len(messages) - 1; i >= 0; i-- {
+		for _, f := range messages[i].Files {
+			consider(f)
+		}
+	}
+	listed := s.ChannelFiles[channelID]
+	for i := len(listed) - 1; i >= 0; i-- {
+		consider(listed[i])
+	}
+
+	switch {
+	case len(byID) == 1:
+		return byID[0], true
+	case len(byName) > 0:
+		return byName[0], true
+	}
+	return File{}, false
+}
+
 // SetTypingUsers sets the typing users for a channel
 func (s *ChatState) SetTypingUsers(channelID string, users []string) {
 	s.TypingUsers[channelID] = users
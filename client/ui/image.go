@@ -0,0 +1,294 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	// Formats previews can be decoded from
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/ansi/iterm2"
+	"github.com/charmbracelet/x/ansi/kitty"
+
+	"terminal-chat/client/state"
+)
+
+// ImageMode is how image attachments are previewed
+type ImageMode int
+
+const (
+	// ImagesOff shows images as a file line only
+	ImagesOff ImageMode = iota
+
+	// ImagesBlocks draws images with half-block characters, two pixels per
+	// cell, in as many colors as the terminal has
+	ImagesBlocks
+
+	// ImagesKitty shows images with the kitty graphics protocol, placed
+	// with Unicode placeholders so they scroll with the text
+	ImagesKitty
+
+	// ImagesITerm2 shows images with the iTerm2 inline image protocol
+	ImagesITerm2
+)
+
+const (
+	// maxPreviewRows bounds the height of a preview in lines
+	maxPreviewRows = 20
+
+	// maxPreviewSource bounds the decoded size kept for previews, in
+	// pixels on each side, which is plenty for the widest terminal
+	maxPreviewSource = 640
+
+	// maxDecodePixels bounds the images decoded for previews
+	maxDecodePixels = 40_000_000
+
+	// cellWidth and cellHeight are a typical cell's size in pixels
+	cellWidth  = 10
+	cellHeight = 20
+
+	previewIndent = "  "
+)
+
+// ParseImageMode reads an -images setting: blocks, inline or off. Inline
+// uses the kitty or iTerm2 protocol when the terminal advertises one, and
+// half-blocks otherwise.
+func ParseImageMode(s string) (ImageMode, error) {
+	switch s {
+	case "blocks":
+		return ImagesBlocks, nil
+	case "inline":
+		return detectInlineImages(), nil
+	case "off":
+		return ImagesOff, nil
+	}
+	return ImagesOff, fmt.Errorf("unknown image mode %q, want blocks, inline or off", s)
+}
+
+// detectInlineImages picks the inline image protocol the terminal says it
+// speaks. Multiplexers get in the way of both, so they get half-blocks.
+func detectInlineImages() ImageMode {
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		return ImagesBlocks
+	case os.Getenv("TERM") == "xterm-kitty" || os.Getenv("KITTY_WINDOW_ID") != "" ||
+		os.Getenv("TERM") == "xterm-ghostty":
+		return ImagesKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("LC_TERMINAL") == "iTerm2" ||
+		os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return ImagesITerm2
+	}
+	return ImagesBlocks
+}
+
+// CanPreview reports whether a file is an image previews can be made of
+func CanPreview(f state.File) bool {
+	switch f.MIME {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// DecodePreview decodes an image for previewing, scaled down to what a
+// terminal can show
+func DecodePreview(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("image is empty")
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return nil, fmt.Errorf("image is too large to preview (%dx%d)", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	w, h := fitSize(config.Width, config.Height, maxPreviewSource, maxPreviewSource)
+	if w == config.Width && h == config.Height {
+		return img, nil
+	}
+	return resize(img, w, h), nil
+}
+
+// preview is a decoded image and what it was last drawn as
+type preview struct {
+	img image.Image
+
+	// cols and rows are the size drawn, in cells
+	cols, rows int
+
+	// lines are the preview's lines of text
+	lines []string
+
+	// seq draws an iTerm2 preview from the end of its last line
+	seq string
+
+	// kittyID is the ID the image was sent to kitty under
+	kittyID int
+}
+
+// previewSize is how many cells an image is shown in, fitting maxCols by
+// maxRows without being enlarged. Cells are about twice as tall as wide.
+func previewSize(img image.Image, maxCols, maxRows int) (int, int) {
+	b := img.Bounds()
+	cols, height := fitSize(b.Dx(), b.Dy(), maxCols, maxRows*2)
+	return cols, max(1, (height+1)/2)
+}
+
+// fitSize scales w by h down to fit within maxW by maxH, keeping the aspect
+// ratio
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	if w > maxW {
+		w, h = maxW, max(1, h*maxW/w)
+	}
+	if h > maxH {
+		w, h = max(1, w*maxH/h), maxH
+	}
+	return w, h
+}
+
+// drawBlocks draws an image cols cells wide and rows high with upper
+// half-blocks: the foreground is the top pixel and the background the
+// bottom one. Lipgloss brings the colors down to what the terminal has.
+func drawBlocks(img image.Image, cols, rows int) []string {
+	small := resize(img, cols, rows*2)
+	lines := make([]string, rows)
+	for y := range rows {
+		var line strings.Builder
+		line.WriteString(previewIndent)
+		for x := range cols {
+			line.WriteString(lipgloss.NewStyle().
+				Foreground(hexColor(small.At(x, y*2))).
+				Background(hexColor(small.At(x, y*2+1))).
+				Render("▀"))
+		}
+		lines[y] = line.String()
+	}
+	return lines
+}
+
+// hexColor converts a pixel, shown over black, to a lipgloss color
+func hexColor(c color.Color) lipgloss.Color {
+	r, g, b, _ := c.RGBA()
+	return lipgloss.Color(fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8))
+}
+
+// transmitKitty sends an image to kitty under id, with a virtual placement
+// of cols by rows cells for placeholders to show
+func transmitKitty(w io.Writer, img image.Image, id, cols, rows int) error {
+	return kitty.EncodeGraphics(w, fitCells(img, cols, rows), &kitty.Options{
+		Action:           kitty.TransmitAndPut,
+		Transmission:     kitty.Direct,
+		Format:           kitty.PNG,
+		ID:               id,
+		Columns:          cols,
+		Rows:             rows,
+		VirtualPlacement: true,
+		Quite:            2,
+		Chunk:            true,
+	})
+}
+
+// drawKitty lays out the Unicode placeholders showing kitty image id. The
+// foreground color carries the ID and each line's first cell its row;
+// kitty works out the rest of the cells from their neighbours.
+func drawKitty(id, cols, rows int) []string {
+	color := fmt.Sprintf("\x1b[38;2;%d;%d;%dm", id>>16&0xff, id>>8&0xff, id&0xff)
+	rest := strings.Repeat(string(kitty.Placeholder), cols-1)
+	lines := make([]string, rows)
+	for y := range rows {
+		lines[y] = previewIndent + color + string(kitty.Placeholder) +
+			string(kitty.Diacritic(y)) + string(kitty.Diacritic(0)) + rest + "\x1b[39m"
+	}
+	return lines
+}
+
+// drawITerm2 returns blank lines to hold an iTerm2 image and the sequence
+// that draws it, written at the end of the last line. The terminal draws
+// from the cursor, so the sequence moves it to the first line and back.
+func drawITerm2(img image.Image, cols, rows int) ([]string, string, error) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, fitCells(img, cols, rows)); err != nil {
+		return nil, "", err
+	}
+	lines := make([]string, rows)
+	for y := range lines {
+		lines[y] = previewIndent + strings.Repeat(" ", cols)
+	}
+
+	var seq strings.Builder
+	seq.WriteString(ansi.SaveCursor)
+	if rows > 1 {
+		seq.WriteString(ansi.CursorUp(rows - 1))
+	}
+	seq.WriteString(ansi.CursorBackward(cols))
+	seq.WriteString(ansi.ITerm2(iterm2.File{
+		Inline:          true,
+		DoNotMoveCursor: true,
+		Width:           iterm2.Cells(cols),
+		Height:          iterm2.Cells(rows),
+		Size:            int64(data.Len()),
+		Content:         []byte(base64.StdEncoding.EncodeToString(data.Bytes())),
+	}))
+	seq.WriteString(ansi.RestoreCursor)
+	return lines, seq.String(), nil
+}
+
+// fitCells scales an image down to about the pixels of cols by rows cells,
+// so inline images are no bigger to send than they are to show
+func fitCells(img image.Image, cols, rows int) image.Image {
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), cols*cellWidth, rows*cellHeight)
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	return resize(img, w, h)
+}
+
+// resize scales an image to w by h pixels, averaging up to four by four
+// source pixels for each one
+func resize(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		y1 = max(y1, y0+1)
+		stepY := max(1, (y1-y0)/4)
+		for x := range w {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			x1 = max(x1, x0+1)
+			stepX := max(1, (x1-x0)/4)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return out
+}
@@ -1,12 +1,27 @@
 package ui
 
 import (
+	"fmt"
+	"unicode/utf8"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 
 	"terminal-chat/client/state"
 )
 
+var (
+	counterStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("240"))
+
+	counterLowStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196"))
+)
+
+// counterWidth is the space reserved next to the input for the remaining-characters counter
+const counterWidth = 7
+
 // Input represents the message input field
 type Input struct {
 	chatState *state.ChatState
@@ -17,7 +32,7 @@ type Input struct {
 func NewInput(chatState *state.ChatState) *Input {
 	ti := textinput.New()
 	ti.Placeholder = "Type your message here..."
-	ti.CharLimit = 500
+	ti.CharLimit = chatState.MaxContentLength
 	ti.Width = 80
 
 	return &Input{
@@ -28,12 +43,26 @@ func NewInput(chatState *state.ChatState) *Input {
 
 // SetSize sets the input dimensions
 func (i *Input) SetSize(width, height int) {
-	i.textinput.Width = width
+	i.textinput.Width = width - counterWidth
 }
 
-// View renders the input field
+// SetCharLimit sets the maximum number of characters that can be typed
+func (i *Input) SetCharLimit(limit int) {
+	i.textinput.CharLimit = limit
+}
+
+// View renders the input field with the remaining-characters counter
 func (i *Input) View() string {
-	return i.textinput.View()
+	limit := i.textinput.CharLimit
+	remaining := limit - utf8.RuneCountInString(i.textinput.Value())
+
+	style := counterStyle
+	if remaining <= limit/10 {
+		style = counterLowStyle
+	}
+	counter := style.Width(counterWidth).Align(lipgloss.Right).Render(fmt.Sprintf("%d", remaining))
+
+	return lipgloss.JoinHorizontal(lipgloss.Top, i.textinput.View(), counter)
 }
 
 // Update updates the input field
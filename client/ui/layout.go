@@ -1,6 +1,9 @@
 package ui
 
 import (
+	"image"
+	"io"
+
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
@@ -54,7 +57,7 @@ func (l *Layout) SetSize(width, height int) {
 
 	// Update component sizes
 	l.sidebar.SetSize(20, height-4)
-	l.messageView.SetSize(width-25, height-4)
+	l.messageView.SetSize(width-27, height-4)
 	l.input.SetSize(width-4, 3)
 }
 
@@ -68,7 +71,7 @@ func (l *Layout) View() string {
 	topRow := lipgloss.JoinHorizontal(
 		lipgloss.Top,
 		sidebarStyle.Render(sidebarView),
-		messageViewStyle.Render(messageView),
+		messageViewStyle.Width(l.messageView.width).Render(messageView),
 	)
 
 	// Combine with input at bottom
@@ -87,6 +90,11 @@ func (l *Layout) UpdateInput(msg tea.Msg) tea.Cmd {
 	return cmd
 }
 
+// SetInputCharLimit sets the maximum message length accepted by the input
+func (l *Layout) SetInputCharLimit(limit int) {
+	l.input.SetCharLimit(limit)
+}
+
 // FocusInput focuses the input field
 func (l *Layout) FocusInput() {
 	l.input.Focus()
@@ -122,6 +130,22 @@ func (l *Layout) UpdateMessageView() {
 	l.messageView.Update()
 }
 
+// SetImageMode sets how image attachments are previewed, with kitty
+// images written to out
+func (l *Layout) SetImageMode(mode ImageMode, out io.Writer) {
+	l.messageView.SetImageMode(mode, out)
+}
+
+// PreviewsImages reports whether image attachments are previewed
+func (l *Layout) PreviewsImages() bool {
+	return l.messageView.ImageMode() != ImagesOff
+}
+
+// SetPreview sets the image previewed below a file
+func (l *Layout) SetPreview(id string, img image.Image) {
+	l.messageView.SetPreview(id, img)
+}
+
 // ScrollMessageViewUp scrolls the message view up
 func (l *Layout) ScrollMessageViewUp() {
 	l.messageView.ScrollUp()
@@ -0,0 +1,277 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+var headingStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("75")).
+	Bold(true)
+
+var codeStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("215")).
+	Background(lipgloss.Color("236"))
+
+var codeBlockStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("252")).
+	Background(lipgloss.Color("236"))
+
+var linkStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("39")).
+	Underline(true)
+
+// mutedStyle is for link targets, list markers, quote bars and rules
+var mutedStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("245"))
+
+// markdown parses CommonMark
+var markdown = goldmark.DefaultParser()
+
+// renderMarkdown renders a message's content as lines at most width cells
+// wide. lead, such as the time and author, starts the first paragraph, or
+// gets a line of its own when the message starts with another kind of
+// block.
+func renderMarkdown(source, lead string, width int) []string {
+	src := []byte(source)
+	doc := markdown.Parse(text.NewReader(src))
+
+	r := &mdRenderer{source: src}
+	_, startsWithParagraph := doc.FirstChild().(*ast.Paragraph)
+	if startsWithParagraph {
+		r.lead = lead
+	}
+	lines := r.blocks(doc, width)
+	if !startsWithParagraph {
+		lines = append([]string{strings.TrimRight(lead, " ")}, lines...)
+	}
+	return lines
+}
+
+// mdRenderer draws a parsed Markdown document as styled lines
+type mdRenderer struct {
+	source []byte
+
+	// lead starts the first paragraph, until it has been drawn
+	lead string
+}
+
+// blocks draws the blocks in n, with blank lines between them except in
+// tight lists
+func (r *mdRenderer) blocks(n ast.Node, width int) []string {
+	tight := false
+	if list, ok := n.Parent().(*ast.List); ok {
+		tight = list.IsTight
+	}
+
+	var lines []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if len(lines) > 0 && !tight {
+			lines = append(lines, "")
+		}
+		lines = append(lines, r.block(c, width)...)
+	}
+	return lines
+}
+
+// block draws one block
+func (r *mdRenderer) block(n ast.Node, width int) []string {
+	switch n := n.(type) {
+	case *ast.Paragraph:
+		s := r.inlines(n, lipgloss.NewStyle())
+		s, r.lead = r.lead+s, ""
+		return wrapLines(s, width)
+
+	case *ast.TextBlock:
+		return wrapLines(r.inlines(n, lipgloss.NewStyle()), width)
+
+	case *ast.Heading:
+		return wrapLines(r.inlines(n, headingStyle), width)
+
+	case *ast.ThematicBreak:
+		return []string{mutedStyle.Render(strings.Repeat("─", max(width, 1)))}
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		return r.code(n, width)
+
+	case *ast.Blockquote:
+		bar := mutedStyle.Render("│ ")
+		lines := r.blocks(n, width-2)
+		for i := range lines {
+			lines[i] = bar + lines[i]
+		}
+		return lines
+
+	case *ast.List:
+		return r.list(n, width)
+
+	case *ast.HTMLBlock:
+		// Shown as written, like any other text
+		var b strings.Builder
+		b.Write(n.Lines().Value(r.source))
+		if n.HasClosure() {
+			b.Write(n.ClosureLine.Value(r.source))
+		}
+		return wrapLines(strings.TrimRight(b.String(), "\n"), width)
+	}
+	return r.blocks(n, width)
+}
+
+// code draws a code block as written, on a background as wide as its
+// longest line. Lines too long for the view are cut rather than wrapped.
+func (r *mdRenderer) code(n ast.Node, width int) []string {
+	var lines []string
+	blockWidth := 0
+	for i := 0; i < n.Lines().Len(); i++ {
+		seg := n.Lines().At(i)
+		line := string(seg.Value(r.source))
+		line = strings.ReplaceAll(strings.TrimRight(line, "\r\n"), "\t", "    ")
+		lines = append(lines, line)
+		blockWidth = max(blockWidth, ansi.StringWidth(line))
+	}
+
+	// A space of padding on each side
+	blockWidth = max(min(blockWidth+2, width), 3)
+	for i, line := range lines {
+		lines[i] = codeBlockStyle.Width(blockWidth).Render(" " + ansi.Truncate(line, blockWidth-2, "…"))
+	}
+	return lines
+}
+
+// list draws a list's items behind their bullets or numbers, with lines
+// after the first indented to line up
+func (r *mdRenderer) list(list *ast.List, width int) []string {
+	var lines []string
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "• "
+		if list.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+
+		if len(lines) > 0 && !list.IsTight {
+			lines = append(lines, "")
+		}
+		itemLines := r.blocks(item, width-len(indent))
+		if len(itemLines) == 0 {
+			itemLines = []string{""}
+		}
+		for i, line := range itemLines {
+			if i == 0 {
+				line = mutedStyle.Render(marker) + line
+			} else if line != "" {
+				line = indent + line
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// inlines draws the inline content of n in style
+func (r *mdRenderer) inlines(n ast.Node, style lipgloss.Style) string {
+	var b strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		b.WriteString(r.inline(c, style))
+	}
+	return b.String()
+}
+
+// inline draws an inline node, with emphasis adding to style
+func (r *mdRenderer) inline(n ast.Node, style lipgloss.Style) string {
+	switch n := n.(type) {
+	case *ast.Text:
+		s := styleWords(style, string(n.Value(r.source)))
+		if n.HardLineBreak() {
+			s += "\n"
+		} else if n.SoftLineBreak() {
+			s += " "
+		}
+		return s
+
+	case *ast.String:
+		return styleWords(style, string(n.Value))
+
+	case *ast.CodeSpan:
+		return styleWords(codeStyle, r.plain(n))
+
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			return r.inlines(n, style.Bold(true))
+		}
+		return r.inlines(n, style.Italic(true))
+
+	case *ast.Link:
+		// The target follows the text unless it is the text
+		label := r.inlines(n, linkStyle.Inherit(style))
+		if url := string(n.Destination); url != r.plain(n) {
+			label += " " + styleWords(mutedStyle, "("+url+")")
+		}
+		return label
+
+	case *ast.AutoLink:
+		return styleWords(linkStyle.Inherit(style), string(n.URL(r.source)))
+
+	case *ast.Image:
+		alt := r.plain(n)
+		if alt == "" {
+			alt = "image"
+		}
+		return styleWords(linkStyle.Inherit(style), alt) + " " + styleWords(mutedStyle, "("+string(n.Destination)+")")
+
+	case *ast.RawHTML:
+		var b strings.Builder
+		for i := 0; i < n.Segments.Len(); i++ {
+			seg := n.Segments.At(i)
+			b.Write(seg.Value(r.source))
+		}
+		return styleWords(style, b.String())
+	}
+	return r.inlines(n, style)
+}
+
+// plain returns the text in n without any styling
+func (r *mdRenderer) plain(n ast.Node) string {
+	var b strings.Builder
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			b.Write(c.Value(r.source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
+
+// styleWords renders s in style a word at a time, so wrapping between words
+// never leaves a style running on into the next line
+func styleWords(style lipgloss.Style, s string) string {
+	var b strings.Builder
+	for _, word := range strings.SplitAfter(s, " ") {
+		if word != "" {
+			b.WriteString(style.Render(word))
+		}
+	}
+	return b.String()
+}
+
+// wrapLines wraps styled text to width cells and splits it into lines
+func wrapLines(s string, width int) []string {
+	return strings.Split(ansi.Wrap(s, max(width, 1), ""), "\n")
+}
@@ -2,20 +2,82 @@ package ui
 
 import (
 	"fmt"
+	"image"
+	"io"
+	"log"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/viewport"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 
 	"terminal-chat/client/state"
 )
 
+var systemMessageStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("214"))
+
+var topicStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("245"))
+
+var botTagStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("63")).
+	Bold(true)
+
+var selectedStyle = lipgloss.NewStyle().Reverse(true)
+
+var fileStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("110"))
+
+var attachmentTitleStyle = lipgloss.NewStyle().Bold(true)
+
+// attachmentColors maps named attachment colors to terminal colors
+var attachmentColors = map[string]lipgloss.Color{
+	"good":    lipgloss.Color("34"),
+	"warning": lipgloss.Color("214"),
+	"danger":  lipgloss.Color("160"),
+}
+
 // MessageView represents the message display area
 type MessageView struct {
 	chatState *state.ChatState
 	viewport  viewport.Model
 	width     int
 	height    int
+
+	// images is how image attachments are previewed
+	images ImageMode
+
+	// imageOut is where kitty images are sent, between frames
+	imageOut io.Writer
+
+	// previews holds the decoded images to preview, by file ID
+	previews map[string]*preview
+
+	// lastImageID is the last kitty image ID handed out
+	lastImageID int
+
+	// rendered caches messages rendered from Markdown, since the view is
+	// drawn far more often than messages change
+	rendered map[renderKey]string
+}
+
+// renderKey is what a message's rendering depends on
+type renderKey struct {
+	content, lead string
+	width         int
+}
+
+// maxRendered bounds the rendered messages cached
+const maxRendered = 2000
+
+// messageIndent starts the lines of a message after its first
+const messageIndent = "  "
+
+// inlineImage is an iTerm2 preview in the message view's content
+type inlineImage struct {
+	line, rows int
+	seq        string
 }
 
 // NewMessageView creates a new message view
@@ -28,9 +90,28 @@ func NewMessageView(chatState *state.ChatState) *MessageView {
 	return &MessageView{
 		chatState: chatState,
 		viewport:  vp,
+		previews:  make(map[string]*preview),
+		rendered:  make(map[renderKey]string),
 	}
 }
 
+// SetImageMode sets how image attachments are previewed. Kitty images are
+// written to out, which must not interleave them with a frame being drawn.
+func (m *MessageView) SetImageMode(mode ImageMode, out io.Writer) {
+	m.images = mode
+	m.imageOut = out
+}
+
+// ImageMode returns how image attachments are previewed
+func (m *MessageView) ImageMode() ImageMode {
+	return m.images
+}
+
+// SetPreview sets the image previewed below a file
+func (m *MessageView) SetPreview(id string, img image.Image) {
+	m.previews[id] = &preview{img: img}
+}
+
 // SetSize sets the message view dimensions
 func (m *MessageView) SetSize(width, height int) {
 	m.width = width
@@ -41,12 +122,25 @@ func (m *MessageView) SetSize(width, height int) {
 
 // View renders the message view
 func (m *MessageView) View() string {
+	if card := m.chatState.ProfileCard; card != nil {
+		m.viewport.SetContent(renderProfileCard(card, m.width))
+		m.viewport.GotoTop()
+		return m.viewport.View()
+	}
+
 	messages := m.chatState.GetMessages(m.chatState.ActiveChannel)
 
 	var content strings.Builder
 
-	// Add channel header
-	content.WriteString(fmt.Sprintf("#%s\n", m.chatState.ActiveChannel))
+	// Add channel header, with the topic cut to fit
+	header := "#" + m.chatState.ActiveChannel
+	if topic := m.chatState.Topic(m.chatState.ActiveChannel); topic != "" {
+		header += topicStyle.Render(" — " + strings.Join(strings.Fields(topic), " "))
+	}
+	if m.width > 0 {
+		header = ansi.Truncate(header, m.width, "…")
+	}
+	content.WriteString(header + "\n")
 
 	// Ensure width is positive before using strings.Repeat
 	if m.width > 0 {
@@ -55,11 +149,57 @@ func (m *MessageView) View() string {
 		content.WriteString("─────────\n") // fallback
 	}
 
+	// Previews fill the width of the view, and at most half its height
+	previewCols := m.width - m.viewport.Style.GetHorizontalFrameSize() - len(previewIndent)
+	previewRows := min(maxPreviewRows, (m.height-m.viewport.Style.GetVerticalFrameSize())/2)
+	var inline []inlineImage
+
+	// Lines after a message's first are indented, which leaves this much
+	// room for its text
+	bodyWidth := m.width - m.viewport.Style.GetHorizontalFrameSize() - len(messageIndent)
+
 	// Add messages
-	for _, msg := range messages {
+	selectedLine := 0
+	for i, msg := range messages {
 		timestamp := msg.Timestamp.Format("15:04")
-		line := fmt.Sprintf("[%s] %s: %s\n", timestamp, msg.Username, msg.Content)
+		author := m.chatState.AuthorName(msg)
+		if color := m.chatState.AuthorColor(msg); color != "" {
+			author = lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render(author)
+		}
+		if m.chatState.Selecting && i == m.chatState.Selected {
+			author = selectedStyle.Render(m.chatState.AuthorName(msg))
+			selectedLine = strings.Count(content.String(), "\n")
+		}
+		lead := fmt.Sprintf("[%s] %s: ", timestamp, author)
+		if msg.Bot {
+			lead = fmt.Sprintf("[%s] %s %s: ", timestamp, botTagStyle.Render("[BOT]"), author)
+		}
+		if msg.Action {
+			lead = fmt.Sprintf("[%s] * %s ", timestamp, author)
+		}
+		line := lead + msg.Content + "\n"
+		if !m.chatState.ShowRaw && bodyWidth > 0 {
+			line = m.renderContent(msg.Content, lead, bodyWidth)
+		}
+		if msg.System {
+			line = systemMessageStyle.Render(fmt.Sprintf("[%s] * %s", timestamp, msg.Content)) + "\n"
+		}
 		content.WriteString(line)
+		for _, a := range msg.Attachments {
+			content.WriteString(renderAttachment(a))
+		}
+		for _, f := range msg.Files {
+			content.WriteString(fileStyle.Render("  📎 "+FileSummary(f)) + "\n")
+			p := m.previews[f.ID]
+			if p == nil || m.images == ImagesOff || previewCols <= 0 || previewRows <= 0 {
+				continue
+			}
+			m.drawPreview(p, previewCols, previewRows)
+			if p.seq != "" {
+				inline = append(inline, inlineImage{line: strings.Count(content.String(), "\n"), rows: p.rows, seq: p.seq})
+			}
+			content.WriteString(strings.Join(p.lines, "\n") + "\n")
+		}
 	}
 
 	// Add typing indicator
@@ -70,12 +210,134 @@ func (m *MessageView) View() string {
 
 	m.viewport.SetContent(content.String())
 
-	// Auto-scroll to bottom for new messages
+	// Auto-scroll to bottom for new messages, or keep a selected message in
+	// view
 	m.viewport.GotoBottom()
+	if m.chatState.Selecting && selectedLine < m.viewport.YOffset {
+		m.viewport.SetYOffset(selectedLine)
+	}
+	if len(inline) > 0 {
+		m.placeInlineImages(content.String(), inline)
+	}
 
 	return m.viewport.View()
 }
 
+// renderContent renders a message's Markdown after lead, with the lines
+// after the first indented
+func (m *MessageView) renderContent(content, lead string, width int) string {
+	key := renderKey{content: content, lead: lead, width: width}
+	rendered, ok := m.rendered[key]
+	if !ok {
+		if len(m.rendered) >= maxRendered {
+			clear(m.rendered)
+		}
+		rendered = strings.Join(renderMarkdown(content, lead, width), "\n"+messageIndent) + "\n"
+		m.rendered[key] = rendered
+	}
+	return rendered
+}
+
+// drawPreview draws an image to fit maxCols by maxRows cells, unless it
+// already has been
+func (m *MessageView) drawPreview(p *preview, maxCols, maxRows int) {
+	cols, rows := previewSize(p.img, maxCols, maxRows)
+	if p.lines != nil && cols == p.cols && rows == p.rows {
+		return
+	}
+	p.cols, p.rows, p.seq = cols, rows, ""
+
+	switch {
+	case m.images == ImagesKitty && m.imageOut != nil:
+		// The image keeps its ID when redrawn at another size, replacing
+		// the one kitty has
+		if p.kittyID == 0 {
+			m.lastImageID = m.lastImageID%0xffffff + 1
+			p.kittyID = m.lastImageID
+		}
+		err := transmitKitty(m.imageOut, p.img, p.kittyID, cols, rows)
+		if err == nil {
+			p.lines = drawKitty(p.kittyID, cols, rows)
+			return
+		}
+		log.Printf("Sending image to the terminal: %v", err)
+	case m.images == ImagesITerm2:
+		lines, seq, err := drawITerm2(p.img, cols, rows)
+		if err == nil {
+			p.lines, p.seq = lines, seq
+			return
+		}
+		log.Printf("Encoding image: %v", err)
+	}
+	p.lines = drawBlocks(p.img, cols, rows)
+}
+
+// placeInlineImages adds the sequences drawing iTerm2 previews to those
+// wholly in view. One cut off at the top or bottom would draw over the
+// border.
+func (m *MessageView) placeInlineImages(content string, images []inlineImage) {
+	top := m.viewport.YOffset
+	bottom := top + m.viewport.Height - m.viewport.Style.GetVerticalFrameSize()
+	lines := strings.Split(content, "\n")
+	for _, img := range images {
+		last := img.line + img.rows - 1
+		if img.line >= top && last < bottom {
+			lines[last] += img.seq
+		}
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+}
+
+// renderAttachment draws an attachment as indented lines behind a bar in
+// the attachment's color
+func renderAttachment(a state.Attachment) string {
+	color, ok := attachmentColors[a.Color]
+	if !ok && strings.HasPrefix(a.Color, "#") {
+		color = lipgloss.Color(a.Color)
+	}
+	if color == "" {
+		color = lipgloss.Color("240")
+	}
+	bar := lipgloss.NewStyle().Foreground(color).Render("  │ ")
+
+	var lines []string
+	if a.Title != "" {
+		lines = append(lines, attachmentTitleStyle.Render(a.Title))
+	}
+	if a.URL != "" {
+		lines = append(lines, a.URL)
+	}
+	if a.Text != "" {
+		lines = append(lines, strings.Split(a.Text, "\n")...)
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(bar + line + "\n")
+	}
+	return b.String()
+}
+
+// FileSummary describes a file on one line: its name, size, type and the
+// start of its ID, which /download accepts
+func FileSummary(f state.File) string {
+	return fmt.Sprintf("%s · %s · %s · %s", f.Name, FormatSize(f.Size), f.MIME, f.ID[:min(len(f.ID), 8)])
+}
+
+// FormatSize formats a byte count for people, such as 1.5 MB
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // Update updates the message view content
 func (m *MessageView) Update() {
 	// Content will be updated in View()
@@ -0,0 +1,64 @@
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"terminal-chat/client/state"
+)
+
+var profileCardStyle = lipgloss.NewStyle().
+	BorderStyle(lipgloss.RoundedBorder()).
+	BorderForeground(lipgloss.Color("240")).
+	Padding(0, 1)
+
+var (
+	onlineStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("34"))
+	offlineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+)
+
+// renderProfileCard draws a user's profile: their name in their color, the
+// username, pronouns, status, local time and whether they are online
+func renderProfileCard(p *state.Profile, width int) string {
+	name := p.DisplayName
+	if name == "" {
+		name = p.Username
+	}
+	nameStyle := lipgloss.NewStyle().Bold(true)
+	if p.Color != "" {
+		nameStyle = nameStyle.Foreground(lipgloss.Color(p.Color))
+	}
+
+	title := nameStyle.Render(name)
+	if p.Bot {
+		title += " " + botTagStyle.Render("[BOT]")
+	}
+	lines := []string{title, topicStyle.Render("@" + p.Username)}
+	if p.Pronouns != "" {
+		lines = append(lines, p.Pronouns)
+	}
+	if status := strings.TrimSpace(p.StatusEmoji + " " + p.StatusText); status != "" {
+		lines = append(lines, "", status)
+	}
+	lines = append(lines, "")
+
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			lines = append(lines, "Local time  "+time.Now().In(loc).Format("Mon 15:04")+topicStyle.Render(" ("+p.Timezone+")"))
+		}
+	}
+	if p.Online {
+		lines = append(lines, "Presence    "+onlineStyle.Render("● Online"))
+	} else {
+		lines = append(lines, "Presence    "+offlineStyle.Render("○ Offline"))
+	}
+	lines = append(lines, "", topicStyle.Render("Esc to close"))
+
+	card := profileCardStyle
+	if width > 4 {
+		card = card.MaxWidth(width)
+	}
+	return card.Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,573 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"terminal-chat/server/bots"
+	"terminal-chat/server/hub"
+	"terminal-chat/server/models"
+	"terminal-chat/server/storage"
+	"terminal-chat/server/webhook"
+)
+
+const (
+	// maxBodySize bounds admin request bodies
+	maxBodySize = 64 * 1024
+
+	// tailBufferSize is how many events a tail may fall behind before it is ended
+	tailBufferSize = 1024
+
+	// tailKeepalive is how often an idle tail sends a blank line, so dead
+	// connections are noticed
+	tailKeepalive = 30 * time.Second
+
+	// deadLetterLimit is how many dead letters are listed by default
+	deadLetterLimit = 100
+)
+
+// api serves the admin REST endpoints for one hub
+type api struct {
+	hub      *hub.Hub
+	store    *storage.FileStore
+	tokens   *Tokens
+	webhooks *webhook.Dispatcher
+	incoming *webhook.Incoming
+	bots     *bots.Registry
+}
+
+// NewAPI returns the admin REST API. It does no authentication itself and
+// must be wrapped with RequireToken.
+func NewAPI(h *hub.Hub, store *storage.FileStore, tokens *Tokens, webhooks *webhook.Dispatcher, incoming *webhook.Incoming, registry *bots.Registry) http.Handler {
+	a := &api{hub: h, store: store, tokens: tokens, webhooks: webhooks, incoming: incoming, bots: registry}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/sessions", a.listSessions)
+	mux.HandleFunc("POST /admin/sessions/{id}/disconnect", a.disconnectSession)
+	mux.HandleFunc("GET /admin/users", a.listUsers)
+	mux.HandleFunc("GET /admin/channels", a.listChannels)
+	mux.HandleFunc("POST /admin/channels", a.createChannel)
+	mux.HandleFunc("GET /admin/channels/{id}", a.getChannel)
+	mux.HandleFunc("POST /admin/channels/{id}/archive", a.archiveChannel)
+	mux.HandleFunc("GET /admin/channels/{id}/history", a.channelHistory)
+	mux.HandleFunc("GET /admin/channels/{id}/tail", a.tailChannel)
+	mux.HandleFunc("POST /admin/announcements", a.announce)
+	mux.HandleFunc("GET /admin/bans", a.listBans)
+	mux.HandleFunc("POST /admin/bans", a.ban)
+	mux.HandleFunc("DELETE /admin/bans/{username}", a.unban)
+	mux.HandleFunc("POST /admin/token/rotate", a.rotateToken)
+	mux.HandleFunc("GET /admin/backups", a.listBackups)
+	mux.HandleFunc("POST /admin/backups", a.createBackup)
+	mux.HandleFunc("GET /admin/backups/{name}", a.downloadBackup)
+	mux.HandleFunc("GET /admin/webhooks", a.listWebhooks)
+	mux.HandleFunc("POST /admin/webhooks", a.createWebhook)
+	mux.HandleFunc("GET /admin/webhooks/dead-letters", a.deadLetters)
+	mux.HandleFunc("GET /admin/webhooks/{id}", a.getWebhook)
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", a.deleteWebhook)
+	mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", a.webhookDeliveries)
+	mux.HandleFunc("GET /admin/incoming-webhooks", a.listIncoming)
+	mux.HandleFunc("POST /admin/incoming-webhooks", a.createIncoming)
+	mux.HandleFunc("DELETE /admin/incoming-webhooks/{id}", a.deleteIncoming)
+	mux.HandleFunc("GET /admin/bots", a.listBots)
+	mux.HandleFunc("POST /admin/bots", a.createBot)
+	mux.HandleFunc("DELETE /admin/bots/{name}", a.deleteBot)
+	mux.HandleFunc("POST /admin/bots/{name}/token", a.rotateBotToken)
+	mux.HandleFunc("GET /admin/commands", a.listCommands)
+	return mux
+}
+
+func (a *api) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := a.hub.Sessions()
+	if sessions == nil {
+		sessions = []hub.SessionStats{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (a *api) disconnectSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &req, true) {
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "disconnected by an administrator"
+	}
+
+	if !a.hub.Disconnect(r.PathValue("id"), req.Reason) {
+		writeError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) listUsers(w http.ResponseWriter, r *http.Request) {
+	users := a.hub.ListUsers()
+	if users == nil {
+		users = []hub.UserInfo{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (a *api) listChannels(w http.ResponseWriter, r *http.Request) {
+	channels := a.hub.ListChannels()
+	if channels == nil {
+		channels = []hub.ChannelInfo{}
+	}
+	writeJSON(w, http.StatusOK, channels)
+}
+
+func (a *api) getChannel(w http.ResponseWriter, r *http.Request) {
+	info, err := a.hub.Channel(r.PathValue("id"))
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *api) createChannel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+
+	ch, err := a.hub.CreateChannel(req.ID, req.Name)
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ch)
+}
+
+func (a *api) archiveChannel(w http.ResponseWriter, r *http.Request) {
+	if err := a.hub.ArchiveChannel(r.PathValue("id")); err != nil {
+		writeHubError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) channelHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := a.hub.History(r.PathValue("id"))
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// tailChannel streams a channel's events as newline-delimited JSON until the
+// client goes away, starting with the last ?backlog= messages (default 10)
+func (a *api) tailChannel(w http.ResponseWriter, r *http.Request) {
+	backlog := 10
+	if v := r.URL.Query().Get("backlog"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "backlog must be a non-negative number")
+			return
+		}
+		backlog = n
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	o, stop, err := a.hub.Watch(r.PathValue("id"), backlog, tailBufferSize)
+	if err != nil {
+		writeHubError(w, err)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(tailKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-o.Ready():
+			batch := o.Drain()
+			for _, msg := range batch.Messages {
+				if _, err := w.Write(append(msg, '\n')); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+			if batch.Closed {
+				return
+			}
+
+		case <-keepalive.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *api) announce(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content must not be empty")
+		return
+	}
+
+	sent := a.hub.Announce(req.Content)
+	writeJSON(w, http.StatusOK, map[string]int{"sessions": sent})
+}
+
+func (a *api) listBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.hub.Bans())
+}
+
+func (a *api) ban(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Reason   string `json:"reason"`
+
+		// Duration such as "24h"; empty bans permanently
+		Duration string `json:"duration"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+	if req.Username == "" {
+		writeError(w, http.StatusBadRequest, "username must not be empty")
+		return
+	}
+
+	ban := models.Ban{
+		Username:  req.Username,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "duration must be a positive Go duration such as 24h")
+			return
+		}
+		ban.ExpiresAt = ban.CreatedAt.Add(d)
+	}
+
+	kicked := a.hub.Ban(ban)
+	writeJSON(w, http.StatusCreated, struct {
+		models.Ban
+		Disconnected int `json:"disconnected"`
+	}{ban, kicked})
+}
+
+func (a *api) unban(w http.ResponseWriter, r *http.Request) {
+	if !a.hub.Unban(r.PathValue("username")) {
+		writeError(w, http.StatusNotFound, "user is not banned")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) rotateToken(w http.ResponseWriter, r *http.Request) {
+	token, err := a.tokens.Rotate()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Warn("Admin token rotated", "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+func (a *api) listBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := a.store.Backups()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+func (a *api) createBackup(w http.ResponseWriter, r *http.Request) {
+	backup, err := a.store.SaveBackup(a.hub.Snapshot())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info("Saved backup", "name", backup.Name, "bytes", backup.Size)
+	writeJSON(w, http.StatusCreated, backup)
+}
+
+func (a *api) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	f, err := a.store.OpenBackup(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such backup")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, f)
+}
+
+func (a *api) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.webhooks.List())
+}
+
+func (a *api) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL      string   `json:"url"`
+		Secret   string   `json:"secret"`
+		Channels []string `json:"channels"`
+		Events   []string `json:"events"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+
+	sub, err := a.webhooks.Create(webhook.Subscription{
+		URL:      req.URL,
+		Secret:   req.Secret,
+		Channels: req.Channels,
+		Events:   req.Events,
+	})
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	slog.Info("Webhook registered", "webhook", sub.ID, "url", sub.URL)
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (a *api) getWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, err := a.webhooks.Get(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (a *api) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := a.webhooks.Delete(r.PathValue("id")); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	slog.Info("Webhook deleted", "webhook", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := a.webhooks.Deliveries(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// deadLetters lists the most recent failed deliveries, at most ?limit=
+// (default 100)
+func (a *api) deadLetters(w http.ResponseWriter, r *http.Request) {
+	limit := deadLetterLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	letters, err := a.webhooks.DeadLetters(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
+
+// listIncoming lists incoming webhooks, only those for ?channel= if given
+func (a *api) listIncoming(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.incoming.List(r.URL.Query().Get("channel")))
+}
+
+func (a *api) createIncoming(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Channel string `json:"channel"`
+		Name    string `json:"name"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+
+	hook, token, err := a.incoming.Create(req.Channel, req.Name)
+	if err != nil {
+		if errors.Is(err, webhook.ErrInvalid) {
+			writeWebhookError(w, err)
+		} else {
+			writeHubError(w, err)
+		}
+		return
+	}
+	slog.Info("Incoming webhook created", "webhook", hook.ID, "channel", hook.Channel)
+	writeJSON(w, http.StatusCreated, struct {
+		webhook.IncomingHook
+		Token string `json:"token"`
+		Path  string `json:"path"`
+	}{hook, token, "/hooks/" + hook.ID + "/" + token})
+}
+
+func (a *api) deleteIncoming(w http.ResponseWriter, r *http.Request) {
+	if err := a.incoming.Delete(r.PathValue("id")); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	slog.Info("Incoming webhook deleted", "webhook", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) listBots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.bots.List())
+}
+
+func (a *api) createBot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if !readJSON(w, r, &req, false) {
+		return
+	}
+
+	bot, token, err := a.bots.Create(req.Name, req.Description)
+	if err != nil {
+		writeBotError(w, err)
+		return
+	}
+	slog.Info("Bot created", "bot", bot.Name)
+	writeJSON(w, http.StatusCreated, struct {
+		bots.Bot
+		Token string `json:"token"`
+	}{bot, token})
+}
+
+// deleteBot removes a bot account and disconnects its sessions
+func (a *api) deleteBot(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.bots.Delete(name); err != nil {
+		writeBotError(w, err)
+		return
+	}
+	kicked := a.hub.DisconnectBot(name, "bot account deleted")
+	slog.Info("Bot deleted", "bot", name, "disconnected", kicked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) rotateBotToken(w http.ResponseWriter, r *http.Request) {
+	token, err := a.bots.RotateToken(r.PathValue("name"))
+	if err != nil {
+		writeBotError(w, err)
+		return
+	}
+	kicked := a.hub.DisconnectBot(r.PathValue("name"), "bot token rotated")
+	slog.Warn("Bot token rotated", "bot", r.PathValue("name"), "disconnected", kicked, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, struct {
+		Token        string `json:"token"`
+		Disconnected int    `json:"disconnected"`
+	}{token, kicked})
+}
+
+func (a *api) listCommands(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.hub.Commands())
+}
+
+// readJSON decodes the request body into v, answering 400 on failure. An
+// empty body is accepted when optional is set.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil || (optional && errors.Is(err, io.EOF)) {
+		return true
+	}
+	writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+	return false
+}
+
+// writeHubError maps hub errors to HTTP statuses
+func writeHubError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, hub.ErrNoChannel):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, hub.ErrChannelExists), errors.Is(err, hub.ErrChannelArchived):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, hub.ErrInvalidChannel):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeWebhookError maps webhook errors to HTTP statuses
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, webhook.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeBotError maps bot registry errors to HTTP statuses
+func writeBotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, bots.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, bots.ErrExists):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, bots.ErrInvalidName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeError answers with a JSON error body
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// writeJSON answers with v encoded as JSON
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
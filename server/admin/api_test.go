@@ -0,0 +1,309 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"terminal-chat/server/chatsdk"
+	"terminal-chat/server/chatsdk/bot/bottest"
+	"terminal-chat/server/storage"
+	"terminal-chat/server/webhook"
+)
+
+const (
+	timeout = 5 * time.Second
+
+	// adminToken is the configured token of the test API
+	adminToken = "admin-secret"
+)
+
+// testAPI is the admin API of a bottest server, behind RequireToken
+type testAPI struct {
+	http.Handler
+	srv *bottest.Server
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	srv := bottest.NewServer(t)
+	dir := t.TempDir()
+	store, err := storage.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewTokens(adminToken, filepath.Join(dir, "admin_token.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := webhook.NewDispatcher(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(webhooks.Close)
+	incoming, err := webhook.NewIncoming(srv.Hub, store, 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAPI{
+		Handler: RequireToken(tokens, NewAPI(srv.Hub, store, tokens, webhooks, incoming, srv.Bots)),
+		srv:     srv,
+	}
+}
+
+// do makes a request with the admin token and returns the response
+func (a *testAPI) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return a.doAs(t, "Bearer "+adminToken, method, path, body)
+}
+
+// doAs makes a request with the given Authorization header, if any
+func (a *testAPI) doAs(t *testing.T, authorization, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+// decode checks the response status and decodes its JSON body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+	}
+}
+
+// session is a client of the test server that records announcements and
+// disconnects
+type session struct {
+	*chatsdk.Client
+	announcements chan string
+	disconnects   chan chatsdk.Disconnected
+}
+
+// connect connects a user, or a bot when token is set
+func (a *testAPI) connect(t *testing.T, username, token string) *session {
+	t.Helper()
+	s := &session{
+		Client: chatsdk.New(chatsdk.Options{
+			URL:         a.srv.URL,
+			Username:    username,
+			Token:       token,
+			Channels:    []string{"general"},
+			NoReconnect: true,
+		}),
+		announcements: make(chan string, 16),
+		disconnects:   make(chan chatsdk.Disconnected, 1),
+	}
+	s.OnAnnouncement(func(content string) { s.announcements <- content })
+	s.OnDisconnect(func(d chatsdk.Disconnected) { s.disconnects <- d })
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Connect(ctx); err != nil {
+		t.Fatalf("connecting %s: %v", username, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// disconnected waits for the server to close the session with code
+func (s *session) disconnected(t *testing.T, code int) {
+	t.Helper()
+	select {
+	case d := <-s.disconnects:
+		if d.Code != code {
+			t.Errorf("disconnected with %+v, want code %d", d, code)
+		}
+	case <-time.After(timeout):
+		t.Fatal("session still connected")
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	a := newTestAPI(t)
+	for _, c := range []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", "Basic " + adminToken, http.StatusUnauthorized},
+		{"right token", "Bearer " + adminToken, http.StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := a.doAs(t, c.authorization, "GET", "/admin/sessions", "")
+			if w.Code != c.status {
+				t.Errorf("status = %d, want %d", w.Code, c.status)
+			}
+			if c.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+
+	// After rotating, only the new token is accepted
+	var rotated struct {
+		Token string `json:"token"`
+	}
+	decode(t, a.do(t, "POST", "/admin/token/rotate", ""), http.StatusOK, &rotated)
+	if w := a.do(t, "GET", "/admin/sessions", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token: status = %d", w.Code)
+	}
+	if w := a.doAs(t, "Bearer "+rotated.Token, "GET", "/admin/sessions", ""); w.Code != http.StatusOK {
+		t.Errorf("new token: status = %d", w.Code)
+	}
+}
+
+func TestRequireTokenDisabled(t *testing.T) {
+	tokens, err := NewTokens("", filepath.Join(t.TempDir(), "admin_token.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := RequireToken(tokens, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request let through without a configured token")
+	}))
+	r := httptest.NewRequest("GET", "/admin/sessions", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestBan(t *testing.T) {
+	a := newTestAPI(t)
+	alice := a.connect(t, "alice", "")
+
+	for _, body := range []string{`{"reason":"spam"}`, `{"username":"alice","duration":"soon"}`, `{"username":"alice","duration":"-1h"}`, `{"user":"alice"}`} {
+		if w := a.do(t, "POST", "/admin/bans", body); w.Code != http.StatusBadRequest {
+			t.Errorf("banning with %s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	var ban struct {
+		Username     string    `json:"username"`
+		Reason       string    `json:"reason"`
+		ExpiresAt    time.Time `json:"expires_at"`
+		Disconnected int       `json:"disconnected"`
+	}
+	decode(t, a.do(t, "POST", "/admin/bans", `{"username":"alice","reason":"spam","duration":"1h"}`), http.StatusCreated, &ban)
+	if ban.Username != "alice" || ban.Reason != "spam" || ban.Disconnected != 1 || time.Until(ban.ExpiresAt) <= 59*time.Minute {
+		t.Errorf("ban = %+v", ban)
+	}
+	alice.disconnected(t, chatsdk.CloseBanned)
+
+	var bans []struct {
+		Username string `json:"username"`
+	}
+	decode(t, a.do(t, "GET", "/admin/bans", ""), http.StatusOK, &bans)
+	if len(bans) != 1 || bans[0].Username != "alice" {
+		t.Errorf("bans = %+v", bans)
+	}
+
+	if w := a.do(t, "DELETE", "/admin/bans/alice", ""); w.Code != http.StatusNoContent {
+		t.Errorf("unban: status = %d", w.Code)
+	}
+	if w := a.do(t, "DELETE", "/admin/bans/alice", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unban again: status = %d", w.Code)
+	}
+	a.connect(t, "alice", "")
+}
+
+func TestAnnounce(t *testing.T) {
+	a := newTestAPI(t)
+	sessions := []*session{a.connect(t, "alice", ""), a.connect(t, "bob", "")}
+
+	for _, body := range []string{``, `{"content":"  "}`, `{"text":"hello"}`} {
+		if w := a.do(t, "POST", "/admin/announcements", body); w.Code != http.StatusBadRequest {
+			t.Errorf("announcing %q: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	var sent struct {
+		Sessions int `json:"sessions"`
+	}
+	decode(t, a.do(t, "POST", "/admin/announcements", `{"content":"restarting at noon"}`), http.StatusOK, &sent)
+	if sent.Sessions != 2 {
+		t.Errorf("sent to %d sessions, want 2", sent.Sessions)
+	}
+	for _, s := range sessions {
+		select {
+		case content := <-s.announcements:
+			if content != "restarting at noon" {
+				t.Errorf("announcement = %q", content)
+			}
+		case <-time.After(timeout):
+			t.Fatal("announcement not received")
+		}
+	}
+}
+
+func TestBots(t *testing.T) {
+	a := newTestAPI(t)
+
+	var created struct {
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	decode(t, a.do(t, "POST", "/admin/bots", `{"name":"deploybot","description":"Deploys"}`), http.StatusCreated, &created)
+	if created.Name != "deploybot" || created.Token == "" {
+		t.Fatalf("created = %+v", created)
+	}
+	if w := a.do(t, "POST", "/admin/bots", `{"name":"DeployBot"}`); w.Code != http.StatusConflict {
+		t.Errorf("creating a taken name: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := a.do(t, "POST", "/admin/bots", `{"name":"deploy bot"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("creating an invalid name: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var listed []struct {
+		Name string `json:"name"`
+	}
+	decode(t, a.do(t, "GET", "/admin/bots", ""), http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].Name != "deploybot" {
+		t.Errorf("bots = %+v", listed)
+	}
+
+	// Rotating the token disconnects the bot's sessions
+	bot := a.connect(t, "", created.Token)
+	var rotated struct {
+		Token        string `json:"token"`
+		Disconnected int    `json:"disconnected"`
+	}
+	decode(t, a.do(t, "POST", "/admin/bots/deploybot/token", ""), http.StatusOK, &rotated)
+	if rotated.Token == "" || rotated.Token == created.Token || rotated.Disconnected != 1 {
+		t.Errorf("rotated = %+v", rotated)
+	}
+	bot.disconnected(t, chatsdk.CloseKicked)
+
+	// So does deleting it
+	bot = a.connect(t, "", rotated.Token)
+	if w := a.do(t, "DELETE", "/admin/bots/deploybot", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	bot.disconnected(t, chatsdk.CloseKicked)
+	for _, c := range []struct{ method, path string }{
+		{"DELETE", "/admin/bots/deploybot"},
+		{"POST", "/admin/bots/deploybot/token"},
+	} {
+		if w := a.do(t, c.method, c.path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s after deleting: status = %d, want %d", c.method, c.path, w.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package admin serves the operator-only HTTP endpoints.
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Tokens holds the admin token. The token starts as the configured one;
+// once rotated, the new token's SHA-256 hash is kept in a file and takes
+// precedence over the configuration.
+type Tokens struct {
+	path string
+
+	mu   sync.RWMutex
+	hash []byte
+}
+
+// NewTokens loads a rotated token hash from path, falling back to the
+// configured token. With neither, the admin endpoints are disabled until a
+// token is configured.
+func NewTokens(configured, path string) (*Tokens, error) {
+	t := &Tokens{path: path}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		hash, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("admin token hash %s is corrupt", path)
+		}
+		t.hash = hash
+		slog.Info("Using rotated admin token", "file", path)
+	case errors.Is(err, os.ErrNotExist):
+		if configured != "" {
+			sum := sha256.Sum256([]byte(configured))
+			t.hash = sum[:]
+		}
+	default:
+		return nil, fmt.Errorf("reading admin token hash: %w", err)
+	}
+	return t, nil
+}
+
+// Enabled reports whether an admin token is set
+func (t *Tokens) Enabled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.hash != nil
+}
+
+// Check reports whether token is the current admin token
+func (t *Tokens) Check(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.hash == nil {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(sum[:], t.hash) == 1
+}
+
+// Rotate replaces the admin token with a new random one and returns it.
+// The previous token stops working immediately.
+func (t *Tokens) Rotate() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(token))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(hex.EncodeToString(sum[:])+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("saving admin token hash: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return "", fmt.Errorf("saving admin token hash: %w", err)
+	}
+	t.hash = sum[:]
+	return token, nil
+}
+
+// RequireToken only lets requests carrying "Authorization: Bearer <token>"
+// through to next. With no token configured the endpoint is disabled.
+func RequireToken(tokens *Tokens, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokens.Enabled() {
+			http.NotFound(w, r)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !tokens.Check(given) {
+			slog.Warn("Rejected admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
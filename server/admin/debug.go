@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+
+	"terminal-chat/server/hub"
+)
+
+// channelDebug describes one channel in the /debug/hub snapshot
+type channelDebug struct {
+	ID          string   `json:"id"`
+	Subscribers int      `json:"subscribers"`
+	Users       []string `json:"users"`
+}
+
+// hubDebug is the /debug/hub snapshot
+type hubDebug struct {
+	Draining bool           `json:"draining"`
+	Sessions int            `json:"sessions"`
+	Channels []channelDebug `json:"channels"`
+}
+
+// DebugHub serves a snapshot of the hub's channels and their local subscribers
+func DebugHub(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := hubDebug{
+			Draining: h.Draining(),
+			Sessions: len(h.Sessions()),
+			Channels: []channelDebug{},
+		}
+
+		channelIDs := h.Channels()
+		sort.Strings(channelIDs)
+		for _, channelID := range channelIDs {
+			clients := h.GetClientsInChannel(channelID)
+			ch := channelDebug{ID: channelID, Subscribers: len(clients), Users: []string{}}
+			for _, client := range clients {
+				ch.Users = append(ch.Users, client.Username)
+			}
+			sort.Strings(ch.Users)
+			snap.Channels = append(snap.Channels, ch)
+		}
+
+		writeJSON(w, http.StatusOK, snap)
+	}
+}
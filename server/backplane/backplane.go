@@ -0,0 +1,37 @@
+// Package backplane shares channel events between server nodes. Only the
+// events are shared; the state behind them is local to each node. The
+// channel list, topics and descriptions, profiles, bans and bots are kept
+// and persisted by each node on its own, so a topic set on one node is
+// announced to members on the others but not stored there, and operators
+// make catalog, ban and bot changes on every node.
+package backplane
+
+import "terminal-chat/server/models"
+
+// Envelope carries a channel event between server nodes
+type Envelope struct {
+	// Origin is the ID of the node the event was first received on
+	Origin string       `json:"origin"`
+	Event  models.Event `json:"event"`
+}
+
+// Backplane connects hubs on different nodes. A hub publishes every channel
+// event that originates locally and fans out the events it receives, so
+// clients connected to different nodes share channels, presence and typing.
+type Backplane interface {
+	// NodeID identifies this node in published envelopes
+	NodeID() string
+
+	// Publish sends an event to every other node. It must not block; events
+	// that cannot be queued are dropped and logged.
+	Publish(event models.Event)
+
+	// Messages delivers events published by other nodes
+	Messages() <-chan Envelope
+
+	// Close disconnects from the other nodes
+	Close() error
+}
+
+// inboxSize is the number of received envelopes buffered per node
+const inboxSize = 1024
@@ -0,0 +1,92 @@
+package backplane
+
+import (
+	"testing"
+	"time"
+
+	"terminal-chat/server/models"
+)
+
+const timeout = 5 * time.Second
+
+// receive returns the next envelope delivered to bp
+func receive(t *testing.T, bp Backplane) Envelope {
+	t.Helper()
+	select {
+	case env := <-bp.Messages():
+		return env
+	case <-time.After(timeout):
+		t.Fatalf("%s: no envelope received", bp.NodeID())
+		return Envelope{}
+	}
+}
+
+// expectNone checks that nothing is waiting for bp
+func expectNone(t *testing.T, bp Backplane) {
+	t.Helper()
+	select {
+	case env := <-bp.Messages():
+		t.Errorf("%s received %+v", bp.NodeID(), env)
+	default:
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	a, b, c := bus.Join("a"), bus.Join("b"), bus.Join("c")
+
+	a.Publish(models.Event{Type: "send_message", Channel: "general", Content: "hello"})
+	for _, bp := range []Backplane{b, c} {
+		if env := receive(t, bp); env.Origin != "a" || env.Event.Content != "hello" {
+			t.Errorf("%s received %+v", bp.NodeID(), env)
+		}
+	}
+	expectNone(t, a)
+
+	// A node that left receives nothing more
+	c.Close()
+	b.Publish(models.Event{Type: "typing_start", Channel: "general", From: "bob"})
+	if env := receive(t, a); env.Origin != "b" || env.Event.Type != "typing_start" {
+		t.Errorf("a received %+v", env)
+	}
+	expectNone(t, c)
+}
+
+// startMesh starts a mesh on a loopback port and closes it when the test
+// ends, which waits for its connections to finish
+func startMesh(t *testing.T, nodeID string, peers []string, secret string) *Mesh {
+	t.Helper()
+	m, err := NewMesh(nodeID, "127.0.0.1:0", peers, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestMesh(t *testing.T) {
+	a := startMesh(t, "a", nil, "secret")
+	addr := a.listener.Addr().String()
+	b := startMesh(t, "b", []string{addr}, "secret")
+	intruder := startMesh(t, "intruder", []string{addr}, "guess")
+
+	// Events published before the connection is up are queued
+	intruder.Publish(models.Event{Type: "send_message", Channel: "general", Content: "let me in"})
+	b.Publish(models.Event{Type: "send_message", Channel: "general", Content: "hello", Data: map[string]interface{}{"n": 1.0}})
+	b.Publish(models.Event{Type: "typing_start", Channel: "general", From: "bob"})
+
+	env := receive(t, a)
+	if env.Origin != "b" || env.Event.Content != "hello" || env.Event.Data["n"] != 1.0 {
+		t.Errorf("first envelope = %+v", env)
+	}
+	if env := receive(t, a); env.Origin != "b" || env.Event.Type != "typing_start" {
+		t.Errorf("second envelope = %+v", env)
+	}
+
+	// The intruder's connection is refused at the handshake
+	select {
+	case env := <-a.Messages():
+		t.Errorf("received %+v from a peer with the wrong secret", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,73 @@
+package backplane
+
+import (
+	"log/slog"
+	"sync"
+
+	"terminal-chat/server/models"
+)
+
+// Bus connects in-process nodes, for tests and single-binary setups
+type Bus struct {
+	mu    sync.RWMutex
+	nodes map[string]*memoryNode
+}
+
+// NewBus creates an empty in-memory bus
+func NewBus() *Bus {
+	return &Bus{nodes: make(map[string]*memoryNode)}
+}
+
+// Join attaches a node to the bus and returns its backplane
+func (b *Bus) Join(nodeID string) Backplane {
+	n := &memoryNode{
+		id:    nodeID,
+		bus:   b,
+		inbox: make(chan Envelope, inboxSize),
+	}
+
+	b.mu.Lock()
+	b.nodes[nodeID] = n
+	b.mu.Unlock()
+	return n
+}
+
+// memoryNode is one node's view of a Bus
+type memoryNode struct {
+	id    string
+	bus   *Bus
+	inbox chan Envelope
+}
+
+func (n *memoryNode) NodeID() string {
+	return n.id
+}
+
+func (n *memoryNode) Publish(event models.Event) {
+	env := Envelope{Origin: n.id, Event: event}
+
+	n.bus.mu.RLock()
+	defer n.bus.mu.RUnlock()
+
+	for id, peer := range n.bus.nodes {
+		if id == n.id {
+			continue
+		}
+		select {
+		case peer.inbox <- env:
+		default:
+			slog.Warn("Backplane: dropping event, inbox full", "type", event.Type, "node", id)
+		}
+	}
+}
+
+func (n *memoryNode) Messages() <-chan Envelope {
+	return n.inbox
+}
+
+func (n *memoryNode) Close() error {
+	n.bus.mu.Lock()
+	delete(n.bus.nodes, n.id)
+	n.bus.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,246 @@
+package backplane
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"terminal-chat/server/models"
+)
+
+const (
+	// peerQueueSize is the number of envelopes buffered per outbound peer
+	peerQueueSize = 1024
+
+	// maxLineSize bounds a single envelope on the wire
+	maxLineSize = 1 << 20
+
+	minRedialDelay = time.Second
+	maxRedialDelay = 30 * time.Second
+)
+
+// hello is the first line sent on every mesh connection
+type hello struct {
+	Node   string `json:"node"`
+	Secret string `json:"secret"`
+}
+
+// Mesh is a full TCP mesh between server nodes. Every node dials every
+// configured peer and sends its events over that connection, and accepts
+// connections from peers to receive theirs. Envelopes are newline-delimited
+// JSON. Connections are authenticated with a shared secret sent in the
+// clear, so the mesh should run on a private network.
+type Mesh struct {
+	id     string
+	secret string
+
+	listener net.Listener
+	peers    []*meshPeer
+	inbox    chan Envelope
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	inbound map[net.Conn]bool
+}
+
+// meshPeer is an outbound connection to another node
+type meshPeer struct {
+	addr  string
+	queue chan []byte
+}
+
+// NewMesh listens on listenAddr for peers and starts dialing peerAddrs
+func NewMesh(nodeID, listenAddr string, peerAddrs []string, secret string) (*Mesh, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("backplane listen: %w", err)
+	}
+
+	m := &Mesh{
+		id:       nodeID,
+		secret:   secret,
+		listener: listener,
+		inbox:    make(chan Envelope, inboxSize),
+		done:     make(chan struct{}),
+		inbound:  make(map[net.Conn]bool),
+	}
+
+	m.wg.Add(1)
+	go m.accept()
+
+	for _, addr := range peerAddrs {
+		p := &meshPeer{addr: addr, queue: make(chan []byte, peerQueueSize)}
+		m.peers = append(m.peers, p)
+		m.wg.Add(1)
+		go m.dial(p)
+	}
+
+	slog.Info("Backplane listening", "node", nodeID, "addr", listener.Addr().String(), "peers", len(peerAddrs))
+	return m, nil
+}
+
+func (m *Mesh) NodeID() string {
+	return m.id
+}
+
+func (m *Mesh) Publish(event models.Event) {
+	line, err := json.Marshal(Envelope{Origin: m.id, Event: event})
+	if err != nil {
+		slog.Error("Backplane: marshaling event", "type", event.Type, "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	for _, p := range m.peers {
+		select {
+		case p.queue <- line:
+		default:
+			slog.Warn("Backplane: dropping event, queue full", "type", event.Type, "peer", p.addr)
+		}
+	}
+}
+
+func (m *Mesh) Messages() <-chan Envelope {
+	return m.inbox
+}
+
+func (m *Mesh) Close() error {
+	close(m.done)
+	err := m.listener.Close()
+
+	m.mu.Lock()
+	for conn := range m.inbound {
+		conn.Close()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return err
+}
+
+// accept receives connections from peers
+func (m *Mesh) accept() {
+	defer m.wg.Done()
+
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			select {
+			case <-m.done:
+				return
+			default:
+			}
+			slog.Warn("Backplane: accept failed", "err", err)
+			time.Sleep(minRedialDelay)
+			continue
+		}
+
+		m.mu.Lock()
+		m.inbound[conn] = true
+		m.mu.Unlock()
+
+		m.wg.Add(1)
+		go m.receive(conn)
+	}
+}
+
+// receive authenticates a peer connection and reads its envelopes
+func (m *Mesh) receive(conn net.Conn) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.inbound, conn)
+		m.mu.Unlock()
+		conn.Close()
+		m.wg.Done()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	if !scanner.Scan() {
+		return
+	}
+	var h hello
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil ||
+		subtle.ConstantTimeCompare([]byte(h.Secret), []byte(m.secret)) != 1 {
+		slog.Warn("Backplane: rejected peer, bad handshake", "remote", conn.RemoteAddr().String())
+		return
+	}
+	slog.Info("Backplane: node connected", "node", h.Node, "remote", conn.RemoteAddr().String())
+
+	for scanner.Scan() {
+		var env Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &env); err != nil {
+			slog.Warn("Backplane: bad envelope", "node", h.Node, "err", err)
+			continue
+		}
+
+		select {
+		case m.inbox <- env:
+		case <-m.done:
+			return
+		}
+	}
+	slog.Info("Backplane: node disconnected", "node", h.Node)
+}
+
+// dial keeps an outbound connection to a peer open and writes its queue
+func (m *Mesh) dial(p *meshPeer) {
+	defer m.wg.Done()
+
+	delay := minRedialDelay
+	for {
+		conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+		if err == nil {
+			delay = minRedialDelay
+			err = m.send(conn, p)
+			conn.Close()
+		}
+
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		slog.Warn("Backplane: peer unavailable", "peer", p.addr, "retry_in", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-m.done:
+			return
+		}
+		delay *= 2
+		if delay > maxRedialDelay {
+			delay = maxRedialDelay
+		}
+	}
+}
+
+// send writes the handshake and then queued envelopes until the connection fails
+func (m *Mesh) send(conn net.Conn, p *meshPeer) error {
+	greeting, err := json.Marshal(hello{Node: m.id, Secret: m.secret})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(greeting, '\n')); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case line := <-p.queue:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write(line); err != nil {
+				return err
+			}
+		case <-m.done:
+			return nil
+		}
+	}
+}
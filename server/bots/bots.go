@@ -0,0 +1,217 @@
+// Package bots manages bot accounts. A bot connects over the same WebSocket
+// protocol as people, authenticating with an API token instead of choosing a
+// username, and its sessions and messages are flagged as bots.
+package bots
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	botsFile = "bots.json"
+
+	// TokenPrefix starts every bot token, so they are easy to recognize
+	TokenPrefix = "bot_"
+)
+
+var (
+	// ErrExists is returned when creating a bot whose name is taken
+	ErrExists = errors.New("bot already exists")
+
+	// ErrNotFound is returned for an unknown bot
+	ErrNotFound = errors.New("no such bot")
+
+	// ErrInvalidName is returned for a malformed bot name
+	ErrInvalidName = errors.New("bot names must be 1 to 32 letters, digits, - or _")
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// Store persists the bot accounts
+type Store interface {
+	ReadJSON(name string, v interface{}) error
+	WriteJSON(name string, v interface{}) error
+}
+
+// Bot is a bot account
+type Bot struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// TokenHash is the hex SHA-256 of the API token; the token itself is
+	// only returned when it is issued
+	TokenHash string `json:"token_hash,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Registry holds the bot accounts
+type Registry struct {
+	store Store
+
+	mu   sync.Mutex
+	bots []*Bot
+}
+
+// NewRegistry loads the saved bot accounts
+func NewRegistry(store Store) (*Registry, error) {
+	r := &Registry{store: store}
+	if err := store.ReadJSON(botsFile, &r.bots); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading bots: %w", err)
+	}
+	return r, nil
+}
+
+// Create adds a bot account and returns it with its API token
+func (r *Registry) Create(name, description string) (Bot, string, error) {
+	if !namePattern.MatchString(name) {
+		return Bot{}, "", ErrInvalidName
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		return Bot{}, "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.find(name) != nil {
+		return Bot{}, "", ErrExists
+	}
+	bot := &Bot{Name: name, Description: description, TokenHash: hash, CreatedAt: time.Now()}
+	r.bots = append(r.bots, bot)
+	if err := r.save(); err != nil {
+		r.bots = r.bots[:len(r.bots)-1]
+		return Bot{}, "", err
+	}
+	return bot.redacted(), token, nil
+}
+
+// Delete removes a bot account, so its token no longer connects. Sessions
+// already connected stay up; callers end them with hub.DisconnectBot.
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.bots, func(b *Bot) bool { return strings.EqualFold(b.Name, name) })
+	if i < 0 {
+		return ErrNotFound
+	}
+	removed := r.bots[i]
+	r.bots = slices.Delete(r.bots, i, i+1)
+	if err := r.save(); err != nil {
+		r.bots = slices.Insert(r.bots, i, removed)
+		return err
+	}
+	return nil
+}
+
+// RotateToken issues a new API token for a bot, revoking the old one
+func (r *Registry) RotateToken(name string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bot := r.find(name)
+	if bot == nil {
+		return "", ErrNotFound
+	}
+	old := bot.TokenHash
+	bot.TokenHash = hash
+	if err := r.save(); err != nil {
+		bot.TokenHash = old
+		return "", err
+	}
+	return token, nil
+}
+
+// List returns the bot accounts without their token hashes
+func (r *Registry) List() []Bot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bots := []Bot{}
+	for _, bot := range r.bots {
+		bots = append(bots, bot.redacted())
+	}
+	return bots
+}
+
+// Authenticate returns the bot that token belongs to
+func (r *Registry) Authenticate(token string) (Bot, bool) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return Bot{}, false
+	}
+	hash := hashToken(token)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, bot := range r.bots {
+		if bot.TokenHash == hash {
+			return bot.redacted(), true
+		}
+	}
+	return Bot{}, false
+}
+
+// IsBot reports whether name belongs to a bot account. People cannot
+// connect under a bot's name.
+func (r *Registry) IsBot(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.find(name) != nil
+}
+
+// find returns the bot named name, ignoring case. The caller must hold r.mu.
+func (r *Registry) find(name string) *Bot {
+	for _, bot := range r.bots {
+		if strings.EqualFold(bot.Name, name) {
+			return bot
+		}
+	}
+	return nil
+}
+
+// save writes the bot accounts. The caller must hold r.mu.
+func (r *Registry) save() error {
+	return r.store.WriteJSON(botsFile, r.bots)
+}
+
+func (b *Bot) redacted() Bot {
+	bot := *b
+	bot.TokenHash = ""
+	return bot
+}
+
+// newToken returns a random API token and its hash
+func newToken() (token, hash string, err error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = TokenPrefix + hex.EncodeToString(secret)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of a token. Tokens are random, so a
+// plain hash is enough to keep them out of the state directory.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval limits how often certificate files are checked for changes
+const reloadCheckInterval = 10 * time.Second
+
+// Reloader serves a certificate from disk and reloads it when the files change
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they cannot be read
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the key pair from disk and records when it was last modified
+func (r *Reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// latestModTime returns the newer of the certificate and key modification times
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The files are checked
+// for changes at most every reloadCheckInterval; if a reload fails the
+// previous certificate keeps being served.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	due := time.Since(r.lastCheck) >= reloadCheckInterval
+	if due {
+		r.lastCheck = time.Now()
+	}
+	r.mu.Unlock()
+
+	if due {
+		r.maybeReload()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// maybeReload reloads the key pair if either file changed on disk
+func (r *Reloader) maybeReload() {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		slog.Warn("Checking TLS certificate", "err", err)
+		return
+	}
+
+	r.mu.RLock()
+	changed := modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		slog.Error("Reloading TLS certificate, keeping previous one", "err", err)
+		return
+	}
+	slog.Info("Reloaded TLS certificate", "file", r.certFile, "fingerprint", r.Fingerprint())
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate being served
+func (r *Reloader) Fingerprint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil || len(r.cert.Certificate) == 0 {
+		return ""
+	}
+	return Fingerprint(r.cert.Certificate[0])
+}
+
+// Fingerprint returns the hex SHA-256 digest of a DER-encoded certificate
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureSelfSigned returns the paths of a self-signed certificate and key in
+// dir, generating them if they do not exist or the certificate has expired.
+// The certificate is valid for localhost and the given extra hosts.
+func EnsureSelfSigned(dir string, hosts []string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "selfsigned.crt")
+	keyFile = filepath.Join(dir, "selfsigned.key")
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Before(leaf.NotAfter.Add(-24*time.Hour)) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("creating certificate directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generating key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", fmt.Errorf("generating serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"terminal-chat development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range append([]string{"localhost", "127.0.0.1", "::1"}, hosts...) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("creating certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("encoding key: %w", err)
+	}
+
+	// Write the key first so a reloader never sees a certificate without its key
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return "", "", err
+	}
+
+	slog.Info("Generated self-signed certificate", "file", certFile, "fingerprint", Fingerprint(der))
+	return certFile, keyFile, nil
+}
+
+// writePEM atomically writes a single PEM block to path
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+)
+
+// parseArgs splits a command's arguments on spaces. Double or single quotes
+// group words into one argument and a backslash escapes the next character.
+func parseArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		inArg   bool
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,444 @@
+// Package bot is a small framework for chat bots built on chatsdk. A bot
+// registers commands with handlers; the framework answers both "!command"
+// messages in its channels and "/command" slash commands routed to it by the
+// server, parses their arguments, enforces permissions and argument counts,
+// runs middleware such as logging and rate limiting, and provides a help
+// command.
+//
+//	b := bot.New(chatsdk.New(chatsdk.Options{URL: url, Token: token, Channels: []string{"ops"}}), bot.Options{})
+//	b.Use(bot.Logging(slog.Default()), bot.RateLimit(5, time.Minute))
+//	b.Handle(bot.Command{
+//		Name:    "deploy",
+//		Usage:   "<service> <env>",
+//		Args:    2,
+//		Allow:   bot.Users("alice", "bob"),
+//		Handler: func(c *bot.Context) error { return c.Replyf("deploying %s to %s", c.Arg(0), c.Arg(1)) },
+//	})
+//	log.Fatal(b.Run(ctx))
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"terminal-chat/server/chatsdk"
+)
+
+// DefaultPrefix starts a command typed as a plain message
+const DefaultPrefix = "!"
+
+// ErrPermission is reported to a user who invokes a command they are not
+// allowed to use
+var ErrPermission = errors.New("you are not allowed to use this command")
+
+// Options configures a Bot
+type Options struct {
+	// Prefix starts a command in a plain message. It defaults to "!".
+	Prefix string
+
+	// NoPrefix answers only slash commands, not prefixed messages
+	NoPrefix bool
+
+	// NoSlash skips registering the commands as server slash commands
+	NoSlash bool
+
+	// Logger receives errors from handlers. It defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// HandlerFunc handles one command invocation. A returned error is reported
+// to the user who invoked the command.
+type HandlerFunc func(*Context) error
+
+// Middleware wraps the handler of every command
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Permission decides whether an invocation may run
+type Permission func(*Context) bool
+
+// Command is a command the bot answers
+type Command struct {
+	// Name is the command without its prefix, such as "deploy"
+	Name    string
+	Aliases []string
+
+	// Description and Usage are shown by help; Usage lists the arguments,
+	// such as "<service> <env>"
+	Description string
+	Usage       string
+
+	// Args is the least number of arguments and MaxArgs the most, with 0
+	// meaning no limit. Invocations outside them are answered with the usage.
+	Args    int
+	MaxArgs int
+
+	// Allow, if set, must approve an invocation for it to run
+	Allow Permission
+
+	// NoSlash keeps the command from being registered as a slash command,
+	// for names other bots are likely to want
+	NoSlash bool
+
+	Handler HandlerFunc
+}
+
+// Context is one invocation of a command. It carries the context of the
+// bot's Run call.
+type Context struct {
+	context.Context
+
+	Bot     *Bot
+	Command *Command
+
+	// Name is the command as typed, which may be an alias
+	Name string
+
+	// Args are the parsed arguments and RawArgs the text they came from
+	Args    []string
+	RawArgs string
+
+	// From, Channel and MessageID identify the invoking message
+	From      string
+	Channel   string
+	MessageID string
+
+	// Slash is set for commands routed by the server rather than typed
+	// with the prefix
+	Slash bool
+}
+
+// Arg returns the i-th argument, or "" if there are fewer
+func (c *Context) Arg(i int) string {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	return ""
+}
+
+// Reply posts text to the channel the command came from
+func (c *Context) Reply(text string) error {
+	_, err := c.Bot.client.Send(c, c.Channel, text)
+	return err
+}
+
+// Replyf posts formatted text to the channel the command came from
+func (c *Context) Replyf(format string, args ...interface{}) error {
+	return c.Reply(fmt.Sprintf(format, args...))
+}
+
+// Bot routes command invocations to handlers
+type Bot struct {
+	client *chatsdk.Client
+	opts   Options
+	log    *slog.Logger
+
+	mu         sync.Mutex
+	commands   map[string]*Command
+	names      []string
+	middleware []Middleware
+	ctx        context.Context
+
+	ready    chan struct{}
+	handlers sync.WaitGroup
+}
+
+// New creates a bot driving client, which must not be connected yet
+func New(client *chatsdk.Client, opts Options) *Bot {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	b := &Bot{
+		client:   client,
+		opts:     opts,
+		log:      opts.Logger,
+		commands: make(map[string]*Command),
+		ready:    make(chan struct{}),
+		ctx:      context.Background(),
+	}
+	b.Handle(Command{
+		Name:        "help",
+		Description: "List commands, or show how to use one",
+		Usage:       "[command]",
+		MaxArgs:     1,
+		NoSlash:     true,
+		Handler:     b.help,
+	})
+
+	client.OnMessage(b.onMessage)
+	client.OnCommand(b.onCommand)
+	return b
+}
+
+// Client returns the connection the bot drives
+func (b *Bot) Client() *chatsdk.Client {
+	return b.client
+}
+
+// Handle adds a command, replacing any with the same name or alias. Commands
+// added after Run has started are not registered as slash commands.
+func (b *Bot) Handle(cmd Command) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cmd.Name = strings.ToLower(cmd.Name)
+	c := &cmd
+	if _, exists := b.commands[cmd.Name]; !exists {
+		b.names = append(b.names, cmd.Name)
+		sort.Strings(b.names)
+	}
+	b.commands[cmd.Name] = c
+	for _, alias := range cmd.Aliases {
+		b.commands[strings.ToLower(alias)] = c
+	}
+}
+
+// Use adds middleware. The first added runs outermost.
+func (b *Bot) Use(mw ...Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.middleware = append(b.middleware, mw...)
+}
+
+// Ready is closed once Run has connected and set up the bot's commands
+func (b *Bot) Ready() <-chan struct{} {
+	return b.ready
+}
+
+// Run connects, registers the bot's slash commands and subscribes to
+// messages for prefixed commands, then serves until ctx is done or the
+// client stops for good. It waits for running handlers before returning.
+func (b *Bot) Run(ctx context.Context) error {
+	b.mu.Lock()
+	b.ctx = ctx
+	b.mu.Unlock()
+
+	if err := b.client.Connect(ctx); err != nil {
+		return err
+	}
+	defer b.handlers.Wait()
+	defer b.client.Close()
+
+	if err := b.setup(ctx); err != nil {
+		return err
+	}
+	close(b.ready)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-b.client.Done():
+		return b.client.Err()
+	}
+}
+
+// setup registers slash commands and subscribes to messages. People's
+// accounts can run a bot too, answering prefixed commands only.
+func (b *Bot) setup(ctx context.Context) error {
+	if !b.client.Welcome().Bot {
+		return nil
+	}
+	if !b.opts.NoPrefix {
+		if err := b.client.Subscribe(ctx, "send_message"); err != nil {
+			return fmt.Errorf("subscribing to messages: %w", err)
+		}
+	}
+	if b.opts.NoSlash {
+		return nil
+	}
+
+	b.mu.Lock()
+	var commands []*Command
+	for _, name := range b.names {
+		commands = append(commands, b.commands[name])
+	}
+	b.mu.Unlock()
+
+	for _, cmd := range commands {
+		if cmd.NoSlash {
+			continue
+		}
+		spec := chatsdk.CommandSpec{Name: cmd.Name, Description: cmd.Description, Usage: "/" + cmd.Name}
+		if cmd.Usage != "" {
+			spec.Usage += " " + cmd.Usage
+		}
+		if err := b.client.RegisterCommand(ctx, spec); err != nil {
+			// Another bot may own the name; it still works with the prefix
+			b.log.Warn("Could not register slash command", "command", cmd.Name, "err", err)
+		}
+	}
+	return nil
+}
+
+// onMessage answers prefixed commands, ignoring bots including itself
+func (b *Bot) onMessage(msg chatsdk.Message) {
+	if b.opts.NoPrefix || msg.Bot || msg.From == b.client.Welcome().Username {
+		return
+	}
+	text, ok := strings.CutPrefix(msg.Content, b.opts.Prefix)
+	if !ok {
+		return
+	}
+	name, args, _ := strings.Cut(text, " ")
+	b.invoke(name, args, &Context{From: msg.From, Channel: msg.Channel, MessageID: msg.ID})
+}
+
+// onCommand answers slash commands routed by the server
+func (b *Bot) onCommand(cmd chatsdk.Command) {
+	b.invoke(cmd.Name, cmd.Args, &Context{From: cmd.From, Channel: cmd.Channel, MessageID: cmd.ID, Slash: true})
+}
+
+// invoke runs the named command in its own goroutine so a slow handler does
+// not hold up other events
+func (b *Bot) invoke(name, rawArgs string, c *Context) {
+	b.mu.Lock()
+	cmd := b.commands[strings.ToLower(name)]
+	handler := b.chain(cmd)
+	c.Context = b.ctx
+	b.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	c.Bot, c.Command, c.Name = b, cmd, name
+	c.RawArgs = strings.TrimSpace(rawArgs)
+	args, err := parseArgs(c.RawArgs)
+	c.Args = args
+
+	b.handlers.Add(1)
+	go func() {
+		defer b.handlers.Done()
+		if err == nil {
+			err = handler(c)
+		}
+		if err != nil {
+			b.fail(c, err)
+		}
+	}()
+}
+
+// chain wraps a command's handler in the checks and middleware. The caller
+// must hold b.mu.
+func (b *Bot) chain(cmd *Command) HandlerFunc {
+	if cmd == nil {
+		return nil
+	}
+	h := func(c *Context) error {
+		if cmd.Allow != nil && !cmd.Allow(c) {
+			return ErrPermission
+		}
+		if len(c.Args) < cmd.Args || (cmd.MaxArgs > 0 && len(c.Args) > cmd.MaxArgs) {
+			return c.Replyf("Usage: %s", b.usage(cmd, c.Slash))
+		}
+		return cmd.Handler(c)
+	}
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		h = b.middleware[i](h)
+	}
+	return h
+}
+
+// fail reports a handler's error to the invoking user
+func (b *Bot) fail(c *Context, err error) {
+	if !errors.Is(err, ErrPermission) && !errors.Is(err, ErrRateLimited) {
+		b.log.Warn("Bot command failed", "command", c.Command.Name, "user", c.From, "channel", c.Channel, "err", err)
+	}
+	if replyErr := c.Replyf("%s: %v", c.From, err); replyErr != nil {
+		b.log.Warn("Could not report bot command error", "command", c.Command.Name, "err", replyErr)
+	}
+}
+
+// usage formats how to invoke a command
+func (b *Bot) usage(cmd *Command, slash bool) string {
+	prefix := b.opts.Prefix
+	if slash {
+		prefix = "/"
+	}
+	if cmd.Usage == "" {
+		return prefix + cmd.Name
+	}
+	return prefix + cmd.Name + " " + cmd.Usage
+}
+
+// help lists the commands the user may run, or describes one
+func (b *Bot) help(c *Context) error {
+	if name := strings.TrimPrefix(strings.TrimPrefix(c.Arg(0), b.opts.Prefix), "/"); name != "" {
+		b.mu.Lock()
+		cmd := b.commands[strings.ToLower(name)]
+		b.mu.Unlock()
+		if cmd == nil {
+			return fmt.Errorf("no command %q", name)
+		}
+		text := "Usage: " + b.usage(cmd, c.Slash)
+		if cmd.Description != "" {
+			text += " — " + cmd.Description
+		}
+		if len(cmd.Aliases) > 0 {
+			text += " (also " + strings.Join(cmd.Aliases, ", ") + ")"
+		}
+		return c.Reply(text)
+	}
+
+	b.mu.Lock()
+	var commands []*Command
+	for _, name := range b.names {
+		commands = append(commands, b.commands[name])
+	}
+	b.mu.Unlock()
+
+	var lines []string
+	for _, cmd := range commands {
+		if cmd.Allow != nil && !cmd.Allow(c) {
+			continue
+		}
+		line := b.usage(cmd, c.Slash)
+		if cmd.Description != "" {
+			line += " — " + cmd.Description
+		}
+		lines = append(lines, line)
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+// Users allows only the named users
+func Users(names ...string) Permission {
+	return func(c *Context) bool {
+		for _, name := range names {
+			if strings.EqualFold(name, c.From) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Channels allows invocations only from the given channels
+func Channels(ids ...string) Permission {
+	return func(c *Context) bool {
+		for _, id := range ids {
+			if id == c.Channel {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All allows an invocation only if every permission does
+func All(perms ...Permission) Permission {
+	return func(c *Context) bool {
+		for _, p := range perms {
+			if !p(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,223 @@
+// Package bottest runs bots against an in-process chat server, so a bot's
+// commands can be tested end to end without a deployment.
+//
+//	func TestDeploy(t *testing.T) {
+//		srv := bottest.NewServer(t)
+//		b := bot.New(srv.BotClient("deploybot", "dev"), bot.Options{})
+//		b.Handle(deployCommand)
+//		srv.Start(b)
+//
+//		alice := srv.User("alice", "dev")
+//		alice.Say("dev", "!deploy api prod")
+//		if got := alice.Expect().Content; got != "deploying api to prod" {
+//			t.Errorf("reply = %q", got)
+//		}
+//	}
+package bottest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"terminal-chat/server/bots"
+	"terminal-chat/server/chatsdk"
+	"terminal-chat/server/chatsdk/bot"
+	"terminal-chat/server/config"
+	"terminal-chat/server/files"
+	"terminal-chat/server/hub"
+	"terminal-chat/server/storage"
+	"terminal-chat/server/ws"
+)
+
+// Timeout bounds how long the harness waits for the server or a bot
+var Timeout = 5 * time.Second
+
+// Server is a chat server running in the test process with the default
+// configuration and channels
+type Server struct {
+	// URL is the server's WebSocket endpoint
+	URL string
+
+	Hub  *hub.Hub
+	Bots *bots.Registry
+
+	t testing.TB
+}
+
+// NewServer starts a server that is shut down when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	store, err := storage.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("bottest: opening storage: %v", err)
+	}
+	registry, err := bots.NewRegistry(store)
+	if err != nil {
+		t.Fatalf("bottest: loading bots: %v", err)
+	}
+
+	cfg := config.Default()
+	fileStore, err := files.NewStore(filepath.Join(t.TempDir(), "files"), store, cfg.Limits.MaxFileSize)
+	if err != nil {
+		t.Fatalf("bottest: opening file storage: %v", err)
+	}
+	h := hub.NewHub()
+	h.EnsureChannels(cfg.DefaultChannels)
+	h.SetFiles(fileStore)
+	go h.Run()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ws.HandleWebSocket(h, cfg, registry, fileStore))
+	mux.Handle("/files/", fileStore.Handler())
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		defer cancel()
+		h.Shutdown(ctx, "test finished")
+		srv.Close()
+	})
+
+	return &Server{
+		URL:  "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		Hub:  h,
+		Bots: registry,
+		t:    t,
+	}
+}
+
+// BotClient creates a bot account and returns an unconnected client for it
+// that joins channels
+func (s *Server) BotClient(name string, channels ...string) *chatsdk.Client {
+	s.t.Helper()
+
+	_, token, err := s.Bots.Create(name, "")
+	if err != nil {
+		s.t.Fatalf("bottest: creating bot %s: %v", name, err)
+	}
+	return chatsdk.New(chatsdk.Options{
+		URL:            s.URL,
+		Token:          token,
+		Channels:       channels,
+		NoReconnect:    true,
+		RequestTimeout: Timeout,
+	})
+}
+
+// Start runs a bot until the test ends, returning once it is ready. An error
+// from its Run fails the test.
+func (s *Server) Start(b *bot.Bot) {
+	s.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(ctx)
+	}()
+	s.t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil && !errors.Is(err, chatsdk.ErrClosed) {
+			s.t.Errorf("bottest: bot stopped: %v", err)
+		}
+	})
+
+	select {
+	case <-b.Ready():
+	case err := <-done:
+		done <- err
+		s.t.Fatalf("bottest: bot did not start: %v", err)
+	case <-time.After(Timeout):
+		s.t.Fatalf("bottest: bot not ready after %s", Timeout)
+	}
+}
+
+// User is a person connected to the server, for driving bots
+type User struct {
+	*chatsdk.Client
+
+	t        testing.TB
+	messages chan chatsdk.Message
+}
+
+// User connects a person who joins channels. They are disconnected when the
+// test ends.
+func (s *Server) User(name string, channels ...string) *User {
+	s.t.Helper()
+
+	u := &User{
+		Client: chatsdk.New(chatsdk.Options{
+			URL:            s.URL,
+			Username:       name,
+			Channels:       channels,
+			NoReconnect:    true,
+			RequestTimeout: Timeout,
+		}),
+		t:        s.t,
+		messages: make(chan chatsdk.Message, 256),
+	}
+	u.OnMessage(func(msg chatsdk.Message) {
+		if msg.From == name {
+			return
+		}
+		select {
+		case u.messages <- msg:
+		case <-u.Done():
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	if err := u.Connect(ctx); err != nil {
+		s.t.Fatalf("bottest: connecting %s: %v", name, err)
+	}
+	s.t.Cleanup(func() { u.Close() })
+
+	// Joins are not answered, but the server handles a session's requests
+	// in order, so a history fetch returns once the join has happened
+	for _, ch := range channels {
+		if _, err := u.History(ctx, ch); err != nil {
+			s.t.Fatalf("bottest: %s joining #%s: %v", name, ch, err)
+		}
+	}
+	return u
+}
+
+// Say posts a message, failing the test if the server rejects it
+func (u *User) Say(channel, text string) {
+	u.t.Helper()
+
+	if _, err := u.Send(context.Background(), channel, text); err != nil {
+		u.t.Fatalf("bottest: sending %q to #%s: %v", text, channel, err)
+	}
+}
+
+// Expect returns the next message from someone else, failing the test if
+// none arrives in time
+func (u *User) Expect() chatsdk.Message {
+	u.t.Helper()
+
+	select {
+	case msg := <-u.messages:
+		return msg
+	case <-time.After(Timeout):
+		u.t.Fatalf("bottest: no message after %s", Timeout)
+		return chatsdk.Message{}
+	}
+}
+
+// ExpectNone fails the test if a message from someone else arrives within d
+func (u *User) ExpectNone(d time.Duration) {
+	u.t.Helper()
+
+	select {
+	case msg := <-u.messages:
+		u.t.Fatalf("bottest: unexpected message from %s: %q", msg.From, msg.Content)
+	case <-time.After(d):
+	}
+}
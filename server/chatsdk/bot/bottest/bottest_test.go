@@ -0,0 +1,72 @@
+package bottest_test
+
+import (
+	"testing"
+	"time"
+
+	"terminal-chat/server/chatsdk/bot"
+	"terminal-chat/server/chatsdk/bot/bottest"
+)
+
+var deployCommand = bot.Command{
+	Name:        "deploy",
+	Description: "Deploy a service",
+	Usage:       "<service> <env>",
+	Args:        2,
+	MaxArgs:     2,
+	Allow:       bot.Users("alice"),
+	Handler: func(c *bot.Context) error {
+		return c.Replyf("deploying %s to %s", c.Arg(0), c.Arg(1))
+	},
+}
+
+// startDeployBot runs a bot answering deployCommand in #dev
+func startDeployBot(t *testing.T) *bottest.Server {
+	t.Helper()
+	srv := bottest.NewServer(t)
+	b := bot.New(srv.BotClient("deploybot", "dev"), bot.Options{})
+	b.Handle(deployCommand)
+	srv.Start(b)
+	return srv
+}
+
+func TestDeploy(t *testing.T) {
+	srv := startDeployBot(t)
+	alice := srv.User("alice", "dev")
+
+	alice.Say("dev", "!deploy api prod")
+	if got := alice.Expect(); got.Content != "deploying api to prod" || got.From != "deploybot" || !got.Bot {
+		t.Errorf("reply = %+v", got)
+	}
+
+	// The same command is registered as a slash command routed to the bot
+	alice.Say("dev", "/deploy web staging")
+	if got := alice.Expect().Content; got != "deploying web to staging" {
+		t.Errorf("slash reply = %q", got)
+	}
+
+	alice.Say("dev", "!deploy api")
+	if got := alice.Expect().Content; got != "Usage: !deploy <service> <env>" {
+		t.Errorf("usage reply = %q", got)
+	}
+}
+
+func TestDeployPermission(t *testing.T) {
+	srv := startDeployBot(t)
+	alice := srv.User("alice", "dev")
+	mallory := srv.User("mallory", "dev")
+
+	mallory.Say("dev", "!deploy api prod")
+	want := "mallory: " + bot.ErrPermission.Error()
+	if got := mallory.Expect().Content; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	// alice sees the rejection too, and nothing else
+	if got := alice.Expect().Content; got != "!deploy api prod" {
+		t.Errorf("alice saw %q", got)
+	}
+	if got := alice.Expect().Content; got != want {
+		t.Errorf("alice saw %q", got)
+	}
+	alice.ExpectNone(100 * time.Millisecond)
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is reported to a user who invokes commands faster than a
+// RateLimit middleware allows
+var ErrRateLimited = errors.New("too many commands")
+
+// Logging logs every invocation with how long it took and how it ended
+func Logging(log *slog.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			start := time.Now()
+			err := next(c)
+			attrs := []interface{}{
+				"command", c.Command.Name,
+				"user", c.From,
+				"channel", c.Channel,
+				"slash", c.Slash,
+				"duration", time.Since(start),
+			}
+			if err != nil {
+				log.Info("Bot command failed", append(attrs, "err", err)...)
+			} else {
+				log.Info("Bot command handled", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// RateLimit lets each user run n commands per period, refilling steadily,
+// and rejects the rest with ErrRateLimited
+func RateLimit(n int, per time.Duration) Middleware {
+	limiter := &rateLimiter{
+		burst:   float64(n),
+		rate:    float64(n) / per.Seconds(),
+		buckets: make(map[string]*bucket),
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if wait := limiter.take(strings.ToLower(c.From), time.Now()); wait > 0 {
+				return fmt.Errorf("%w, try again in %s", ErrRateLimited, max(wait.Round(time.Second), time.Second))
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimiter is a token bucket per user
+type rateLimiter struct {
+	burst float64
+	rate  float64 // tokens per second
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends one of user's tokens, returning how long until one is
+// available if there is none
+func (l *rateLimiter) take(user string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[user]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[user] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
@@ -0,0 +1,803 @@
+// Package chatsdk is a Go client for the chat server's WebSocket protocol,
+// for bots and integrations. It decodes events into typed values, calls
+// handlers for them, reconnects with backoff when the connection drops,
+// and turns request/response exchanges such as sending with an
+// acknowledgement or fetching history into blocking calls.
+//
+//	c := chatsdk.New(chatsdk.Options{URL: "ws://localhost:8080/ws", Token: token, Channels: []string{"dev"}})
+//	c.OnCommand(func(cmd chatsdk.Command) {
+//		c.Reply(context.Background(), cmd, "on it")
+//	})
+//	if err := c.Connect(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+package chatsdk
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"terminal-chat/server/protocol"
+)
+
+// Subprotocol is the Sec-WebSocket-Protocol value the client requests
+const Subprotocol = protocol.Subprotocol
+
+const (
+	defaultMinBackoff     = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultRequestTimeout = 10 * time.Second
+	defaultPongWait       = 60 * time.Second
+
+	// writeWait bounds a single write to the server
+	writeWait = 10 * time.Second
+
+	// dispatchQueueSize is how many events may wait for the handlers before
+	// reading from the server pauses
+	dispatchQueueSize = 1024
+)
+
+var (
+	// ErrNotConnected is returned by requests made while disconnected
+	ErrNotConnected = errors.New("chatsdk: not connected")
+
+	// ErrDisconnected is returned by requests whose connection dropped
+	// before the server answered
+	ErrDisconnected = errors.New("chatsdk: disconnected before the server answered")
+
+	// ErrClosed is returned once Close has been called
+	ErrClosed = errors.New("chatsdk: client closed")
+)
+
+// Options configures a Client
+type Options struct {
+	// URL is the server's WebSocket endpoint, such as ws://localhost:8080/ws
+	URL string
+
+	// Username connects as a person. Token connects as a bot account and
+	// takes precedence.
+	Username string
+	Token    string
+
+	// TLSConfig is used for wss:// URLs
+	TLSConfig *tls.Config
+
+	// Channels are joined on every connect
+	Channels []string
+
+	// Subscribe lists the event types a bot receives, sent on every
+	// connect. Bots that leave it empty receive only commands and replies.
+	Subscribe []string
+
+	// NoReconnect stops the client when the connection drops instead of
+	// reconnecting
+	NoReconnect bool
+
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts.
+	// They default to 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// RequestTimeout bounds how long a request waits for its answer when
+	// its context has no earlier deadline. It defaults to 10s.
+	RequestTimeout time.Duration
+
+	// PongWait is how long the connection may stay silent before it is
+	// dropped as dead. The client pings the server every 9/10 of it, so a
+	// live server always answers in time. It defaults to 60s.
+	PongWait time.Duration
+
+	// Logger receives connection events. It defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// CommandSpec describes a slash command registered by a bot
+type CommandSpec struct {
+	Name        string `json:"command"`
+	Description string `json:"description,omitempty"`
+	Usage       string `json:"usage,omitempty"`
+
+	// Bot is the owner, filled in by Commands
+	Bot string `json:"bot,omitempty"`
+}
+
+// result answers a pending request
+type result struct {
+	event Event
+	err   error
+}
+
+// Client is a connection to the chat server. Handlers are registered before
+// Connect and are called one at a time from a single goroutine, in the order
+// events arrive. They may make requests such as Send, but should not block
+// for long: events queue up behind a slow handler.
+type Client struct {
+	opts Options
+	log  *slog.Logger
+
+	onEvent        []func(Event)
+	onWelcome      []func(Welcome)
+	onMessage      []func(Message)
+	onCommand      []func(Command)
+	onPresence     []func(Presence)
+	onAnnouncement []func(string)
+	onError        []func(*ServerError)
+	onDisconnect   []func(Disconnected)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan func()
+	done   chan struct{}
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	welcome  Welcome
+	channels map[string]bool
+	commands map[string]CommandSpec
+	waiters  map[string][]chan result
+	stopErr  error
+
+	// writeMu serializes writes to conn
+	writeMu sync.Mutex
+}
+
+// New creates a client. Register handlers, then call Connect.
+func New(opts Options) *Client {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = defaultRequestTimeout
+	}
+	if opts.PongWait <= 0 {
+		opts.PongWait = defaultPongWait
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		opts:     opts,
+		log:      opts.Logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		queue:    make(chan func(), dispatchQueueSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]bool),
+		commands: make(map[string]CommandSpec),
+		waiters:  make(map[string][]chan result),
+	}
+	for _, ch := range opts.Channels {
+		c.channels[ch] = true
+	}
+	return c
+}
+
+// OnEvent registers a handler for every event, before it is decoded
+func (c *Client) OnEvent(fn func(Event)) { c.onEvent = append(c.onEvent, fn) }
+
+// OnWelcome registers a handler called after every successful connect
+func (c *Client) OnWelcome(fn func(Welcome)) { c.onWelcome = append(c.onWelcome, fn) }
+
+// OnMessage registers a handler for chat messages, including the client's own
+func (c *Client) OnMessage(fn func(Message)) { c.onMessage = append(c.onMessage, fn) }
+
+// OnCommand registers a handler for slash commands registered by this bot
+func (c *Client) OnCommand(fn func(Command)) { c.onCommand = append(c.onCommand, fn) }
+
+// OnPresence registers a handler for users joining and leaving channels
+func (c *Client) OnPresence(fn func(Presence)) { c.onPresence = append(c.onPresence, fn) }
+
+// OnAnnouncement registers a handler for server-wide announcements
+func (c *Client) OnAnnouncement(fn func(string)) { c.onAnnouncement = append(c.onAnnouncement, fn) }
+
+// OnError registers a handler for server errors not tied to a request
+func (c *Client) OnError(fn func(*ServerError)) { c.onError = append(c.onError, fn) }
+
+// OnDisconnect registers a handler called whenever a connection ends
+func (c *Client) OnDisconnect(fn func(Disconnected)) { c.onDisconnect = append(c.onDisconnect, fn) }
+
+// Connect dials the server and waits for its welcome. Later drops are
+// reconnected in the background unless NoReconnect is set.
+func (c *Client) Connect(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	go c.dispatch()
+	go c.run(conn)
+	return nil
+}
+
+// dial connects, reads the welcome and restores joins, subscriptions and
+// commands
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if c.opts.Token != "" {
+		header.Set("Authorization", "Bearer "+c.opts.Token)
+	} else {
+		q := u.Query()
+		q.Set("username", c.opts.Username)
+		u.RawQuery = q.Encode()
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{Subprotocol}
+	dialer.TLSClientConfig = c.opts.TLSConfig
+
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, &HandshakeError{Status: resp.StatusCode, err: err}
+		}
+		return nil, err
+	}
+
+	var welcome Event
+	conn.SetReadDeadline(time.Now().Add(c.opts.RequestTimeout))
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != "welcome" {
+		conn.Close()
+		if err == nil {
+			err = fmt.Errorf("chatsdk: expected welcome, got %q", welcome.Type)
+		}
+		return nil, err
+	}
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+		return nil
+	})
+
+	c.mu.Lock()
+	c.conn = conn
+	c.welcome = welcomeFromEvent(welcome)
+	var channels []string
+	for ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	var commands []CommandSpec
+	for _, cmd := range c.commands {
+		commands = append(commands, cmd)
+	}
+	subscribe := c.opts.Subscribe
+	c.mu.Unlock()
+
+	// Restore what the previous connection had set up; answers arrive as
+	// ordinary events
+	if c.welcome.Bot && subscribe != nil {
+		c.write(Event{Type: "subscribe", Data: map[string]interface{}{"events": subscribe}})
+	}
+	for _, cmd := range commands {
+		c.write(registerEvent(cmd))
+	}
+	for _, ch := range channels {
+		c.write(Event{Type: "join_channel", Channel: ch})
+	}
+
+	c.emit(welcome)
+	return conn, nil
+}
+
+// HandshakeError is returned when the server refuses a connection, such as
+// for a banned user or an invalid bot token
+type HandshakeError struct {
+	Status int
+	err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("chatsdk: server refused the connection with %d %s", e.Status, http.StatusText(e.Status))
+}
+
+func (e *HandshakeError) Unwrap() error { return e.err }
+
+// permanent reports whether retrying a handshake is pointless
+func (e *HandshakeError) permanent() bool {
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden || e.Status == http.StatusBadRequest
+}
+
+// run reads from conn until it fails, then reconnects until Close
+func (c *Client) run(conn *websocket.Conn) {
+	for {
+		err := c.read(conn)
+		conn.Close()
+
+		c.mu.Lock()
+		c.conn = nil
+		c.failWaiters()
+		c.mu.Unlock()
+
+		d := Disconnected{Reason: "connection lost"}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			d.Code, d.Reason = closeErr.Code, closeErr.Text
+		}
+		d.Reconnecting = c.ctx.Err() == nil && !c.opts.NoReconnect && d.Code != CloseBanned
+		queueHandlersFor(c, c.onDisconnect, d)
+		if !d.Reconnecting {
+			c.stop(fmt.Errorf("chatsdk: disconnected: %s", d.Reason))
+			return
+		}
+		c.log.Info("Chat connection lost, reconnecting", "code", d.Code, "reason", d.Reason)
+
+		conn = c.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials with exponential backoff, returning nil once the client
+// has stopped
+func (c *Client) reconnect() *websocket.Conn {
+	delay := c.opts.MinBackoff
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			c.stop(ErrClosed)
+			return nil
+		}
+
+		conn, err := c.dial(c.ctx)
+		if err == nil {
+			c.log.Info("Chat connection restored")
+			return conn
+		}
+
+		var handshakeErr *HandshakeError
+		if errors.As(err, &handshakeErr) && handshakeErr.permanent() {
+			c.stop(err)
+			return nil
+		}
+		if c.ctx.Err() != nil {
+			c.stop(ErrClosed)
+			return nil
+		}
+		c.log.Warn("Chat reconnect failed", "err", err, "retry_in", delay)
+		delay = min(delay*2, c.opts.MaxBackoff)
+	}
+}
+
+// read handles events from conn until it fails, pinging the server
+// meanwhile. A server that neither sends nor answers a ping within PongWait
+// fails the read.
+func (c *Client) read(conn *websocket.Conn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.ping(conn, stop)
+
+	for {
+		var event Event
+		conn.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+		if err := conn.ReadJSON(&event); err != nil {
+			return err
+		}
+		c.emit(event)
+	}
+}
+
+// ping sends pings on conn until stop is closed. A failed ping closes conn,
+// which ends the read.
+func (c *Client) ping(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.PongWait * 9 / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// emit answers pending requests and queues handlers for an event
+func (c *Client) emit(e Event) {
+	if c.resolve(e) {
+		return
+	}
+
+	queueHandlersFor(c, c.onEvent, e)
+	switch e.Type {
+	case "welcome":
+		queueHandlersFor(c, c.onWelcome, welcomeFromEvent(e))
+	case "send_message":
+		queueHandlersFor(c, c.onMessage, messageFromEvent(e))
+	case "command":
+		queueHandlersFor(c, c.onCommand, commandFromEvent(e))
+	case "user_joined", "user_left":
+		queueHandlersFor(c, c.onPresence, Presence{Channel: e.Channel, User: e.From, Joined: e.Type == "user_joined"})
+	case "announcement":
+		queueHandlersFor(c, c.onAnnouncement, e.Content)
+	case "error":
+		queueHandlersFor(c, c.onError, errorFromEvent(e))
+	}
+}
+
+// resolve hands an event to the requests waiting for it, reporting whether
+// it was consumed. Unrequested history is passed on to the handlers.
+func (c *Client) resolve(e Event) bool {
+	var keys []string
+	var err error
+	switch e.Type {
+	case "ack":
+		keys = []string{"ack:" + e.ID}
+	case "history", "files":
+		keys = []string{e.Type + ":" + e.Channel}
+	case "command_registered":
+		name, _ := e.Data["command"].(string)
+		keys = []string{"command:" + name}
+	case "subscribed", "commands":
+		keys = []string{e.Type}
+	case "profile":
+		username, _ := e.Data["username"].(string)
+		keys = []string{"profile:" + strings.ToLower(username)}
+	case "upload_ready", "upload_complete":
+		id, _ := e.Data["upload_id"].(string)
+		keys = []string{"upload:" + id}
+	case "error":
+		err = errorFromEvent(e)
+		if id, ok := e.Data["upload_id"].(string); ok {
+			keys = []string{"upload:" + id}
+		} else if id, ok := e.Data["id"].(string); ok {
+			keys = []string{"ack:" + id}
+		} else if name, ok := e.Data["command"].(string); ok {
+			keys = []string{"command:" + name}
+		} else if ch, ok := e.Data["channel"].(string); ok && e.Data["code"] == "not_joined" {
+			keys = []string{"history:" + ch, "files:" + ch}
+		} else if e.Data["code"] == "not_a_bot" || e.Data["code"] == "unknown_event" {
+			keys = []string{"subscribed"}
+		}
+	}
+
+	var waiters []chan result
+	c.mu.Lock()
+	for _, key := range keys {
+		waiters = append(waiters, c.waiters[key]...)
+		delete(c.waiters, key)
+	}
+	c.mu.Unlock()
+
+	for _, w := range waiters {
+		w <- result{event: e, err: err}
+	}
+	return len(waiters) > 0
+}
+
+// failWaiters ends every pending request. The caller must hold c.mu.
+func (c *Client) failWaiters() {
+	for key, waiters := range c.waiters {
+		for _, w := range waiters {
+			w <- result{err: ErrDisconnected}
+		}
+		delete(c.waiters, key)
+	}
+}
+
+// queueHandlersFor schedules handlers to be called with v on the dispatch goroutine
+func queueHandlersFor[T any](c *Client, handlers []func(T), v T) {
+	if len(handlers) == 0 {
+		return
+	}
+	select {
+	case c.queue <- func() {
+		for _, fn := range handlers {
+			fn(v)
+		}
+	}:
+	case <-c.done:
+	}
+}
+
+// dispatch calls queued handlers until the client stops
+func (c *Client) dispatch() {
+	for {
+		select {
+		case fn := <-c.queue:
+			fn()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// stop ends the client for good, recording why
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.stopErr = err
+	close(c.done)
+	c.cancel()
+}
+
+// request sends an event and waits for the answer filed under key
+func (c *Client) request(ctx context.Context, key string, event Event) (Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RequestTimeout)
+	defer cancel()
+
+	w := make(chan result, 1)
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return Event{}, c.notConnected()
+	}
+	c.waiters[key] = append(c.waiters[key], w)
+	c.mu.Unlock()
+
+	if err := c.write(event); err != nil {
+		c.dropWaiter(key, w)
+		return Event{}, err
+	}
+
+	select {
+	case r := <-w:
+		return r.event, r.err
+	case <-ctx.Done():
+		c.dropWaiter(key, w)
+		return Event{}, ctx.Err()
+	}
+}
+
+// dropWaiter forgets a request that gave up
+func (c *Client) dropWaiter(key string, w chan result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	waiters := c.waiters[key]
+	for i, other := range waiters {
+		if other == w {
+			c.waiters[key] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(c.waiters[key]) == 0 {
+		delete(c.waiters, key)
+	}
+}
+
+// write sends an event on the current connection
+func (c *Client) write(event Event) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return c.notConnected()
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteJSON(event)
+}
+
+// notConnected returns ErrClosed after Close and ErrNotConnected otherwise
+func (c *Client) notConnected() error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	return ErrNotConnected
+}
+
+// Send posts a message to a channel and waits for the server to accept it
+func (c *Client) Send(ctx context.Context, channel, content string) (Message, error) {
+	return c.send(ctx, channel, content, nil)
+}
+
+// send posts a message with the given files attached
+func (c *Client) send(ctx context.Context, channel, content string, files []File) (Message, error) {
+	id := newID()
+	data := map[string]interface{}{"ack": true}
+	if len(files) > 0 {
+		ids := make([]string, len(files))
+		for i, f := range files {
+			ids[i] = f.ID
+		}
+		data["files"] = ids
+	}
+	ack, err := c.request(ctx, "ack:"+id, Event{
+		ID:      id,
+		Type:    "send_message",
+		Channel: channel,
+		Content: content,
+		Data:    data,
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		ID:      id,
+		Channel: channel,
+		From:    c.Welcome().Username,
+		Content: content,
+		Time:    eventTime(ack.Timestamp),
+		Bot:     c.Welcome().Bot,
+		Files:   files,
+	}, nil
+}
+
+// Reply answers a command in the channel it was invoked from
+func (c *Client) Reply(ctx context.Context, cmd Command, content string) error {
+	_, err := c.Send(ctx, cmd.Channel, content)
+	return err
+}
+
+// History fetches a joined channel's recent messages, oldest first
+func (c *Client) History(ctx context.Context, channel string) ([]Message, error) {
+	e, err := c.request(ctx, "history:"+channel, Event{Type: "get_history", Channel: channel})
+	if err != nil {
+		return nil, err
+	}
+	return historyFromEvent(e), nil
+}
+
+// Profile fetches a user's profile, or this client's own if username is
+// empty. Users who never filled one in have an empty profile.
+func (c *Client) Profile(ctx context.Context, username string) (Profile, error) {
+	if username == "" {
+		username = c.Welcome().Username
+	}
+	e, err := c.request(ctx, "profile:"+strings.ToLower(username), Event{
+		Type: "get_profile",
+		Data: map[string]interface{}{"username": username},
+	})
+	if err != nil {
+		return Profile{}, err
+	}
+	return profileFromEvent(e), nil
+}
+
+// Join joins a channel now, if connected, and after every reconnect
+func (c *Client) Join(channel string) error {
+	c.mu.Lock()
+	c.channels[channel] = true
+	c.mu.Unlock()
+	return c.write(Event{Type: "join_channel", Channel: channel})
+}
+
+// Leave leaves a channel and stops rejoining it
+func (c *Client) Leave(channel string) error {
+	c.mu.Lock()
+	delete(c.channels, channel)
+	c.mu.Unlock()
+	return c.write(Event{Type: "leave_channel", Channel: channel})
+}
+
+// Subscribe replaces the event types a bot receives, now and after every
+// reconnect
+func (c *Client) Subscribe(ctx context.Context, events ...string) error {
+	if events == nil {
+		events = []string{}
+	}
+	_, err := c.request(ctx, "subscribed", Event{Type: "subscribe", Data: map[string]interface{}{"events": events}})
+	if err == nil {
+		c.mu.Lock()
+		c.opts.Subscribe = events
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// RegisterCommand registers a slash command for this bot, now and after
+// every reconnect. Invocations are passed to the OnCommand handlers.
+func (c *Client) RegisterCommand(ctx context.Context, cmd CommandSpec) error {
+	if _, err := c.request(ctx, "command:"+cmd.Name, registerEvent(cmd)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.commands[cmd.Name] = cmd
+	c.mu.Unlock()
+	return nil
+}
+
+// UnregisterCommand removes a slash command registered by this bot
+func (c *Client) UnregisterCommand(name string) error {
+	c.mu.Lock()
+	delete(c.commands, name)
+	c.mu.Unlock()
+	return c.write(Event{Type: "unregister_command", Data: map[string]interface{}{"command": name}})
+}
+
+// Commands lists the slash commands registered on the server
+func (c *Client) Commands(ctx context.Context) ([]CommandSpec, error) {
+	e, err := c.request(ctx, "commands", Event{Type: "get_commands"})
+	if err != nil {
+		return nil, err
+	}
+	var commands []CommandSpec
+	decodeData(e.Data["commands"], &commands)
+	return commands, nil
+}
+
+// registerEvent builds a register_command request
+func registerEvent(cmd CommandSpec) Event {
+	return Event{Type: "register_command", Data: map[string]interface{}{
+		"command":     cmd.Name,
+		"description": cmd.Description,
+		"usage":       cmd.Usage,
+	}}
+}
+
+// Welcome returns what the server said on the latest connect
+func (c *Client) Welcome() Welcome {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome
+}
+
+// Connected reports whether the client currently has a connection
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Done is closed once the client has stopped for good, after Close or a
+// disconnect it will not recover from
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, once Done is closed
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopErr
+}
+
+// Close disconnects and stops reconnecting
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.writeMu.Lock()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+		c.writeMu.Unlock()
+		conn.Close()
+	}
+	c.stop(ErrClosed)
+	return nil
+}
+
+// newID returns a random ID for an outgoing message
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
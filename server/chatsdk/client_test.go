@@ -0,0 +1,224 @@
+package chatsdk_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"terminal-chat/server/chatsdk"
+	"terminal-chat/server/chatsdk/bot/bottest"
+)
+
+const timeout = 5 * time.Second
+
+// connect connects a client, closing the client when the test ends
+func connect(t *testing.T, opts chatsdk.Options) *chatsdk.Client {
+	t.Helper()
+	c := chatsdk.New(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestSendAndHistory(t *testing.T) {
+	srv := bottest.NewServer(t)
+	alice := connect(t, chatsdk.Options{URL: srv.URL, Username: "alice", Channels: []string{"general"}})
+	if w := alice.Welcome(); w.Username != "alice" || w.Bot || w.MaxContentLength == 0 {
+		t.Errorf("welcome = %+v", w)
+	}
+
+	ctx := context.Background()
+	sent, err := alice.Send(ctx, "general", "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	history, err := alice.History(ctx, "general")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 1 || history[0].ID != sent.ID || history[0].Content != "hello" || history[0].From != "alice" {
+		t.Errorf("history = %+v, want the sent message", history)
+	}
+
+	_, err = alice.Send(ctx, "nowhere", "hello")
+	var serverErr *chatsdk.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != "not_found" {
+		t.Errorf("sending to an unknown channel: %v", err)
+	}
+}
+
+func TestReconnectRejoins(t *testing.T) {
+	srv := bottest.NewServer(t)
+	welcomes := make(chan chatsdk.Welcome, 4)
+	disconnects := make(chan chatsdk.Disconnected, 4)
+	c := chatsdk.New(chatsdk.Options{
+		URL:        srv.URL,
+		Username:   "alice",
+		Channels:   []string{"general"},
+		MinBackoff: 10 * time.Millisecond,
+	})
+	c.OnWelcome(func(w chatsdk.Welcome) { welcomes <- w })
+	c.OnDisconnect(func(d chatsdk.Disconnected) { disconnects <- d })
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer c.Close()
+	<-welcomes
+
+	sessions := srv.Hub.Sessions()
+	if len(sessions) != 1 || !srv.Hub.Disconnect(sessions[0].ID, "take a break") {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	select {
+	case d := <-disconnects:
+		if d.Code != chatsdk.CloseKicked || d.Reason != "take a break" || !d.Reconnecting {
+			t.Errorf("disconnected = %+v", d)
+		}
+	case <-ctx.Done():
+		t.Fatal("no disconnect reported")
+	}
+	select {
+	case <-welcomes:
+	case <-ctx.Done():
+		t.Fatal("did not reconnect")
+	}
+
+	// The channel was joined again: history is only sent to members
+	if _, err := c.History(ctx, "general"); err != nil {
+		t.Errorf("History after reconnecting: %v", err)
+	}
+}
+
+func TestSubscribeRestoredOnReconnect(t *testing.T) {
+	srv := bottest.NewServer(t)
+	_, token, err := srv.Bots.Create("newsbot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	welcomes := make(chan chatsdk.Welcome, 4)
+	events := make(chan chatsdk.Event, 16)
+	c := chatsdk.New(chatsdk.Options{URL: srv.URL, Token: token, Channels: []string{"general"}, MinBackoff: 10 * time.Millisecond})
+	c.OnWelcome(func(w chatsdk.Welcome) { welcomes <- w })
+	c.OnEvent(func(e chatsdk.Event) {
+		if e.Type == "announcement" || e.Type == "send_message" {
+			events <- e
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer c.Close()
+	<-welcomes
+
+	if err := c.Subscribe(ctx, "send_message"); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if srv.Hub.DisconnectBot("newsbot", "take a break") != 1 {
+		t.Fatal("bot not connected")
+	}
+	select {
+	case <-welcomes:
+	case <-ctx.Done():
+		t.Fatal("did not reconnect")
+	}
+
+	// The announcement is filtered out; the message after it is not
+	alice := srv.User("alice", "general")
+	srv.Hub.Announce("not for bots")
+	alice.Say("general", "hello")
+	select {
+	case e := <-events:
+		if e.Type != "send_message" || e.Content != "hello" {
+			t.Errorf("received %s %q, want only the message", e.Type, e.Content)
+		}
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+}
+
+// fakeServer accepts connections that request the subprotocol, sends each a
+// welcome and hands it to serve
+func fakeServer(t *testing.T, serve func(conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{Subprotocols: []string{chatsdk.Subprotocol}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(websocket.Subprotocols(r), chatsdk.Subprotocol) {
+			http.Error(w, "missing subprotocol", http.StatusBadRequest)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(chatsdk.Event{Type: "welcome", Data: map[string]interface{}{"username": "alice"}})
+		serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestSilentServerDropped(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// The server never reads, so pings go unanswered
+	url := fakeServer(t, func(conn *websocket.Conn) { <-release })
+
+	disconnects := make(chan chatsdk.Disconnected, 1)
+	c := chatsdk.New(chatsdk.Options{URL: url, Username: "alice", NoReconnect: true, PongWait: 100 * time.Millisecond})
+	c.OnDisconnect(func(d chatsdk.Disconnected) { disconnects <- d })
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	defer c.Close()
+
+	select {
+	case <-c.Done():
+	case <-ctx.Done():
+		t.Fatal("client still connected to a silent server")
+	}
+	if d := <-disconnects; d.Code != 0 || d.Reconnecting {
+		t.Errorf("disconnected = %+v", d)
+	}
+	if c.Connected() {
+		t.Error("Connected after the connection was dropped")
+	}
+}
+
+func TestPongsKeepConnection(t *testing.T) {
+	// The server reads, answering pings, but sends nothing
+	url := fakeServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	})
+
+	c := connect(t, chatsdk.Options{URL: url, Username: "alice", NoReconnect: true, PongWait: 100 * time.Millisecond})
+	select {
+	case <-c.Done():
+		t.Fatalf("client stopped: %v", c.Err())
+	case <-time.After(500 * time.Millisecond):
+	}
+	if !c.Connected() {
+		t.Error("client disconnected from a server answering pings")
+	}
+}
@@ -0,0 +1,260 @@
+package chatsdk
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a message exchanged with the server, as sent on the wire. Most
+// programs use the decoded types below; OnEvent receives every event as is.
+type Event struct {
+	ID        string                 `json:"id,omitempty"`
+	Type      string                 `json:"type"`
+	Channel   string                 `json:"channel,omitempty"`
+	From      string                 `json:"from,omitempty"`
+	Timestamp int64                  `json:"timestamp,omitempty"`
+	Content   string                 `json:"content,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Close codes the server ends a session with, found in Disconnected.Code
+const (
+	CloseServerRestart = 1012
+	CloseSlowConsumer  = 1013
+	CloseKicked        = 4000
+	CloseBanned        = 4003
+)
+
+// File is an uploaded file's metadata
+type File struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	MIME string `json:"mime"`
+
+	// SHA256 is the hex digest of the contents
+	SHA256 string `json:"sha256"`
+
+	// Channel is where the file may be posted and listed
+	Channel   string    `json:"channel"`
+	Uploader  string    `json:"uploader"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Welcome is sent by the server when a connection is established
+type Welcome struct {
+	Username         string
+	Bot              bool
+	Channels         []string
+	MaxContentLength int
+	MaxMessageSize   int
+	MaxFileSize      int64
+
+	// Topics holds the topic and description of channels that have one
+	Topics map[string]Topic
+}
+
+// Topic is a channel's topic and longer description
+type Topic struct {
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Message is a chat message in a channel
+type Message struct {
+	ID      string
+	Channel string
+	From    string
+	Content string
+	Time    time.Time
+
+	// DisplayName is the sender's chosen name when the message was sent,
+	// if they had one
+	DisplayName string
+
+	// Bot is set for messages from bot accounts and incoming webhooks
+	Bot         bool
+	Attachments []Attachment
+
+	// Files are the uploaded files the message carries
+	Files []File
+
+	// Action is set for messages posted with /me
+	Action bool
+}
+
+// Attachment is a card posted with a message
+type Attachment struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// Command is a slash command invocation sent to the bot that registered it
+type Command struct {
+	ID      string
+	Channel string
+	From    string
+
+	// Name is the command without its slash and Args the rest of the line
+	Name string
+	Args string
+
+	// Text is the message as typed, such as "/deploy api prod"
+	Text string
+
+	// Session is the invoking user's session
+	Session string
+	Time    time.Time
+}
+
+// Profile is what a user tells others about themselves, with whether they
+// are connected. Every field the user sets is optional.
+type Profile struct {
+	Username string `json:"-"`
+
+	DisplayName string `json:"display_name,omitempty"`
+	Pronouns    string `json:"pronouns,omitempty"`
+	StatusEmoji string `json:"status_emoji,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+
+	// Timezone is an IANA name such as Europe/Berlin
+	Timezone string `json:"timezone,omitempty"`
+
+	// Color is the preferred color of the user's name, as #rrggbb
+	Color string `json:"color,omitempty"`
+
+	Online bool `json:"-"`
+	Bot    bool `json:"-"`
+}
+
+// Presence reports a user joining or leaving a channel
+type Presence struct {
+	Channel string
+	User    string
+	Joined  bool
+}
+
+// ServerError is an error event from the server
+type ServerError struct {
+	Code    string
+	Message string
+
+	// Data holds the rest of the error's details, such as limits
+	Data map[string]interface{}
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Disconnected reports why a connection ended
+type Disconnected struct {
+	// Code is the WebSocket close code, or 0 if the connection was lost
+	Code   int
+	Reason string
+
+	// Reconnecting is set if the client will try to connect again
+	Reconnecting bool
+}
+
+// eventTime converts a Unix timestamp, leaving zero for a missing one
+func eventTime(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+// messageFromEvent decodes a send_message event
+func messageFromEvent(e Event) Message {
+	msg := Message{
+		ID:      e.ID,
+		Channel: e.Channel,
+		From:    e.From,
+		Content: e.Content,
+		Time:    eventTime(e.Timestamp),
+	}
+	msg.Bot, _ = e.Data["bot"].(bool)
+	msg.Action, _ = e.Data["action"].(bool)
+	msg.DisplayName, _ = e.Data["display_name"].(string)
+	decodeData(e.Data["attachments"], &msg.Attachments)
+	decodeData(e.Data["files"], &msg.Files)
+	return msg
+}
+
+// commandFromEvent decodes a command event
+func commandFromEvent(e Event) Command {
+	cmd := Command{
+		ID:      e.ID,
+		Channel: e.Channel,
+		From:    e.From,
+		Text:    e.Content,
+		Time:    eventTime(e.Timestamp),
+	}
+	cmd.Name, _ = e.Data["command"].(string)
+	cmd.Args, _ = e.Data["args"].(string)
+	cmd.Session, _ = e.Data["session"].(string)
+	return cmd
+}
+
+// welcomeFromEvent decodes a welcome event
+func welcomeFromEvent(e Event) Welcome {
+	var w Welcome
+	w.Username, _ = e.Data["username"].(string)
+	w.Bot, _ = e.Data["bot"].(bool)
+	decodeData(e.Data["channels"], &w.Channels)
+	decodeData(e.Data["topics"], &w.Topics)
+	if n, ok := e.Data["max_content_length"].(float64); ok {
+		w.MaxContentLength = int(n)
+	}
+	if n, ok := e.Data["max_message_size"].(float64); ok {
+		w.MaxMessageSize = int(n)
+	}
+	if n, ok := e.Data["max_file_size"].(float64); ok {
+		w.MaxFileSize = int64(n)
+	}
+	return w
+}
+
+// historyFromEvent decodes the messages of a history event
+func historyFromEvent(e Event) []Message {
+	var events []Event
+	decodeData(e.Data["messages"], &events)
+
+	msgs := make([]Message, 0, len(events))
+	for _, m := range events {
+		msgs = append(msgs, messageFromEvent(m))
+	}
+	return msgs
+}
+
+// profileFromEvent decodes a profile event
+func profileFromEvent(e Event) Profile {
+	p := Profile{}
+	p.Username, _ = e.Data["username"].(string)
+	p.Online, _ = e.Data["online"].(bool)
+	p.Bot, _ = e.Data["bot"].(bool)
+	decodeData(e.Data["profile"], &p)
+	return p
+}
+
+// errorFromEvent decodes an error event
+func errorFromEvent(e Event) *ServerError {
+	code, _ := e.Data["code"].(string)
+	return &ServerError{Code: code, Message: e.Content, Data: e.Data}
+}
+
+// decodeData converts a generic JSON value from an event's data into v
+func decodeData(value interface{}, v interface{}) {
+	if value == nil {
+		return
+	}
+	if data, err := json.Marshal(value); err == nil {
+		json.Unmarshal(data, v)
+	}
+}
@@ -0,0 +1,118 @@
+package chatsdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Upload sends size bytes from r to the server as a file for channel, in
+// chunks, and returns its metadata. Post it with SendFiles.
+func (c *Client) Upload(ctx context.Context, channel, name string, r io.Reader, size int64) (File, error) {
+	id := newID()
+	key := "upload:" + id
+	ready, err := c.request(ctx, key, Event{
+		Type:    "upload_start",
+		Channel: channel,
+		Data:    map[string]interface{}{"upload_id": id, "name": name, "size": size},
+	})
+	if err != nil {
+		return File{}, err
+	}
+	chunkSize, _ := ready.Data["chunk_size"].(float64)
+	if chunkSize <= 0 {
+		return File{}, errors.New("chatsdk: server sent no chunk size")
+	}
+
+	hash := sha256.New()
+	buf := make([]byte, int(chunkSize))
+	var offset int64
+	for offset < size {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-offset)])
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			hash.Write(buf[:n])
+			err = c.write(Event{Type: "upload_chunk", Data: map[string]interface{}{
+				"upload_id": id,
+				"offset":    offset,
+				"data":      base64.StdEncoding.EncodeToString(buf[:n]),
+			}})
+		}
+		if err != nil {
+			c.write(Event{Type: "upload_cancel", Data: map[string]interface{}{"upload_id": id}})
+			return File{}, fmt.Errorf("uploading %s: %w", name, err)
+		}
+		offset += int64(n)
+	}
+
+	done, err := c.request(ctx, key, Event{Type: "upload_finish", Data: map[string]interface{}{
+		"upload_id": id,
+		"sha256":    hex.EncodeToString(hash.Sum(nil)),
+	}})
+	if err != nil {
+		return File{}, err
+	}
+	var f File
+	decodeData(done.Data["file"], &f)
+	return f, nil
+}
+
+// SendFiles posts a message carrying uploaded files to their channel and
+// waits for the server to accept it
+func (c *Client) SendFiles(ctx context.Context, channel, content string, files ...File) (Message, error) {
+	return c.send(ctx, channel, content, files)
+}
+
+// Files lists the files uploaded to a joined channel, oldest first
+func (c *Client) Files(ctx context.Context, channel string) ([]File, error) {
+	e, err := c.request(ctx, "files:"+channel, Event{Type: "list_files", Channel: channel})
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	decodeData(e.Data["files"], &files)
+	return files, nil
+}
+
+// FileURL returns where a file is downloaded from over HTTP
+func (c *Client) FileURL(id string) (string, error) {
+	u, err := url.Parse(c.opts.URL)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	u.Path = strings.TrimSuffix(u.Path, "/ws") + "/files/" + url.PathEscape(id)
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// Download opens a file's contents. The caller must close the reader.
+func (c *Client) Download(ctx context.Context, id string) (io.ReadCloser, error) {
+	fileURL, err := c.FileURL(id)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: c.opts.TLSConfig, DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("chatsdk: downloading %s: %s", id, resp.Status)
+	}
+	return resp.Body, nil
+}
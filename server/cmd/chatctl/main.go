@@ -0,0 +1,764 @@
+// Command chatctl manages a running chat server through its admin API.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"terminal-chat/server/bots"
+	"terminal-chat/server/hub"
+	"terminal-chat/server/models"
+	"terminal-chat/server/storage"
+	"terminal-chat/server/webhook"
+)
+
+const usage = `Usage: chatctl [flags] <command> [args]
+
+Commands:
+  users                                  list connected users
+  sessions                               list connected sessions
+  channels                               list channels with member counts
+  channel <id>                           show a channel's members
+  create-channel <id> [name]             create or restore a channel
+  archive-channel <id>                   archive a channel
+  tail [-n lines] <channel>              follow a channel's messages
+  kick [-reason text] <session|user>     disconnect a session, or every session of a user
+  ban [-reason text] [-for 24h] <user>   ban a user and disconnect them
+  unban <user>                           lift a ban
+  bans                                   list bans
+  announce <text>                        send an announcement to everyone
+  rotate-token                           replace the admin token and print the new one
+  export [-o file] [channel...]          export channel history (all channels by default)
+  backup [-download file]                save a backup of the server state
+  backups                                list backups
+  webhooks                               list outgoing webhooks
+  create-webhook [-channels a,b] [-events e,f] [-secret s] <url>
+                                         register an outgoing webhook and print its secret
+  delete-webhook <id>                    remove an outgoing webhook
+  deliveries <webhook>                   show a webhook's recent deliveries
+  dead-letters [-n count]                show deliveries that failed for good
+  incoming-webhooks [channel]            list incoming webhooks
+  create-incoming-webhook [-name bot] <channel>
+                                         create an incoming webhook and print its URL
+  delete-incoming-webhook <id>           remove an incoming webhook
+  bots                                   list bot accounts
+  create-bot [-description text] <name>  create a bot account and print its token
+  delete-bot <name>                      remove a bot account and disconnect it
+  rotate-bot-token <name>                replace a bot's token and print the new one
+  commands                               list slash commands registered by bots
+
+Flags:
+`
+
+// cli holds what every command needs
+type cli struct {
+	api *apiClient
+	out *printer
+}
+
+func main() {
+	fs := flag.NewFlagSet("chatctl", flag.ExitOnError)
+	server := fs.String("server", envOr("CHATCTL_SERVER", "http://localhost:8080"), "server base URL (CHATCTL_SERVER)")
+	token := fs.String("token", "", "admin token (default $CHAT_ADMIN_TOKEN)")
+	caFile := fs.String("ca", "", "PEM file with a CA certificate to trust")
+	output := fs.String("output", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+	if *token == "" {
+		*token = os.Getenv("CHAT_ADMIN_TOKEN")
+	}
+	if *token == "" {
+		fatalf("no admin token: set -token or CHAT_ADMIN_TOKEN")
+	}
+
+	api, err := newAPIClient(*server, *token, *caFile)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	c := &cli{api: api, out: &printer{w: os.Stdout, json: *output == "json"}}
+
+	commands := map[string]func(args []string) error{
+		"users":           c.users,
+		"sessions":        c.sessions,
+		"channels":        c.channels,
+		"channel":         c.channel,
+		"create-channel":  c.createChannel,
+		"archive-channel": c.archiveChannel,
+		"tail":            c.tail,
+		"kick":            c.kick,
+		"ban":             c.ban,
+		"unban":           c.unban,
+		"bans":            c.bans,
+		"announce":        c.announce,
+		"rotate-token":    c.rotateToken,
+		"export":          c.export,
+		"backup":          c.backup,
+		"backups":         c.backups,
+		"webhooks":        c.webhooks,
+		"create-webhook":  c.createWebhook,
+		"delete-webhook":  c.deleteWebhook,
+		"deliveries":      c.deliveries,
+		"dead-letters":    c.deadLetters,
+
+		"incoming-webhooks":       c.incomingWebhooks,
+		"create-incoming-webhook": c.createIncomingWebhook,
+		"delete-incoming-webhook": c.deleteIncomingWebhook,
+		"bots":                    c.bots,
+		"create-bot":              c.createBot,
+		"delete-bot":              c.deleteBot,
+		"rotate-bot-token":        c.rotateBotToken,
+		"commands":                c.commands,
+	}
+
+	name, args := fs.Arg(0), fs.Args()[1:]
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "chatctl: unknown command %q\n\n", name)
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		fatalf("%s: %v", name, err)
+	}
+}
+
+func (c *cli) users(args []string) error {
+	var users []hub.UserInfo
+	if err := c.api.call(http.MethodGet, "/admin/users", nil, &users); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, u := range users {
+		rows = append(rows, []string{u.Username, strconv.Itoa(u.Sessions), orDash(strings.Join(u.Channels, ",")), since(u.ConnectedAt)})
+	}
+	return c.out.print(users, []string{"USER", "SESSIONS", "CHANNELS", "CONNECTED"}, rows)
+}
+
+func (c *cli) sessions(args []string) error {
+	sessions, err := c.listSessions()
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, s := range sessions {
+		rows = append(rows, []string{
+			s.ID, s.Username, orDash(s.RemoteAddr), orDash(strings.Join(s.Channels, ",")), since(s.ConnectedAt),
+			fmt.Sprintf("%d/%d", s.Outbox.Depth, s.Outbox.Capacity), strconv.FormatUint(s.Outbox.Dropped, 10),
+		})
+	}
+	return c.out.print(sessions, []string{"SESSION", "USER", "REMOTE", "CHANNELS", "CONNECTED", "QUEUED", "DROPPED"}, rows)
+}
+
+// listSessions fetches the sessions sorted by username
+func (c *cli) listSessions() ([]hub.SessionStats, error) {
+	var sessions []hub.SessionStats
+	if err := c.api.call(http.MethodGet, "/admin/sessions", nil, &sessions); err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Username != sessions[j].Username {
+			return sessions[i].Username < sessions[j].Username
+		}
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions, nil
+}
+
+func (c *cli) channels(args []string) error {
+	var channels []hub.ChannelInfo
+	if err := c.api.call(http.MethodGet, "/admin/channels", nil, &channels); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, ch := range channels {
+		rows = append(rows, []string{ch.ID, ch.Name, channelState(ch), strconv.Itoa(len(ch.Members)), strconv.Itoa(ch.History)})
+	}
+	return c.out.print(channels, []string{"CHANNEL", "NAME", "STATE", "MEMBERS", "HISTORY"}, rows)
+}
+
+// channelState describes whether a channel is offered to clients
+func channelState(ch hub.ChannelInfo) string {
+	switch {
+	case ch.Archived:
+		return "archived"
+	case !ch.Listed:
+		return "unlisted"
+	}
+	return "active"
+}
+
+func (c *cli) channel(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: channel <id>")
+	}
+
+	var ch hub.ChannelInfo
+	if err := c.api.call(http.MethodGet, "/admin/channels/"+pathEscape(args[0]), nil, &ch); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, m := range ch.Members {
+		rows = append(rows, []string{m.Username, m.Session})
+	}
+	return c.out.print(ch, []string{"USER", "SESSION"}, rows)
+}
+
+func (c *cli) createChannel(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: create-channel <id> [name]")
+	}
+	req := map[string]string{"id": args[0]}
+	if len(args) == 2 {
+		req["name"] = args[1]
+	}
+
+	var ch models.Channel
+	if err := c.api.call(http.MethodPost, "/admin/channels", req, &ch); err != nil {
+		return err
+	}
+	return c.out.message(ch, "Channel #%s is available", ch.ID)
+}
+
+func (c *cli) archiveChannel(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: archive-channel <id>")
+	}
+	if err := c.api.call(http.MethodPost, "/admin/channels/"+pathEscape(args[0])+"/archive", nil, nil); err != nil {
+		return err
+	}
+	return c.out.message(map[string]string{"archived": args[0]}, "Archived #%s", args[0])
+}
+
+func (c *cli) tail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	lines := fs.Int("n", 10, "number of recent messages to show first")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: tail [-n lines] <channel>")
+	}
+
+	path := fmt.Sprintf("/admin/channels/%s/tail?backlog=%d", pathEscape(fs.Arg(0)), *lines)
+	resp, err := c.api.do(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			// Keepalive
+			continue
+		}
+		if c.out.json {
+			fmt.Printf("%s\n", line)
+			continue
+		}
+
+		var event models.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("bad event from server: %w", err)
+		}
+		if event.Type == "send_message" {
+			fmt.Println(formatMessage(event))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("server ended the stream")
+}
+
+// formatMessage renders a chat message as a transcript line
+func formatMessage(event models.Event) string {
+	ts := time.Unix(event.Timestamp, 0).Format("2006-01-02 15:04:05")
+	return fmt.Sprintf("[%s] #%s <%s> %s", ts, event.Channel, event.From, event.Content)
+}
+
+func (c *cli) kick(args []string) error {
+	fs := flag.NewFlagSet("kick", flag.ExitOnError)
+	reason := fs.String("reason", "", "reason shown to the user")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: kick [-reason text] <session|user>")
+	}
+	target := fs.Arg(0)
+
+	sessions, err := c.listSessions()
+	if err != nil {
+		return err
+	}
+
+	// A session ID kicks that session; a username kicks all of theirs
+	var ids []string
+	for _, s := range sessions {
+		if s.ID == target {
+			ids = []string{s.ID}
+			break
+		}
+		if s.Username == target {
+			ids = append(ids, s.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no session or connected user %q", target)
+	}
+
+	for _, id := range ids {
+		body := map[string]string{"reason": *reason}
+		if err := c.api.call(http.MethodPost, "/admin/sessions/"+pathEscape(id)+"/disconnect", body, nil); err != nil {
+			return err
+		}
+	}
+	return c.out.message(map[string]interface{}{"disconnected": ids}, "Disconnected %d session(s)", len(ids))
+}
+
+func (c *cli) ban(args []string) error {
+	fs := flag.NewFlagSet("ban", flag.ExitOnError)
+	reason := fs.String("reason", "", "reason shown to the user")
+	duration := fs.String("for", "", "ban duration such as 24h (default permanent)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: ban [-reason text] [-for 24h] <user>")
+	}
+
+	req := map[string]string{"username": fs.Arg(0), "reason": *reason, "duration": *duration}
+	var resp struct {
+		models.Ban
+		Disconnected int `json:"disconnected"`
+	}
+	if err := c.api.call(http.MethodPost, "/admin/bans", req, &resp); err != nil {
+		return err
+	}
+
+	until := "permanently"
+	if !resp.ExpiresAt.IsZero() {
+		until = "until " + resp.ExpiresAt.Local().Format(time.RFC1123)
+	}
+	return c.out.message(resp, "Banned %s %s; disconnected %d session(s)", resp.Username, until, resp.Disconnected)
+}
+
+func (c *cli) unban(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: unban <user>")
+	}
+	if err := c.api.call(http.MethodDelete, "/admin/bans/"+pathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	return c.out.message(map[string]string{"unbanned": args[0]}, "Unbanned %s", args[0])
+}
+
+func (c *cli) bans(args []string) error {
+	var bans []models.Ban
+	if err := c.api.call(http.MethodGet, "/admin/bans", nil, &bans); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, b := range bans {
+		expires := "never"
+		if !b.ExpiresAt.IsZero() {
+			expires = b.ExpiresAt.Local().Format(time.DateTime)
+		}
+		rows = append(rows, []string{b.Username, orDash(b.Reason), b.CreatedAt.Local().Format(time.DateTime), expires})
+	}
+	return c.out.print(bans, []string{"USER", "REASON", "SINCE", "EXPIRES"}, rows)
+}
+
+func (c *cli) announce(args []string) error {
+	content := strings.Join(args, " ")
+	if strings.TrimSpace(content) == "" {
+		return errors.New("usage: announce <text>")
+	}
+
+	var resp struct {
+		Sessions int `json:"sessions"`
+	}
+	if err := c.api.call(http.MethodPost, "/admin/announcements", map[string]string{"content": content}, &resp); err != nil {
+		return err
+	}
+	return c.out.message(resp, "Announced to %d session(s)", resp.Sessions)
+}
+
+func (c *cli) rotateToken(args []string) error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.api.call(http.MethodPost, "/admin/token/rotate", nil, &resp); err != nil {
+		return err
+	}
+	return c.out.message(resp, "%s\nThe previous token no longer works.", resp.Token)
+}
+
+func (c *cli) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	outFile := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	channelIDs := fs.Args()
+	if len(channelIDs) == 0 {
+		var channels []hub.ChannelInfo
+		if err := c.api.call(http.MethodGet, "/admin/channels", nil, &channels); err != nil {
+			return err
+		}
+		for _, ch := range channels {
+			channelIDs = append(channelIDs, ch.ID)
+		}
+	}
+
+	history := make(map[string][]models.Event)
+	for _, id := range channelIDs {
+		var events []models.Event
+		if err := c.api.call(http.MethodGet, "/admin/channels/"+pathEscape(id)+"/history", nil, &events); err != nil {
+			return fmt.Errorf("#%s: %w", id, err)
+		}
+		history[id] = events
+	}
+
+	var w io.Writer = os.Stdout
+	if *outFile != "" {
+		f, err := os.OpenFile(*outFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if c.out.json {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(history)
+	}
+
+	// Plain transcript, one channel after another
+	for _, id := range channelIDs {
+		for _, event := range history[id] {
+			if _, err := fmt.Fprintln(w, formatMessage(event)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cli) backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	download := fs.String("download", "", "also save a copy of the backup to this file")
+	fs.Parse(args)
+
+	var backup storage.Backup
+	if err := c.api.call(http.MethodPost, "/admin/backups", nil, &backup); err != nil {
+		return err
+	}
+
+	if *download != "" {
+		if err := c.downloadBackup(backup.Name, *download); err != nil {
+			return err
+		}
+	}
+	return c.out.message(backup, "Saved backup %s (%d bytes)", backup.Name, backup.Size)
+}
+
+// downloadBackup copies a backup from the server to a local file
+func (c *cli) downloadBackup(name, path string) error {
+	resp, err := c.api.do(http.MethodGet, "/admin/backups/"+pathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *cli) backups(args []string) error {
+	var backups []storage.Backup
+	if err := c.api.call(http.MethodGet, "/admin/backups", nil, &backups); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, b := range backups {
+		rows = append(rows, []string{b.Name, strconv.FormatInt(b.Size, 10), b.SavedAt.Local().Format(time.DateTime)})
+	}
+	return c.out.print(backups, []string{"NAME", "BYTES", "SAVED"}, rows)
+}
+
+func (c *cli) webhooks(args []string) error {
+	var subs []webhook.Subscription
+	if err := c.api.call(http.MethodGet, "/admin/webhooks", nil, &subs); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, s := range subs {
+		channels := strings.Join(s.Channels, ",")
+		if channels == "" {
+			channels = "*"
+		}
+		rows = append(rows, []string{s.ID, s.URL, channels, strings.Join(s.Events, ","), s.CreatedAt.Local().Format(time.DateTime)})
+	}
+	return c.out.print(subs, []string{"WEBHOOK", "URL", "CHANNELS", "EVENTS", "CREATED"}, rows)
+}
+
+func (c *cli) createWebhook(args []string) error {
+	fs := flag.NewFlagSet("create-webhook", flag.ExitOnError)
+	channels := fs.String("channels", "", "comma-separated channels to deliver (default all)")
+	events := fs.String("events", "", "comma-separated event types to deliver (default send_message)")
+	secret := fs.String("secret", "", "signing secret (default generated)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: create-webhook [-channels a,b] [-events e,f] [-secret s] <url>")
+	}
+
+	req := map[string]interface{}{
+		"url":      fs.Arg(0),
+		"secret":   *secret,
+		"channels": splitList(*channels),
+		"events":   splitList(*events),
+	}
+	var sub webhook.Subscription
+	if err := c.api.call(http.MethodPost, "/admin/webhooks", req, &sub); err != nil {
+		return err
+	}
+	return c.out.message(sub, "Registered webhook %s\nSigning secret: %s", sub.ID, sub.Secret)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (c *cli) deleteWebhook(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete-webhook <id>")
+	}
+	if err := c.api.call(http.MethodDelete, "/admin/webhooks/"+pathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	return c.out.message(map[string]string{"deleted": args[0]}, "Deleted webhook %s", args[0])
+}
+
+func (c *cli) deliveries(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: deliveries <webhook>")
+	}
+
+	var deliveries []webhook.Delivery
+	if err := c.api.call(http.MethodGet, "/admin/webhooks/"+pathEscape(args[0])+"/deliveries", nil, &deliveries); err != nil {
+		return err
+	}
+	return c.out.print(deliveries, deliveryHeader, deliveryRows(deliveries))
+}
+
+func (c *cli) deadLetters(args []string) error {
+	fs := flag.NewFlagSet("dead-letters", flag.ExitOnError)
+	count := fs.Int("n", 20, "number of dead letters to show")
+	fs.Parse(args)
+
+	var letters []webhook.DeadLetter
+	if err := c.api.call(http.MethodGet, fmt.Sprintf("/admin/webhooks/dead-letters?limit=%d", *count), nil, &letters); err != nil {
+		return err
+	}
+
+	deliveries := make([]webhook.Delivery, len(letters))
+	for i, letter := range letters {
+		deliveries[i] = letter.Delivery
+	}
+	return c.out.print(letters, deliveryHeader, deliveryRows(deliveries))
+}
+
+var deliveryHeader = []string{"DELIVERY", "WEBHOOK", "EVENT", "CHANNEL", "STATUS", "ATTEMPTS", "CODE", "UPDATED", "ERROR"}
+
+// deliveryRows formats deliveries for a table
+func deliveryRows(deliveries []webhook.Delivery) [][]string {
+	var rows [][]string
+	for _, d := range deliveries {
+		code := "-"
+		if d.StatusCode != 0 {
+			code = strconv.Itoa(d.StatusCode)
+		}
+		rows = append(rows, []string{
+			d.ID, d.Webhook, d.EventType, orDash(d.Channel), d.Status, strconv.Itoa(d.Attempts), code,
+			d.UpdatedAt.Local().Format(time.DateTime), orDash(d.Error),
+		})
+	}
+	return rows
+}
+
+func (c *cli) incomingWebhooks(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: incoming-webhooks [channel]")
+	}
+	path := "/admin/incoming-webhooks"
+	if len(args) == 1 {
+		path += "?channel=" + url.QueryEscape(args[0])
+	}
+
+	var hooks []webhook.IncomingHook
+	if err := c.api.call(http.MethodGet, path, nil, &hooks); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, h := range hooks {
+		rows = append(rows, []string{h.ID, "#" + h.Channel, h.Name, h.CreatedAt.Local().Format(time.DateTime)})
+	}
+	return c.out.print(hooks, []string{"WEBHOOK", "CHANNEL", "NAME", "CREATED"}, rows)
+}
+
+func (c *cli) createIncomingWebhook(args []string) error {
+	fs := flag.NewFlagSet("create-incoming-webhook", flag.ExitOnError)
+	name := fs.String("name", "", "sender name shown for posts (default webhook)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: create-incoming-webhook [-name bot] <channel>")
+	}
+
+	var resp struct {
+		webhook.IncomingHook
+		Token string `json:"token"`
+		Path  string `json:"path"`
+	}
+	req := map[string]string{"channel": fs.Arg(0), "name": *name}
+	if err := c.api.call(http.MethodPost, "/admin/incoming-webhooks", req, &resp); err != nil {
+		return err
+	}
+	return c.out.message(resp, "Created incoming webhook %s for #%s\nPost to: %s", resp.ID, resp.Channel, c.api.base+resp.Path)
+}
+
+func (c *cli) deleteIncomingWebhook(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete-incoming-webhook <id>")
+	}
+	if err := c.api.call(http.MethodDelete, "/admin/incoming-webhooks/"+pathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	return c.out.message(map[string]string{"deleted": args[0]}, "Deleted incoming webhook %s", args[0])
+}
+
+func (c *cli) bots(args []string) error {
+	var list []bots.Bot
+	if err := c.api.call(http.MethodGet, "/admin/bots", nil, &list); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, b := range list {
+		rows = append(rows, []string{b.Name, orDash(b.Description), b.CreatedAt.Local().Format(time.DateTime)})
+	}
+	return c.out.print(list, []string{"BOT", "DESCRIPTION", "CREATED"}, rows)
+}
+
+func (c *cli) createBot(args []string) error {
+	fs := flag.NewFlagSet("create-bot", flag.ExitOnError)
+	description := fs.String("description", "", "what the bot does")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: create-bot [-description text] <name>")
+	}
+
+	var resp struct {
+		bots.Bot
+		Token string `json:"token"`
+	}
+	req := map[string]string{"name": fs.Arg(0), "description": *description}
+	if err := c.api.call(http.MethodPost, "/admin/bots", req, &resp); err != nil {
+		return err
+	}
+	return c.out.message(resp, "Created bot %s\nToken: %s", resp.Name, resp.Token)
+}
+
+func (c *cli) deleteBot(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete-bot <name>")
+	}
+	if err := c.api.call(http.MethodDelete, "/admin/bots/"+pathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	return c.out.message(map[string]string{"deleted": args[0]}, "Deleted bot %s", args[0])
+}
+
+func (c *cli) rotateBotToken(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rotate-bot-token <name>")
+	}
+
+	var resp struct {
+		Token        string `json:"token"`
+		Disconnected int    `json:"disconnected"`
+	}
+	if err := c.api.call(http.MethodPost, "/admin/bots/"+pathEscape(args[0])+"/token", nil, &resp); err != nil {
+		return err
+	}
+	return c.out.message(resp, "%s\nThe previous token no longer works; disconnected %d session(s).", resp.Token, resp.Disconnected)
+}
+
+func (c *cli) commands(args []string) error {
+	var commands []hub.Command
+	if err := c.api.call(http.MethodGet, "/admin/commands", nil, &commands); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, cmd := range commands {
+		rows = append(rows, []string{"/" + cmd.Name, cmd.Bot, orDash(cmd.Usage), orDash(cmd.Description)})
+	}
+	return c.out.print(commands, []string{"COMMAND", "BOT", "USAGE", "DESCRIPTION"}, rows)
+}
+
+// envOr returns the environment variable name, or def if it is unset
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// fatalf prints an error and exits
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "chatctl: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,465 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+)
+
+// Config holds all server settings
+type Config struct {
+	// Listen is the address the HTTP server binds to
+	Listen string `yaml:"listen"`
+
+	// StaticDir is served at / for development
+	StaticDir string `yaml:"static_dir"`
+
+	// StoragePath is the directory where server state is kept
+	StoragePath string `yaml:"storage_path"`
+
+	// DefaultChannels are advertised to clients when they connect
+	DefaultChannels []string `yaml:"default_channels"`
+
+	// ShutdownTimeout bounds how long shutdown waits for clients to flush
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// SaveInterval is how often the message history is saved while running.
+	// Channels, bans, topics and profiles are saved as soon as they change.
+	SaveInterval time.Duration `yaml:"save_interval"`
+
+	// AllowedOrigins lists the browser origins allowed to open a WebSocket
+	AllowedOrigins []string `yaml:"allowed_origins"`
+
+	TLS     TLS     `yaml:"tls"`
+	Limits  Limits  `yaml:"limits"`
+	Cluster Cluster `yaml:"cluster"`
+	Log     Log     `yaml:"log"`
+	Admin   Admin   `yaml:"admin"`
+}
+
+// Admin protects the operator endpoints
+type Admin struct {
+	// Token must be sent as "Authorization: Bearer <token>" to use the admin
+	// endpoints; empty disables them
+	Token string `yaml:"token"`
+}
+
+// Log controls the server's structured logging
+type Log struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level string `yaml:"level"`
+
+	// Format is text or json
+	Format string `yaml:"format"`
+
+	// Payloads logs message content instead of redacting it. Payloads are
+	// only logged at debug level.
+	Payloads bool `yaml:"payloads"`
+}
+
+// Cluster connects this node to other nodes through a TCP backplane
+type Cluster struct {
+	// NodeID identifies this node; defaults to the hostname
+	NodeID string `yaml:"node_id"`
+
+	// Listen is the backplane address; empty runs the node standalone
+	Listen string `yaml:"listen"`
+
+	// Peers are the backplane addresses of the other nodes
+	Peers []string `yaml:"peers"`
+
+	// Secret authenticates backplane connections; all nodes must share it
+	Secret string `yaml:"secret"`
+}
+
+// Enabled reports whether clustering is configured
+func (c Cluster) Enabled() bool {
+	return c.Listen != ""
+}
+
+// TLS holds the certificate used to serve wss://
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// SelfSigned generates a development certificate under StoragePath
+	// instead of using CertFile and KeyFile
+	SelfSigned bool `yaml:"self_signed"`
+
+	// Hosts are extra DNS names or IPs the self-signed certificate is valid for
+	Hosts []string `yaml:"hosts"`
+}
+
+// Enabled reports whether TLS is configured
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.SelfSigned
+}
+
+const (
+	// maxEncodedRuneSize is the most bytes a character of content can take
+	// in a frame: one outside the Basic Multilingual Plane may be written as
+	// an escaped UTF-16 surrogate pair such as \ud83d\ude00
+	maxEncodedRuneSize = 12
+
+	// envelopeOverhead is room in a frame for the event's other fields
+	envelopeOverhead = 4096
+)
+
+// Limits bounds connection buffers, timeouts and what a client may send
+type Limits struct {
+	// MaxMessageSize is the largest WebSocket frame, in bytes, that is accepted.
+	// Larger frames are discarded and answered with an error event. It must
+	// fit MaxContentLength characters however they are encoded.
+	MaxMessageSize int64 `yaml:"max_message_size"`
+
+	// MaxContentLength is the largest message content, in characters
+	MaxContentLength int `yaml:"max_content_length"`
+
+	// MaxFileSize is the largest file that may be uploaded, in bytes
+	MaxFileSize int64 `yaml:"max_file_size"`
+
+	// SendBufferSize is the number of outgoing messages queued per client
+	SendBufferSize int `yaml:"send_buffer_size"`
+
+	// SlowConsumerPolicy decides what happens when a client's send buffer
+	// is full: disconnect, drop-oldest, drop-typing or coalesce
+	SlowConsumerPolicy string `yaml:"slow_consumer_policy"`
+
+	ReadBufferSize  int `yaml:"read_buffer_size"`
+	WriteBufferSize int `yaml:"write_buffer_size"`
+
+	// MaxHandshakesPerIP is the number of WebSocket handshakes one IP may
+	// have in progress at the same time
+	MaxHandshakesPerIP int `yaml:"max_handshakes_per_ip"`
+
+	// WriteWait is the time allowed to write a message to a client
+	WriteWait time.Duration `yaml:"write_wait"`
+
+	// PongWait is the time allowed to read the next pong from a client
+	PongWait time.Duration `yaml:"pong_wait"`
+
+	// PingPeriod is how often clients are pinged; must be less than PongWait
+	PingPeriod time.Duration `yaml:"ping_period"`
+}
+
+// MinMessageSize is the smallest frame limit that still accepts every
+// message of MaxContentLength characters
+func (l Limits) MinMessageSize() int64 {
+	return int64(l.MaxContentLength)*maxEncodedRuneSize + envelopeOverhead
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Listen:          ":8080",
+		StaticDir:       "./static",
+		StoragePath:     "./data",
+		DefaultChannels: []string{"general", "random", "dev"},
+		ShutdownTimeout: 10 * time.Second,
+		SaveInterval:    30 * time.Second,
+		Limits: Limits{
+			MaxMessageSize:     64 * 1024,
+			MaxContentLength:   4000,
+			MaxFileSize:        25 << 20,
+			SendBufferSize:     256,
+			SlowConsumerPolicy: string(outbox.Disconnect),
+			ReadBufferSize:     1024,
+			WriteBufferSize:    1024,
+			MaxHandshakesPerIP: 10,
+			WriteWait:          10 * time.Second,
+			PongWait:           60 * time.Second,
+			PingPeriod:         54 * time.Second,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// Load builds the configuration from defaults, an optional YAML file, CHAT_*
+// environment variables and command-line flags, in increasing precedence
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CHAT_CONFIG"), "path to a YAML config file")
+	listen := fs.String("listen", "", "address to listen on")
+	staticDir := fs.String("static-dir", "", "directory served at /")
+	storagePath := fs.String("storage", "", "directory for server state")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	tlsSelfSigned := fs.Bool("tls-self-signed", false, "serve TLS with a generated development certificate")
+	origins := fs.String("allowed-origins", "", "comma-separated browser origins allowed to connect")
+	channels := fs.String("default-channels", "", "comma-separated channels advertised to clients")
+	clusterListen := fs.String("cluster-listen", "", "backplane address for other nodes")
+	clusterPeers := fs.String("cluster-peers", "", "comma-separated backplane addresses of other nodes")
+	logLevel := fs.String("log-level", "", "minimum log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "log format: text or json")
+	logPayloads := fs.Bool("log-payloads", false, "log message content at debug level instead of redacting it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only flags that were given on the command line override earlier sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "static-dir":
+			cfg.StaticDir = *staticDir
+		case "storage":
+			cfg.StoragePath = *storagePath
+		case "tls-cert":
+			cfg.TLS.CertFile = *tlsCert
+		case "tls-key":
+			cfg.TLS.KeyFile = *tlsKey
+		case "tls-self-signed":
+			cfg.TLS.SelfSigned = *tlsSelfSigned
+		case "allowed-origins":
+			cfg.AllowedOrigins = splitList(*origins)
+		case "default-channels":
+			cfg.DefaultChannels = splitList(*channels)
+		case "cluster-listen":
+			cfg.Cluster.Listen = *clusterListen
+		case "cluster-peers":
+			cfg.Cluster.Peers = splitList(*clusterPeers)
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "log-payloads":
+			cfg.Log.Payloads = *logPayloads
+		}
+	})
+
+	if cfg.Cluster.NodeID == "" {
+		cfg.Cluster.NodeID, _ = os.Hostname()
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile merges a YAML file into the config
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv merges CHAT_* environment variables into the config
+func (c *Config) loadEnv() error {
+	strVars := map[string]*string{
+		"CHAT_LISTEN":         &c.Listen,
+		"CHAT_STATIC_DIR":     &c.StaticDir,
+		"CHAT_STORAGE_PATH":   &c.StoragePath,
+		"CHAT_TLS_CERT":       &c.TLS.CertFile,
+		"CHAT_TLS_KEY":        &c.TLS.KeyFile,
+		"CHAT_NODE_ID":        &c.Cluster.NodeID,
+		"CHAT_CLUSTER_LISTEN": &c.Cluster.Listen,
+		"CHAT_CLUSTER_SECRET": &c.Cluster.Secret,
+		"CHAT_LOG_LEVEL":      &c.Log.Level,
+		"CHAT_LOG_FORMAT":     &c.Log.Format,
+		"CHAT_ADMIN_TOKEN":    &c.Admin.Token,
+	}
+	for name, dst := range strVars {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("CHAT_ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CHAT_DEFAULT_CHANNELS"); ok {
+		c.DefaultChannels = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CHAT_CLUSTER_PEERS"); ok {
+		c.Cluster.Peers = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("CHAT_TLS_SELF_SIGNED"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_TLS_SELF_SIGNED: %w", err)
+		}
+		c.TLS.SelfSigned = b
+	}
+	if v, ok := os.LookupEnv("CHAT_LOG_PAYLOADS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_LOG_PAYLOADS: %w", err)
+		}
+		c.Log.Payloads = b
+	}
+
+	if v, ok := os.LookupEnv("CHAT_SLOW_CONSUMER_POLICY"); ok {
+		c.Limits.SlowConsumerPolicy = v
+	}
+
+	if v, ok := os.LookupEnv("CHAT_MAX_MESSAGE_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("CHAT_MAX_MESSAGE_SIZE: %w", err)
+		}
+		c.Limits.MaxMessageSize = n
+	}
+	if v, ok := os.LookupEnv("CHAT_MAX_CONTENT_LENGTH"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_MAX_CONTENT_LENGTH: %w", err)
+		}
+		c.Limits.MaxContentLength = n
+	}
+	if v, ok := os.LookupEnv("CHAT_MAX_FILE_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("CHAT_MAX_FILE_SIZE: %w", err)
+		}
+		c.Limits.MaxFileSize = n
+	}
+	return nil
+}
+
+// Validate checks the configuration and reports every problem found
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen address must not be empty"))
+	}
+	if c.StoragePath == "" {
+		errs = append(errs, errors.New("storage_path must not be empty"))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.SaveInterval <= 0 {
+		errs = append(errs, errors.New("save_interval must be positive"))
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.SelfSigned && c.TLS.CertFile != "" {
+		errs = append(errs, errors.New("tls.self_signed cannot be combined with tls.cert_file"))
+	}
+
+	if len(c.DefaultChannels) == 0 {
+		errs = append(errs, errors.New("default_channels must list at least one channel"))
+	}
+	for _, ch := range c.DefaultChannels {
+		if !models.ValidChannelID(ch) {
+			errs = append(errs, fmt.Errorf("default channel %q must be lowercase letters, digits, - or _", ch))
+		}
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("allowed origin %q must be scheme://host[:port]", origin))
+		}
+	}
+
+	if c.Cluster.Enabled() {
+		if c.Cluster.NodeID == "" {
+			errs = append(errs, errors.New("cluster.node_id must be set when clustering"))
+		}
+		if c.Cluster.Secret == "" {
+			errs = append(errs, errors.New("cluster.secret must be set when clustering"))
+		}
+	} else if len(c.Cluster.Peers) > 0 {
+		errs = append(errs, errors.New("cluster.peers requires cluster.listen"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q must be debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q must be text or json", c.Log.Format))
+	}
+
+	l := c.Limits
+	if l.MaxMessageSize <= 0 {
+		errs = append(errs, errors.New("limits.max_message_size must be positive"))
+	}
+	if l.MaxContentLength <= 0 {
+		errs = append(errs, errors.New("limits.max_content_length must be positive"))
+	}
+	if l.MaxMessageSize > 0 && l.MaxContentLength > 0 && l.MaxMessageSize < l.MinMessageSize() {
+		errs = append(errs, fmt.Errorf("limits.max_message_size must be at least %d bytes to fit limits.max_content_length (%d characters)",
+			l.MinMessageSize(), l.MaxContentLength))
+	}
+	if l.MaxFileSize <= 0 {
+		errs = append(errs, errors.New("limits.max_file_size must be positive"))
+	}
+	if l.SendBufferSize <= 0 {
+		errs = append(errs, errors.New("limits.send_buffer_size must be positive"))
+	}
+	if _, err := outbox.ParsePolicy(l.SlowConsumerPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("limits.slow_consumer_policy: %w", err))
+	}
+	if l.ReadBufferSize <= 0 || l.WriteBufferSize <= 0 {
+		errs = append(errs, errors.New("limits.read_buffer_size and limits.write_buffer_size must be positive"))
+	}
+	if l.MaxHandshakesPerIP <= 0 {
+		errs = append(errs, errors.New("limits.max_handshakes_per_ip must be positive"))
+	}
+	if l.WriteWait <= 0 || l.PongWait <= 0 || l.PingPeriod <= 0 {
+		errs = append(errs, errors.New("limits.write_wait, limits.pong_wait and limits.ping_period must be positive"))
+	}
+	if l.PingPeriod >= l.PongWait {
+		errs = append(errs, fmt.Errorf("limits.ping_period (%s) must be less than limits.pong_wait (%s)", l.PingPeriod, l.PongWait))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMinMessageSizeFitsEscapedContent(t *testing.T) {
+	l := Default().Limits
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default configuration is invalid: %v", err)
+	}
+
+	// The longest content a client can send: every character outside the
+	// BMP, written as an escaped surrogate pair
+	content := strings.Repeat(`\ud83d\ude00`, l.MaxContentLength)
+	frame := `{"id":"0123456789abcdef0123456789abcdef","type":"send_message","channel":"general","content":"` +
+		content + `","data":{"ack":true}}`
+
+	var event struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(frame), &event); err != nil {
+		t.Fatal(err)
+	}
+	if n := utf8.RuneCountInString(event.Content); n != l.MaxContentLength {
+		t.Fatalf("decoded %d characters, want %d", n, l.MaxContentLength)
+	}
+	if int64(len(frame)) > l.MinMessageSize() {
+		t.Errorf("a %d byte frame does not fit MinMessageSize %d", len(frame), l.MinMessageSize())
+	}
+}
+
+// clearEnv removes CHAT_* variables for the rest of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "CHAT_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// writeFile writes a config file for the test and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, `
+listen: ":1000"
+storage_path: "/var/lib/chat"
+default_channels: [ops, dev]
+log:
+  level: warn
+  format: json
+limits:
+  max_content_length: 100
+`)
+	t.Setenv("CHAT_LISTEN", ":2000")
+	t.Setenv("CHAT_LOG_LEVEL", "debug")
+	t.Setenv("CHAT_DEFAULT_CHANNELS", "general, random")
+
+	cfg, err := Load([]string{"-config", path, "-listen", ":3000", "-default-channels", "alerts"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"listen (flag over env and file)", cfg.Listen, ":3000"},
+		{"default_channels (flag over env and file)", strings.Join(cfg.DefaultChannels, ","), "alerts"},
+		{"log.level (env over file)", cfg.Log.Level, "debug"},
+		{"log.format (file over default)", cfg.Log.Format, "json"},
+		{"storage_path (file over default)", cfg.StoragePath, "/var/lib/chat"},
+		{"limits.max_content_length (file over default)", cfg.Limits.MaxContentLength, 100},
+		{"static_dir (default)", cfg.StaticDir, Default().StaticDir},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CHAT_CONFIG", writeFile(t, `listen: ":1000"`))
+
+	// Flags that are not given leave the file's values alone
+	cfg, err := Load([]string{"-log-level", "error"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Listen != ":1000" || cfg.Log.Level != "error" {
+		t.Errorf("listen = %q, log.level = %q", cfg.Listen, cfg.Log.Level)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "unknown field", file: "lsten: \":1000\"", want: "field lsten not found"},
+		{name: "bad env number", env: map[string]string{"CHAT_MAX_MESSAGE_SIZE": "lots"}, want: "CHAT_MAX_MESSAGE_SIZE"},
+		{name: "bad env bool", env: map[string]string{"CHAT_TLS_SELF_SIGNED": "maybe"}, want: "CHAT_TLS_SELF_SIGNED"},
+		{name: "unknown flag", args: []string{"-lsten", ":1"}, want: "flag provided but not defined"},
+		{name: "invalid after merging", env: map[string]string{"CHAT_LOG_FORMAT": "xml"}, want: `log.format "xml" must be text or json`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clearEnv(t)
+			args := c.args
+			if c.file != "" {
+				args = append([]string{"-config", writeFile(t, c.file)}, args...)
+			}
+			for name, value := range c.env {
+				t.Setenv(name, value)
+			}
+			_, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Load = %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"empty listen", func(c *Config) { c.Listen = "" }, "listen address must not be empty"},
+		{"half a certificate", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "tls.cert_file and tls.key_file must be set together"},
+		{"bad channel", func(c *Config) { c.DefaultChannels = []string{"Not OK"} }, `default channel "Not OK"`},
+		{"bad origin", func(c *Config) { c.AllowedOrigins = []string{"example.com"} }, `allowed origin "example.com"`},
+		{"cluster without secret", func(c *Config) { c.Cluster.Listen, c.Cluster.NodeID = ":9090", "a" }, "cluster.secret must be set"},
+		{"peers without listen", func(c *Config) { c.Cluster.Peers = []string{"b:9090"} }, "cluster.peers requires cluster.listen"},
+		{"frame too small", func(c *Config) { c.Limits.MaxMessageSize = 1024 }, "limits.max_message_size must be at least"},
+		{"bad policy", func(c *Config) { c.Limits.SlowConsumerPolicy = "shrug" }, "limits.slow_consumer_policy"},
+		{"ping after pong", func(c *Config) { c.Limits.PingPeriod = c.Limits.PongWait }, "limits.ping_period"},
+		{"zero save interval", func(c *Config) { c.SaveInterval = 0 }, "save_interval must be positive"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := Default()
+			c.change(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("Validate = %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	cfg := Default()
+	cfg.Listen, cfg.StoragePath = "", ""
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "listen address") || !strings.Contains(err.Error(), "storage_path") {
+		t.Errorf("Validate = %v, want both problems", err)
+	}
+}
@@ -0,0 +1,293 @@
+// Package files stores attachments uploaded by clients. Contents are kept
+// once per SHA-256 digest under objects/, while every upload gets its own
+// ID and metadata, so the same file shared twice takes the space of one.
+package files
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"terminal-chat/server/models"
+)
+
+const (
+	indexFile = "files.jsonl"
+
+	// maxNameLength bounds a file name in characters
+	maxNameLength = 255
+
+	// sniffLength is how much of a file MIME detection looks at
+	sniffLength = 512
+)
+
+var (
+	// ErrNotFound is returned for an unknown file
+	ErrNotFound = errors.New("no such file")
+
+	// ErrTooLarge is returned for a file over the size limit
+	ErrTooLarge = errors.New("file too large")
+
+	// ErrInvalidName is returned for an empty or unusable file name
+	ErrInvalidName = errors.New("file names must be 1 to 255 characters without control characters or slashes")
+
+	// ErrOffset is returned for a chunk that does not continue the upload
+	ErrOffset = errors.New("chunk does not start where the upload left off")
+
+	// ErrIncomplete is returned when finishing an upload missing data
+	ErrIncomplete = errors.New("upload is incomplete")
+
+	// ErrChecksum is returned when an upload does not match its digest
+	ErrChecksum = errors.New("upload does not match its sha256")
+)
+
+// Index persists the metadata of stored files
+type Index interface {
+	AppendJSONLine(name string, v interface{}) error
+	ReadJSONLines(name string, decode func(line []byte) error) error
+}
+
+// Store keeps uploaded files on disk
+type Store struct {
+	dir     string
+	index   Index
+	maxSize int64
+
+	mu    sync.Mutex
+	files map[string]*models.File
+
+	// order holds the files by upload time
+	order []*models.File
+}
+
+// NewStore opens the file store in dir, loading the metadata saved in
+// index. Files larger than maxSize bytes are refused.
+func NewStore(dir string, index Index, maxSize int64) (*Store, error) {
+	for _, sub := range []string{"objects", "uploads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("creating file storage: %w", err)
+		}
+	}
+
+	// Uploads in progress did not survive the restart
+	partial, _ := filepath.Glob(filepath.Join(dir, "uploads", "*"))
+	for _, path := range partial {
+		os.Remove(path)
+	}
+
+	s := &Store{dir: dir, index: index, maxSize: maxSize, files: make(map[string]*models.File)}
+	err := index.ReadJSONLines(indexFile, func(line []byte) error {
+		var f models.File
+		if err := json.Unmarshal(line, &f); err != nil {
+			return err
+		}
+		s.files[f.ID] = &f
+		s.order = append(s.order, &f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading files: %w", err)
+	}
+	return s, nil
+}
+
+// MaxSize returns the largest file accepted, in bytes
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Get returns a file's metadata
+func (s *Store) Get(id string) (models.File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return models.File{}, false
+	}
+	return *f, true
+}
+
+// List returns the files uploaded to a channel, oldest first
+func (s *Store) List(channel string) []models.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := []models.File{}
+	for _, f := range s.order {
+		if f.Channel == channel {
+			files = append(files, *f)
+		}
+	}
+	return files
+}
+
+// Open opens a file's contents for reading
+func (s *Store) Open(id string) (*os.File, models.File, error) {
+	f, ok := s.Get(id)
+	if !ok {
+		return nil, models.File{}, ErrNotFound
+	}
+	r, err := os.Open(s.objectPath(f.SHA256))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, models.File{}, ErrNotFound
+	}
+	return r, f, err
+}
+
+// objectPath is where the contents with a digest are kept, spread over
+// directories by the first two hex digits
+func (s *Store) objectPath(sum string) string {
+	return filepath.Join(s.dir, "objects", sum[:2], sum)
+}
+
+// Upload is a file being received in chunks
+type Upload struct {
+	store    *Store
+	file     models.File
+	tmp      *os.File
+	hash     hash.Hash
+	head     []byte
+	received int64
+}
+
+// Begin starts receiving a file of size bytes that uploader will post to
+// channel
+func (s *Store) Begin(name string, size int64, channel, uploader string) (*Upload, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || utf8.RuneCountInString(name) > maxNameLength ||
+		strings.ContainsAny(name, `/\`) || strings.ContainsFunc(name, unicode.IsControl) {
+		return nil, ErrInvalidName
+	}
+	if size < 0 || size > s.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "uploads"), "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating upload: %w", err)
+	}
+	return &Upload{
+		store: s,
+		file: models.File{
+			Name:     name,
+			Size:     size,
+			Channel:  channel,
+			Uploader: uploader,
+		},
+		tmp:  tmp,
+		hash: sha256.New(),
+	}, nil
+}
+
+// Received returns how many bytes have been written
+func (u *Upload) Received() int64 {
+	return u.received
+}
+
+// Write adds a chunk starting at offset, which must be where the last
+// chunk ended
+func (u *Upload) Write(offset int64, chunk []byte) error {
+	if offset != u.received {
+		return ErrOffset
+	}
+	if u.received+int64(len(chunk)) > u.file.Size {
+		return ErrTooLarge
+	}
+	if _, err := u.tmp.Write(chunk); err != nil {
+		return fmt.Errorf("writing upload: %w", err)
+	}
+	u.hash.Write(chunk)
+	if len(u.head) < sniffLength {
+		u.head = append(u.head, chunk[:min(len(chunk), sniffLength-len(u.head))]...)
+	}
+	u.received += int64(len(chunk))
+	return nil
+}
+
+// Abort discards the upload
+func (u *Upload) Abort() {
+	u.tmp.Close()
+	os.Remove(u.tmp.Name())
+}
+
+// Finish stores a complete upload and returns its metadata. If sum is not
+// empty the contents must have that hex SHA-256. The upload is discarded
+// if it fails.
+func (u *Upload) Finish(sum string) (models.File, error) {
+	defer u.Abort()
+
+	if u.received != u.file.Size {
+		return models.File{}, ErrIncomplete
+	}
+	digest := hex.EncodeToString(u.hash.Sum(nil))
+	if sum != "" && !strings.EqualFold(sum, digest) {
+		return models.File{}, ErrChecksum
+	}
+	if err := u.tmp.Close(); err != nil {
+		return models.File{}, fmt.Errorf("writing upload: %w", err)
+	}
+
+	s := u.store
+	object := s.objectPath(digest)
+	if _, err := os.Stat(object); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(object), 0o700); err != nil {
+			return models.File{}, fmt.Errorf("storing file: %w", err)
+		}
+		if err := os.Rename(u.tmp.Name(), object); err != nil {
+			return models.File{}, fmt.Errorf("storing file: %w", err)
+		}
+	}
+
+	f := u.file
+	f.ID = newID()
+	f.SHA256 = digest
+	f.MIME = detectMIME(f.Name, u.head)
+	f.CreatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.index.AppendJSONLine(indexFile, f); err != nil {
+		return models.File{}, fmt.Errorf("saving file: %w", err)
+	}
+	s.files[f.ID] = &f
+	s.order = append(s.order, &f)
+	return f, nil
+}
+
+// detectMIME sniffs a file's type from its first bytes. Where sniffing only
+// tells text or binary apart, a type known for the name's extension is
+// preferred, so logs, configs and archives are labeled usefully.
+func detectMIME(name string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed
+	}
+	byName := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if byName == "" || strings.HasPrefix(byName, "image/") {
+		// Contents that do not sniff as an image are not one
+		return sniffed
+	}
+	return byName
+}
+
+// newID returns a random file ID. IDs are unguessable, since knowing one is
+// enough to download the file.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,39 @@
+package files
+
+import (
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// Handler serves file downloads at GET /files/{id}. The ID is the only
+// credential, like the link to a shared document.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files/{id}", s.download)
+	return mux
+}
+
+// download sends a file's contents as an attachment under its name
+func (s *Store) download(w http.ResponseWriter, r *http.Request) {
+	contents, f, err := s.Open(r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Opening file", "file", r.PathValue("id"), "err", err)
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer contents.Close()
+
+	// Uploaded files are never rendered by a browser visiting the link
+	w.Header().Set("Content-Type", f.MIME)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("ETag", `"`+f.SHA256+`"`)
+	http.ServeContent(w, r, "", f.CreatedAt, contents)
+}
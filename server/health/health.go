@@ -0,0 +1,90 @@
+// Package health serves the liveness and readiness endpoints used by
+// orchestrators and load balancers.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"terminal-chat/server/hub"
+)
+
+// hubTimeout is how long the hub loop may take to answer a readiness probe
+// before it is considered wedged
+const hubTimeout = 2 * time.Second
+
+// Pinger is a dependency that can report whether it is usable
+type Pinger interface {
+	Ping() error
+}
+
+// Checker answers health probes for a hub and its storage
+type Checker struct {
+	hub   *hub.Hub
+	store Pinger
+}
+
+// NewChecker creates a checker for h and store
+func NewChecker(h *hub.Hub, store Pinger) *Checker {
+	return &Checker{hub: h, store: store}
+}
+
+// status is the body of a probe response
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is up and serving HTTP
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, status{Status: "ok"})
+}
+
+// Readyz reports whether the node should receive traffic: the hub loop is
+// responsive, storage is writable and the server is not shutting down.
+// Every check is run so the response shows all failures at once.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]string)
+	ready := true
+	fail := func(name string, err error) {
+		checks[name] = err.Error()
+		ready = false
+	}
+
+	if c.hub.Draining() {
+		checks["draining"] = "server is shutting down"
+		ready = false
+	} else {
+		checks["draining"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), hubTimeout)
+	defer cancel()
+	if err := c.hub.Ping(ctx); err != nil {
+		fail("hub", err)
+	} else {
+		checks["hub"] = "ok"
+	}
+
+	if err := c.store.Ping(); err != nil {
+		fail("storage", err)
+	} else {
+		checks["storage"] = "ok"
+	}
+
+	if !ready {
+		writeStatus(w, http.StatusServiceUnavailable, status{Status: "unavailable", Checks: checks})
+		return
+	}
+	writeStatus(w, http.StatusOK, status{Status: "ok", Checks: checks})
+}
+
+// writeStatus writes a probe response as JSON
+func writeStatus(w http.ResponseWriter, code int, s status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s)
+}
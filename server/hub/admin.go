@@ -0,0 +1,495 @@
+package hub
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+)
+
+var (
+	// ErrChannelExists is returned when creating a channel that is listed
+	ErrChannelExists = errors.New("channel already exists")
+
+	// ErrNoChannel is returned for a channel that is not listed
+	ErrNoChannel = errors.New("no such channel")
+
+	// ErrInvalidChannel is returned for a malformed channel ID
+	ErrInvalidChannel = errors.New("channel IDs must be lowercase letters, digits, - or _")
+
+	// ErrChannelArchived is returned when posting to an archived channel
+	ErrChannelArchived = errors.New("channel is archived")
+)
+
+// Member is a session subscribed to a channel
+type Member struct {
+	Session  string `json:"session"`
+	Username string `json:"username"`
+}
+
+// ChannelInfo describes a channel for admins. Clients cannot use channels
+// that are not listed, but history restored for a channel that has since
+// been dropped from the list keeps it active; such channels are included
+// with Listed false.
+type ChannelInfo struct {
+	models.Channel
+	Listed  bool     `json:"listed"`
+	Members []Member `json:"members"`
+	History int      `json:"history"`
+}
+
+// UserInfo summarizes the sessions of one username
+type UserInfo struct {
+	Username    string    `json:"username"`
+	Sessions    int       `json:"sessions"`
+	Channels    []string  `json:"channels"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// findChannel returns the listed channel with id, or nil
+func (h *Hub) findChannel(id string) *models.Channel {
+	for _, ch := range h.catalog {
+		if ch.ID == id {
+			return ch
+		}
+	}
+	return nil
+}
+
+// ChannelNames returns the IDs of the channels offered to clients
+func (h *Hub) ChannelNames() []string {
+	var names []string
+	h.call(func() {
+		names = h.channelNames()
+	})
+	return names
+}
+
+// channelNames lists unarchived channels from the hub goroutine
+func (h *Hub) channelNames() []string {
+	names := []string{}
+	for _, ch := range h.catalog {
+		if !ch.Archived {
+			names = append(names, ch.ID)
+		}
+	}
+	return names
+}
+
+// TopicInfo is a channel's topic and description as sent to clients
+type TopicInfo struct {
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ChannelTopics returns the topics of the offered channels that have one
+func (h *Hub) ChannelTopics() map[string]TopicInfo {
+	var topics map[string]TopicInfo
+	h.call(func() {
+		topics = h.channelTopics()
+	})
+	return topics
+}
+
+// channelTopics lists topics from the hub goroutine
+func (h *Hub) channelTopics() map[string]TopicInfo {
+	topics := make(map[string]TopicInfo)
+	for _, ch := range h.catalog {
+		if !ch.Archived && (ch.Topic != "" || ch.Description != "") {
+			topics[ch.ID] = TopicInfo{Topic: ch.Topic, Description: ch.Description}
+		}
+	}
+	return topics
+}
+
+// ListChannels returns every listed channel, then any unlisted channel
+// still holding restored history, with their members
+func (h *Hub) ListChannels() []ChannelInfo {
+	var infos []ChannelInfo
+	h.call(func() {
+		seen := make(map[string]bool)
+		for _, ch := range h.catalog {
+			infos = append(infos, h.channelInfo(*ch, true))
+			seen[ch.ID] = true
+		}
+
+		var unlisted []string
+		for channelID := range h.channels {
+			if !seen[channelID] {
+				unlisted = append(unlisted, channelID)
+			}
+		}
+		sort.Strings(unlisted)
+		for _, channelID := range unlisted {
+			infos = append(infos, h.channelInfo(models.Channel{ID: channelID, Name: channelID}, false))
+		}
+	})
+	return infos
+}
+
+// Channel returns one channel with its members
+func (h *Hub) Channel(id string) (ChannelInfo, error) {
+	var (
+		info ChannelInfo
+		err  error
+	)
+	h.call(func() {
+		switch ch := h.findChannel(id); {
+		case ch != nil:
+			info = h.channelInfo(*ch, true)
+		case h.channels[id] != nil:
+			info = h.channelInfo(models.Channel{ID: id, Name: id}, false)
+		default:
+			err = ErrNoChannel
+		}
+	})
+	return info, err
+}
+
+// channelInfo gathers a channel's members from its actor
+func (h *Hub) channelInfo(ch models.Channel, listed bool) ChannelInfo {
+	info := ChannelInfo{Channel: ch, Listed: listed, Members: []Member{}}
+	if actor := h.channels[ch.ID]; actor != nil {
+		subscribers, history := actor.snapshot()
+		for _, client := range subscribers {
+			info.Members = append(info.Members, Member{Session: client.ID, Username: client.Username})
+		}
+		info.History = len(history)
+	}
+	sort.Slice(info.Members, func(i, j int) bool {
+		return info.Members[i].Username < info.Members[j].Username
+	})
+	return info
+}
+
+// ListUsers returns the connected usernames with their sessions
+func (h *Hub) ListUsers() []UserInfo {
+	var users []UserInfo
+	h.call(func() {
+		byName := make(map[string]*UserInfo)
+		channels := make(map[string]map[string]bool)
+		for client, joined := range h.clients {
+			u := byName[client.Username]
+			if u == nil {
+				u = &UserInfo{Username: client.Username, ConnectedAt: client.ConnectedAt}
+				byName[client.Username] = u
+				channels[client.Username] = make(map[string]bool)
+			}
+			u.Sessions++
+			if client.ConnectedAt.Before(u.ConnectedAt) {
+				u.ConnectedAt = client.ConnectedAt
+			}
+			for channelID := range joined {
+				channels[client.Username][channelID] = true
+			}
+		}
+
+		for name, u := range byName {
+			u.Channels = []string{}
+			for channelID := range channels[name] {
+				u.Channels = append(u.Channels, channelID)
+			}
+			sort.Strings(u.Channels)
+			users = append(users, *u)
+		}
+	})
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// Disconnect ends a session, telling the client reason. It reports whether
+// the session was connected.
+func (h *Hub) Disconnect(sessionID, reason string) bool {
+	found := false
+	h.call(func() {
+		for client := range h.clients {
+			if client.ID == sessionID {
+				h.removeClient(client, models.CloseKicked, reason)
+				logging.ForClient(client).Info("Client disconnected by admin", "reason", reason)
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
+
+// DisconnectBot ends every session of the bot named name, telling each
+// reason, and returns how many were connected
+func (h *Hub) DisconnectBot(name, reason string) int {
+	kicked := 0
+	h.call(func() {
+		for client := range h.clients {
+			if client.Bot && strings.EqualFold(client.Username, name) {
+				h.removeClient(client, models.CloseKicked, reason)
+				logging.ForClient(client).Info("Bot disconnected", "reason", reason)
+				kicked++
+			}
+		}
+	})
+	return kicked
+}
+
+// Announce sends a server-wide announcement to every session and returns
+// how many sessions it was sent to
+func (h *Hub) Announce(content string) int {
+	event := models.Event{
+		Type:      "announcement",
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+	}
+
+	sent := 0
+	h.call(func() {
+		for client := range h.clients {
+			h.send(client, event)
+			sent++
+		}
+	})
+	return sent
+}
+
+// Post sends a message that did not come from a session, such as one from
+// an incoming webhook, to a listed channel. It is fanned out, shared with
+// the other nodes and observed like a message from a local client.
+func (h *Hub) Post(event models.Event) error {
+	err := ErrStopped
+	h.call(func() {
+		switch ch := h.findChannel(event.Channel); {
+		case ch == nil:
+			err = ErrNoChannel
+		case ch.Archived:
+			err = ErrChannelArchived
+		default:
+			metrics.MessagesIn.WithLabelValues(h.metricLabel(event.Channel)).Inc()
+			h.broadcastToChannel(event)
+			err = nil
+		}
+	})
+	return err
+}
+
+// CreateChannel lists a new channel, or restores an archived one, and tells
+// every session about the new channel list
+func (h *Hub) CreateChannel(id, name string) (models.Channel, error) {
+	if !models.ValidChannelID(id) {
+		return models.Channel{}, ErrInvalidChannel
+	}
+	if name == "" {
+		name = id
+	}
+
+	var (
+		created models.Channel
+		err     error
+	)
+	h.call(func() {
+		ch := h.findChannel(id)
+		switch {
+		case ch == nil:
+			ch = &models.Channel{ID: id, Name: name, CreatedAt: time.Now()}
+			h.catalog = append(h.catalog, ch)
+			if actor := h.channels[id]; actor != nil {
+				actor.relabel(id)
+			}
+		case ch.Archived:
+			ch.Archived = false
+		default:
+			err = ErrChannelExists
+			return
+		}
+		created = *ch
+		h.markChanged()
+		h.announceChannels()
+	})
+	return created, err
+}
+
+// ArchiveChannel unlists a channel and removes its members. Its history is
+// kept and it can be restored with CreateChannel.
+func (h *Hub) ArchiveChannel(id string) error {
+	var err error
+	h.call(func() {
+		ch := h.findChannel(id)
+		if ch == nil {
+			err = ErrNoChannel
+			return
+		}
+		if ch.Archived {
+			return
+		}
+		ch.Archived = true
+		h.markChanged()
+
+		if actor := h.channels[id]; actor != nil {
+			subscribers, _ := actor.snapshot()
+			for _, client := range subscribers {
+				h.send(client, models.Event{
+					Type:      "channel_archived",
+					Channel:   id,
+					Content:   "#" + id + " has been archived",
+					Timestamp: time.Now().Unix(),
+				})
+				h.leaveChannel(client, id)
+			}
+		}
+		h.announceChannels()
+	})
+	return err
+}
+
+// announceChannels sends the current channel list to every session
+func (h *Hub) announceChannels() {
+	event := models.Event{
+		Type:      "channels_updated",
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"channels": h.channelNames(),
+			"topics":   h.channelTopics(),
+		},
+	}
+	for client := range h.clients {
+		h.send(client, event)
+	}
+}
+
+// Ban keeps a username from connecting and disconnects its sessions. It
+// returns how many sessions were disconnected.
+func (h *Hub) Ban(ban models.Ban) int {
+	if ban.CreatedAt.IsZero() {
+		ban.CreatedAt = time.Now()
+	}
+
+	reason := "banned"
+	if ban.Reason != "" {
+		reason = "banned: " + ban.Reason
+	}
+
+	kicked := 0
+	h.call(func() {
+		h.bans[models.BanKey(ban.Username)] = ban
+		h.markChanged()
+		for client := range h.clients {
+			if models.BanKey(client.Username) == models.BanKey(ban.Username) {
+				h.removeClient(client, models.CloseBanned, reason)
+				kicked++
+			}
+		}
+	})
+	return kicked
+}
+
+// Unban lifts a ban, reporting whether there was one
+func (h *Hub) Unban(username string) bool {
+	found := false
+	h.call(func() {
+		key := models.BanKey(username)
+		_, found = h.bans[key]
+		if found {
+			delete(h.bans, key)
+			h.markChanged()
+		}
+	})
+	return found
+}
+
+// Bans returns the bans in force
+func (h *Hub) Bans() []models.Ban {
+	var bans []models.Ban
+	h.call(func() {
+		bans = h.activeBans()
+	})
+	return bans
+}
+
+// IsBanned returns the ban in force for username, if any
+func (h *Hub) IsBanned(username string) (models.Ban, bool) {
+	var (
+		ban    models.Ban
+		banned bool
+	)
+	h.call(func() {
+		ban, banned = h.bans[models.BanKey(username)]
+		if banned && !ban.Active(time.Now()) {
+			delete(h.bans, models.BanKey(username))
+			banned = false
+		}
+	})
+	return ban, banned
+}
+
+// activeBans drops expired bans and returns the rest sorted by username
+func (h *Hub) activeBans() []models.Ban {
+	now := time.Now()
+	bans := []models.Ban{}
+	for key, ban := range h.bans {
+		if !ban.Active(now) {
+			delete(h.bans, key)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Username < bans[j].Username })
+	return bans
+}
+
+// Watch follows a channel without joining it, for admin tails. Events are
+// queued on the returned outbox, starting with the last backlog messages of
+// the history. The outbox is closed if the watcher falls behind. Call stop
+// when done.
+func (h *Hub) Watch(channelID string, backlog, capacity int) (o *outbox.Outbox, stop func(), err error) {
+	h.call(func() {
+		ch := h.channels[channelID]
+		if ch == nil {
+			if h.findChannel(channelID) == nil {
+				err = ErrNoChannel
+				return
+			}
+			ch = h.newChannel(channelID, nil)
+			h.channels[channelID] = ch
+		}
+
+		o = outbox.New(capacity, outbox.Disconnect)
+		ch.watch(o, backlog)
+		stop = func() {
+			h.call(func() {
+				if h.channels[channelID] == ch && ch.unwatch(o) {
+					ch.stop()
+					delete(h.channels, channelID)
+				}
+			})
+			o.Close(0, "")
+		}
+	})
+	if o == nil && err == nil {
+		err = ErrStopped
+	}
+	return o, stop, err
+}
+
+// History returns the recent messages kept for a channel
+func (h *Hub) History(channelID string) ([]models.Event, error) {
+	var (
+		history []models.Event
+		err     error
+	)
+	h.call(func() {
+		ch := h.channels[channelID]
+		if ch == nil {
+			if h.findChannel(channelID) == nil {
+				err = ErrNoChannel
+			}
+			return
+		}
+		_, history = ch.snapshot()
+	})
+	if history == nil {
+		history = []models.Event{}
+	}
+	return history, err
+}
@@ -0,0 +1,259 @@
+package hub
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+)
+
+// channelInboxSize is the number of operations queued for a channel before
+// the hub blocks on it
+const channelInboxSize = 256
+
+// channel is an actor owning one channel's subscribers and history. All
+// state is touched only by its run goroutine; other goroutines submit
+// closures through inbox.
+type channel struct {
+	id string
+
+	// label is what the channel's metrics are recorded under
+	label string
+
+	subscribers map[*models.Client]bool
+	history     []models.Event
+
+	// watchers receive every event without being members, for admin tails
+	watchers map[*outbox.Outbox]bool
+
+	inbox chan func()
+	done  chan struct{}
+
+	// evict reports subscribers whose send buffer is full; it must not block
+	evict func(*models.Client)
+}
+
+// newChannel starts the actor for a channel
+func newChannel(id, label string, history []models.Event, evict func(*models.Client)) *channel {
+	c := &channel{
+		id:          id,
+		label:       label,
+		subscribers: make(map[*models.Client]bool),
+		history:     history,
+		watchers:    make(map[*outbox.Outbox]bool),
+		inbox:       make(chan func(), channelInboxSize),
+		done:        make(chan struct{}),
+		evict:       evict,
+	}
+	go c.run()
+	return c
+}
+
+// run executes submitted operations until the channel is stopped, then
+// ends any watches
+func (c *channel) run() {
+	defer close(c.done)
+	for op := range c.inbox {
+		op()
+	}
+	for o := range c.watchers {
+		o.Close(models.CloseServerRestart, "channel stopped")
+	}
+}
+
+// send queues an operation without waiting for it
+func (c *channel) send(op func()) {
+	c.inbox <- op
+}
+
+// call runs an operation and waits for it to finish
+func (c *channel) call(op func()) {
+	finished := make(chan struct{})
+	c.inbox <- func() {
+		op()
+		close(finished)
+	}
+	<-finished
+}
+
+// stop ends the actor once queued operations have run
+func (c *channel) stop() {
+	close(c.inbox)
+	<-c.done
+}
+
+// relabel changes the label the channel's metrics are recorded under
+func (c *channel) relabel(label string) {
+	c.send(func() {
+		c.label = label
+	})
+}
+
+// join subscribes a client and replays the channel history to it
+func (c *channel) join(client *models.Client) {
+	c.send(func() {
+		c.subscribers[client] = true
+		c.sendHistory(client, false)
+		logging.ForClient(client).Debug("Joined channel", "channel", c.id)
+	})
+}
+
+// leave unsubscribes a client. Once it returns the channel will not send
+// to the client again. It reports whether the channel can be discarded.
+func (c *channel) leave(client *models.Client) (idle bool) {
+	c.call(func() {
+		if c.subscribers[client] {
+			delete(c.subscribers, client)
+			logging.ForClient(client).Debug("Left channel", "channel", c.id)
+		}
+		idle = c.idle()
+	})
+	return idle
+}
+
+// idle reports whether the channel holds nothing worth keeping
+func (c *channel) idle() bool {
+	return len(c.subscribers) == 0 && len(c.watchers) == 0 && len(c.history) == 0
+}
+
+// watch sends every future event to o, after the last backlog messages
+// from the history
+func (c *channel) watch(o *outbox.Outbox, backlog int) {
+	c.send(func() {
+		c.watchers[o] = true
+
+		start := max(len(c.history)-backlog, 0)
+		for _, event := range c.history[start:] {
+			if msg, err := newMessage(event); err == nil {
+				c.deliverWatcher(o, msg)
+			}
+		}
+	})
+}
+
+// unwatch stops sending to o. It reports whether the channel can be discarded.
+func (c *channel) unwatch(o *outbox.Outbox) (idle bool) {
+	c.call(func() {
+		delete(c.watchers, o)
+		idle = c.idle()
+	})
+	return idle
+}
+
+// deliverWatcher queues a message for a watcher, closing it if it falls behind
+func (c *channel) deliverWatcher(o *outbox.Outbox, msg outbox.Message) {
+	if err := o.Push(msg); err != nil {
+		delete(c.watchers, o)
+		o.Close(models.CloseSlowConsumer, slowConsumerReason)
+	}
+}
+
+// publish fans an event out to every subscriber, recording chat messages
+// in the history
+func (c *channel) publish(event models.Event) {
+	c.send(func() {
+		if event.Type == "send_message" {
+			c.recordHistory(event)
+		}
+
+		msg, err := newMessage(event)
+		if err != nil {
+			slog.Error("Marshaling event", "type", event.Type, "channel", c.id, "err", err)
+			return
+		}
+
+		slog.Debug("Broadcasting", "type", event.Type, "channel", c.id, "subscribers", len(c.subscribers))
+		for client := range c.subscribers {
+			if client.Wants(event.Type) {
+				c.deliver(client, msg)
+			}
+		}
+		for o := range c.watchers {
+			c.deliverWatcher(o, msg)
+		}
+	})
+}
+
+// deliver queues a message for a subscriber. A subscriber whose outbox
+// overflows is dropped from the channel and reported to the hub for eviction.
+func (c *channel) deliver(client *models.Client, msg outbox.Message) {
+	switch err := client.Send.Push(msg); err {
+	case nil:
+		metrics.MessagesOut.WithLabelValues(c.label).Inc()
+	case outbox.ErrOverflow:
+		metrics.DroppedMessages.WithLabelValues("overflow").Inc()
+		delete(c.subscribers, client)
+		c.evict(client)
+	default:
+		delete(c.subscribers, client)
+	}
+}
+
+// recordHistory appends a message to the history
+func (c *channel) recordHistory(event models.Event) {
+	c.history = append(c.history, event)
+	if len(c.history) > historyLimit {
+		c.history = c.history[len(c.history)-historyLimit:]
+	}
+}
+
+// sendHistory replays recent messages to a client. Unless the client asked
+// for them, nothing is sent when there are none or the client does not want
+// messages.
+func (c *channel) sendHistory(client *models.Client, requested bool) {
+	if !requested && (len(c.history) == 0 || !client.Wants("history")) {
+		return
+	}
+
+	msg, err := newMessage(models.Event{
+		Type:      "history",
+		Channel:   c.id,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"messages": append([]models.Event{}, c.history...)},
+	})
+	if err != nil {
+		slog.Error("Marshaling history", "channel", c.id, "err", err)
+		return
+	}
+	c.deliver(client, msg)
+}
+
+// replayHistory resends recent messages to a subscriber on request
+func (c *channel) replayHistory(client *models.Client) {
+	c.send(func() {
+		if c.subscribers[client] {
+			c.sendHistory(client, true)
+		}
+	})
+}
+
+// newMessage encodes an event for an outbox, marking typing events as
+// expendable and coalescible per user
+func newMessage(event models.Event) (outbox.Message, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return outbox.Message{}, err
+	}
+
+	msg := outbox.Message{Data: data}
+	if event.Type == "typing_start" || event.Type == "typing_stop" {
+		msg.Expendable = true
+		msg.Key = "typing:" + event.Channel + ":" + event.From
+	}
+	return msg, nil
+}
+
+// snapshot returns copies of the subscribers and history
+func (c *channel) snapshot() (subscribers []*models.Client, history []models.Event) {
+	c.call(func() {
+		for client := range c.subscribers {
+			subscribers = append(subscribers, client)
+		}
+		history = append([]models.Event(nil), c.history...)
+	})
+	return subscribers, history
+}
@@ -0,0 +1,178 @@
+package hub
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/models"
+)
+
+// maxCommandsPerBot bounds how many slash commands one bot session may own
+const maxCommandsPerBot = 50
+
+var commandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// Command is a slash command registered by a bot session. It lasts as long
+// as the session and is only known to the node the bot is connected to.
+type Command struct {
+	Name        string `json:"command"`
+	Description string `json:"description,omitempty"`
+	Usage       string `json:"usage,omitempty"`
+	// Bot owns the command; it is empty for built-in commands
+	Bot string `json:"bot,omitempty"`
+
+	client *models.Client
+}
+
+// subscribe sets the event types a bot receives
+func (h *Hub) subscribe(client *models.Client, event models.Event) {
+	if !client.Bot {
+		h.send(client, errorEvent("not_a_bot", "Only bots can subscribe to events"))
+		return
+	}
+
+	events := []string{}
+	items, _ := event.Data["events"].([]interface{})
+	for _, item := range items {
+		t, _ := item.(string)
+		if !slices.Contains(models.SubscribableEvents, t) {
+			h.send(client, errorEvent("unknown_event", fmt.Sprintf("Cannot subscribe to %q events", t)))
+			return
+		}
+		events = append(events, t)
+	}
+	sort.Strings(events)
+	events = slices.Compact(events)
+
+	client.Subscribe(events)
+	h.send(client, models.Event{
+		Type:      "subscribed",
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"events": events},
+	})
+}
+
+// registerCommand adds or updates a slash command owned by a bot session
+func (h *Hub) registerCommand(client *models.Client, event models.Event) {
+	if !client.Bot {
+		h.send(client, commandError("not_a_bot", "Only bots can register commands", event))
+		return
+	}
+
+	name, _ := event.Data["command"].(string)
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	if !commandNamePattern.MatchString(name) {
+		h.send(client, commandError("invalid_command", "Command names must be lowercase letters, digits, - or _", event))
+		return
+	}
+
+	cmd := h.commands[name]
+	switch {
+	case h.builtins[name] != nil:
+		h.send(client, commandError("command_taken", "/"+name+" is a built-in command", event))
+		return
+	case cmd != nil && cmd.client != client:
+		h.send(client, commandError("command_taken", "/"+name+" is registered by "+cmd.Bot, event))
+		return
+	case cmd == nil && len(h.commandsOf(client)) >= maxCommandsPerBot:
+		h.send(client, commandError("too_many_commands", fmt.Sprintf("A bot may register at most %d commands", maxCommandsPerBot), event))
+		return
+	}
+
+	description, _ := event.Data["description"].(string)
+	usage, _ := event.Data["usage"].(string)
+	h.commands[name] = &Command{
+		Name:        name,
+		Description: description,
+		Usage:       usage,
+		Bot:         client.Username,
+		client:      client,
+	}
+	logging.ForClient(client).Info("Command registered", "command", name)
+
+	h.send(client, models.Event{
+		Type:      "command_registered",
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"command": name},
+	})
+}
+
+// commandError rejects a register_command request, naming the command as it
+// was requested so the bot can match the error to its request
+func commandError(code, content string, event models.Event) models.Event {
+	rejected := errorEvent(code, content)
+	rejected.Data["command"], _ = event.Data["command"].(string)
+	return rejected
+}
+
+// unregisterCommand removes a slash command owned by the bot session
+func (h *Hub) unregisterCommand(client *models.Client, event models.Event) {
+	name, _ := event.Data["command"].(string)
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	if cmd := h.commands[name]; cmd != nil && cmd.client == client {
+		delete(h.commands, name)
+	}
+}
+
+// dropCommands removes every command owned by a session that has gone
+func (h *Hub) dropCommands(client *models.Client) {
+	for _, cmd := range h.commandsOf(client) {
+		delete(h.commands, cmd.Name)
+	}
+}
+
+// commandsOf returns the commands owned by a session
+func (h *Hub) commandsOf(client *models.Client) []*Command {
+	var owned []*Command
+	for _, cmd := range h.commands {
+		if cmd.client == client {
+			owned = append(owned, cmd)
+		}
+	}
+	return owned
+}
+
+// listCommands returns the built-in and registered commands sorted by name
+func (h *Hub) listCommands() []Command {
+	commands := []Command{}
+	for _, b := range h.builtins {
+		commands = append(commands, Command{Name: b.Name, Description: b.Description, Usage: b.Usage})
+	}
+	for _, cmd := range h.commands {
+		commands = append(commands, *cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Commands returns the built-in and registered slash commands
+func (h *Hub) Commands() []Command {
+	var commands []Command
+	h.call(func() {
+		commands = h.listCommands()
+	})
+	return commands
+}
+
+// routeCommand hands a message invoking a bot's slash command to that bot
+// instead of the channel
+func (h *Hub) routeCommand(cmd *Command, client *models.Client, event models.Event, args string) {
+	h.send(cmd.client, models.Event{
+		ID:        event.ID,
+		Type:      "command",
+		Channel:   event.Channel,
+		From:      client.Username,
+		Timestamp: event.Timestamp,
+		Content:   event.Content,
+		Data: map[string]interface{}{
+			"command": cmd.Name,
+			"args":    args,
+			"session": client.ID,
+		},
+	})
+}
@@ -0,0 +1,29 @@
+package hub
+
+import (
+	"time"
+
+	"terminal-chat/server/models"
+)
+
+// listFiles answers a list_files request from a member with a files event
+// listing what was uploaded to the channel, oldest first
+func (h *Hub) listFiles(client *models.Client, event models.Event) {
+	if !h.clients[client][event.Channel] {
+		rejected := errorEvent("not_joined", "Join #"+event.Channel+" to see its files")
+		rejected.Data["channel"] = event.Channel
+		h.send(client, rejected)
+		return
+	}
+
+	list := []models.File{}
+	if h.files != nil {
+		list = h.files.List(event.Channel)
+	}
+	h.send(client, models.Event{
+		Type:      "files",
+		Channel:   event.Channel,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"files": list},
+	})
+}
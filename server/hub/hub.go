@@ -1,20 +1,76 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
-	"log"
-	"sync"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"terminal-chat/server/backplane"
+	"terminal-chat/server/logging"
+	"terminal-chat/server/metrics"
 	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+	"terminal-chat/server/storage"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to channels
+const (
+	// historyLimit is the number of recent messages kept per channel
+	historyLimit = 200
+
+	// inboundBufferSize lets readers keep going while the hub is busy
+	inboundBufferSize = 1024
+
+	// slowConsumerReason is the close reason for evicted sessions
+	slowConsumerReason = "too slow to keep up"
+
+	// unlistedLabel is the metric label shared by channels that are not
+	// listed; it cannot be a channel ID
+	unlistedLabel = "_unlisted"
+)
+
+// Inbound is an event received from a client
+type Inbound struct {
+	Client *models.Client
+	Event  models.Event
+
+	// Ack asks for an "ack" event once a message has been accepted
+	Ack bool
+}
+
+// Hub owns the lifecycle of every client session and routes events to
+// channel actors, which do the fan-out. Only the Run goroutine touches the
+// hub's maps and only it closes a client's Send buffer, after every channel
+// has confirmed it will no longer send to that client.
 type Hub struct {
-	// Registered clients
-	clients map[*models.Client]bool
+	// Registered clients and the channels each has joined
+	clients map[*models.Client]map[string]bool
+
+	// Channel actors by ID
+	channels map[string]*channel
+
+	// Channels offered to clients, in the order they are listed
+	catalog []*models.Channel
+
+	// Active bans by BanKey
+	bans map[string]models.Ban
 
-	// Inbound messages from the clients
-	Broadcast chan []byte
+	// Profiles users filled in, by BanKey of their username; every session
+	// of a user shares one
+	profiles map[string]*models.User
+
+	// Slash commands run by the server, by name
+	builtins map[string]*Builtin
+
+	// Slash commands registered by bot sessions, by name
+	commands map[string]*Command
+
+	// Inbound events from the clients
+	Inbound chan Inbound
 
 	// Register requests from the clients
 	Register chan *models.Client
@@ -22,148 +78,656 @@ type Hub struct {
 	// Unregister requests from clients
 	Unregister chan *models.Client
 
-	// Channel subscriptions: channelID -> clients
-	channels map[string]map[*models.Client]bool
+	// Clients whose send buffer overflowed, reported by channels
+	evict chan *models.Client
+
+	// Operations run on the hub goroutine, used by accessors
+	ops chan func()
+
+	// Backplane to other nodes, if clustered
+	backplane backplane.Backplane
 
-	// Mutex for thread safety
-	mu sync.RWMutex
+	// Observers of locally received channel events, such as webhooks
+	observers []Observer
+
+	// Files uploaded to channels, if the server stores any
+	files FileLister
+
+	// changed holds a signal once state worth saving has changed
+	changed chan struct{}
+
+	// Set once draining starts; new clients are turned away with drainReason.
+	// draining may be read from any goroutine.
+	draining    atomic.Bool
+	drainReason string
+
+	// quit asks Run to return; stopped is closed once it has
+	quit    chan struct{}
+	stopped chan struct{}
 }
 
 // NewHub creates a new hub
 func NewHub() *Hub {
-	return &Hub{
-		Broadcast:  make(chan []byte),
+	h := &Hub{
+		clients:    make(map[*models.Client]map[string]bool),
+		channels:   make(map[string]*channel),
+		bans:       make(map[string]models.Ban),
+		builtins:   make(map[string]*Builtin),
+		profiles:   make(map[string]*models.User),
+		commands:   make(map[string]*Command),
+		Inbound:    make(chan Inbound, inboundBufferSize),
 		Register:   make(chan *models.Client),
 		Unregister: make(chan *models.Client),
-		clients:    make(map[*models.Client]bool),
-		channels:   make(map[string]map[*models.Client]bool),
+		evict:      make(chan *models.Client),
+		ops:        make(chan func()),
+		changed:    make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	for _, b := range h.builtinCommands() {
+		h.HandleCommand(b)
 	}
+	return h
 }
 
-// Run starts the hub and handles client registration, unregistration, and message broadcasting
+// Observer is told about every channel event received from a local client.
+// Observe is called on the hub goroutine and must not block.
+type Observer interface {
+	Observe(event models.Event)
+}
+
+// AddObserver registers an observer. It must be called before Run.
+func (h *Hub) AddObserver(o Observer) {
+	h.observers = append(h.observers, o)
+}
+
+// Restore loads persisted state. It must be called before Run.
+func (h *Hub) Restore(snap *storage.Snapshot) {
+	for _, ch := range snap.Channels {
+		h.catalog = append(h.catalog, &ch)
+	}
+	for channelID, events := range snap.History {
+		if models.ValidChannelID(channelID) {
+			h.channels[channelID] = h.newChannel(channelID, events)
+		}
+	}
+	now := time.Now()
+	for _, ban := range snap.Bans {
+		if ban.Active(now) {
+			h.bans[models.BanKey(ban.Username)] = ban
+		}
+	}
+	for _, user := range snap.Profiles {
+		if user.Username != "" {
+			h.profiles[models.BanKey(user.Username)] = &user
+		}
+	}
+}
+
+// EnsureChannels adds any of ids missing from the channel list. Channels
+// that were archived stay archived. It must be called before Run.
+func (h *Hub) EnsureChannels(ids []string) {
+	for _, id := range ids {
+		if h.findChannel(id) == nil {
+			h.catalog = append(h.catalog, &models.Channel{ID: id, Name: id, CreatedAt: time.Now()})
+			if ch := h.channels[id]; ch != nil {
+				ch.relabel(id)
+			}
+		}
+	}
+}
+
+// newChannel starts the actor for a channel, labelling its metrics with
+// its ID only if it is listed
+func (h *Hub) newChannel(id string, history []models.Event) *channel {
+	return newChannel(id, h.metricLabel(id), history, h.reportEviction)
+}
+
+// metricLabel is the label recorded for a channel's metrics. Clients cannot
+// add label values by naming channels that are not listed.
+func (h *Hub) metricLabel(id string) string {
+	if h.findChannel(id) == nil {
+		return unlistedLabel
+	}
+	return id
+}
+
+// SetBackplane connects the hub to other nodes. It must be called before Run.
+func (h *Hub) SetBackplane(bp backplane.Backplane) {
+	h.backplane = bp
+}
+
+// FileLister lists the files uploaded to a channel. List is called on the
+// hub goroutine and must not block on I/O.
+type FileLister interface {
+	List(channel string) []models.File
+}
+
+// SetFiles lets members list the files uploaded to their channels. It must
+// be called before Run.
+func (h *Hub) SetFiles(files FileLister) {
+	h.files = files
+}
+
+// Run starts the hub and handles client registration, unregistration, and
+// event routing. It returns after Shutdown.
 func (h *Hub) Run() {
+	defer close(h.stopped)
+
+	// A nil channel never fires, so a standalone hub ignores this case
+	var remote <-chan backplane.Envelope
+	if h.backplane != nil {
+		remote = h.backplane.Messages()
+	}
+
 	for {
 		select {
 		case client := <-h.Register:
-			h.clients[client] = true
-			log.Printf("Client %s connected", client.Username)
+			start := time.Now()
+			if h.draining.Load() {
+				closeClient(client, models.CloseServerRestart, h.drainReason)
+			} else {
+				h.clients[client] = make(map[string]bool)
+				logging.ForClient(client).Info("Client connected")
+			}
+			observeLoop("register", start)
 
 		case client := <-h.Unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
-
-				// Remove from all channels
-				h.mu.Lock()
-				for channelID, clients := range h.channels {
-					delete(clients, client)
-					if len(clients) == 0 {
-						delete(h.channels, channelID)
-					}
-				}
-				h.mu.Unlock()
-
-				log.Printf("Client %s disconnected", client.Username)
+			start := time.Now()
+			if h.removeClient(client, 0, "") {
+				logging.ForClient(client).Info("Client disconnected")
 			}
+			observeLoop("unregister", start)
 
-		case message := <-h.Broadcast:
-			var event models.Event
-			if err := json.Unmarshal(message, &event); err != nil {
-				log.Printf("Error unmarshaling message: %v", err)
-				continue
-			}
+		case client := <-h.evict:
+			start := time.Now()
+			h.evictClient(client)
+			observeLoop("evict", start)
+
+		case in := <-h.Inbound:
+			start := time.Now()
+			h.handleEvent(in.Client, in.Event, in.Ack)
+			observeLoop("event", start)
+
+		case env := <-remote:
+			start := time.Now()
+			h.handleRemote(env)
+			observeLoop("remote", start)
+
+		case op := <-h.ops:
+			start := time.Now()
+			op()
+			observeLoop("call", start)
+
+		case <-h.quit:
+			h.stopChannels()
+			return
+		}
+	}
+}
+
+// observeLoop records how long the hub spent on one operation
+func observeLoop(op string, start time.Time) {
+	metrics.HubLoopDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// call runs op on the hub goroutine and waits for it, reporting false if
+// the hub has stopped. It must not be used from the hub goroutine itself.
+func (h *Hub) call(op func()) bool {
+	finished := make(chan struct{})
+	select {
+	case h.ops <- func() {
+		op()
+		close(finished)
+	}:
+	case <-h.stopped:
+		return false
+	}
+	<-finished
+	return true
+}
+
+// ErrStopped is returned by Ping once the hub has shut down
+var ErrStopped = errors.New("hub stopped")
+
+// Ping waits for the hub goroutine to run an empty operation, showing that
+// its loop is still making progress. It fails if ctx expires first.
+func (h *Hub) Ping(ctx context.Context) error {
+	finished := make(chan struct{})
+	select {
+	case h.ops <- func() { close(finished) }:
+	case <-h.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Changed receives a value after the channel list, bans, topics or
+// profiles change, so they can be saved. Changes made before the value is
+// received are coalesced into it.
+func (h *Hub) Changed() <-chan struct{} {
+	return h.changed
+}
+
+// markChanged signals Changed without blocking
+func (h *Hub) markChanged() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Draining reports whether Shutdown has started
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// removeClient takes a client out of every channel and then closes its send
+// buffer. It reports whether the client was registered.
+func (h *Hub) removeClient(client *models.Client, code int, reason string) bool {
+	joined, ok := h.clients[client]
+	if !ok {
+		return false
+	}
+	delete(h.clients, client)
+
+	for channelID := range joined {
+		h.leaveChannel(client, channelID)
+	}
+	h.dropCommands(client)
+	closeClient(client, code, reason)
+	return true
+}
+
+// reportEviction asks Run to evict a client. It is called from channel
+// actors, which the hub may be waiting on, so it never blocks the caller.
+func (h *Hub) reportEviction(client *models.Client) {
+	go func() {
+		select {
+		case h.evict <- client:
+		case <-h.stopped:
+		}
+	}()
+}
+
+// evictClient disconnects a client whose outbox overflowed, telling it to
+// resync once it reconnects
+func (h *Hub) evictClient(client *models.Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	notice, err := json.Marshal(models.Event{
+		Type:      "resync",
+		Content:   "You fell too far behind and were disconnected; reconnect to resync",
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"reason": "slow_consumer"},
+	})
+	if err == nil {
+		client.Send.Notify(notice)
+	}
+
+	stats := client.Send.Stats()
+	h.removeClient(client, models.CloseSlowConsumer, slowConsumerReason)
+	metrics.Evictions.Inc()
+	logging.ForClient(client).Warn("Client evicted: outbox full", "queued", stats.Depth, "capacity", stats.Capacity)
+}
+
+// closeClient closes the client's outbox; its writer flushes what is
+// queued and then sends a close frame carrying reason
+func closeClient(client *models.Client, code int, reason string) {
+	client.Send.Close(code, reason)
+}
+
+// Shutdown closes every client connection with reason once its pending
+// messages are written, waits for the writes to finish or ctx to expire,
+// then stops Run and returns the state to persist. Messages that arrive
+// while draining are still recorded in the history.
+func (h *Hub) Shutdown(ctx context.Context, reason string) *storage.Snapshot {
+	var drained []*models.Client
+	h.call(func() {
+		h.draining.Store(true)
+		h.drainReason = reason
+		for client := range h.clients {
+			h.removeClient(client, models.CloseServerRestart, reason)
+			drained = append(drained, client)
+		}
+		slog.Info("Draining clients", "clients", len(drained), "reason", reason)
+	})
+
+	for _, client := range drained {
+		select {
+		case <-client.Closed:
+		case <-ctx.Done():
+			logging.ForClient(client).Warn("Shutdown timed out waiting for client to flush")
+		}
+	}
+
+	snap := h.Snapshot()
+	close(h.quit)
+	<-h.stopped
+	return snap
+}
 
-			h.handleEvent(event, message)
+// Snapshot returns the state to persist: recent history, the channel list,
+// bans and profiles
+func (h *Hub) Snapshot() *storage.Snapshot {
+	snap := &storage.Snapshot{History: make(map[string][]models.Event)}
+	h.call(func() {
+		for channelID, ch := range h.channels {
+			if _, history := ch.snapshot(); len(history) > 0 {
+				snap.History[channelID] = history
+			}
+		}
+		for _, ch := range h.catalog {
+			snap.Channels = append(snap.Channels, *ch)
 		}
+		snap.Bans = h.activeBans()
+		snap.Profiles = h.savedProfiles()
+	})
+	return snap
+}
+
+// stopChannels ends every channel actor
+func (h *Hub) stopChannels() {
+	for channelID, ch := range h.channels {
+		ch.stop()
+		delete(h.channels, channelID)
 	}
 }
 
 // handleEvent processes different types of events
-func (h *Hub) handleEvent(event models.Event, rawMessage []byte) {
+func (h *Hub) handleEvent(client *models.Client, event models.Event, ack bool) {
+	if _, ok := h.clients[client]; !ok && !h.draining.Load() {
+		// The client was removed while the event was in flight
+		return
+	}
+
+	// Clients may only use listed channels, so the channel IDs they make up
+	// never start channels, history or metrics
+	if h.findChannel(event.Channel) == nil {
+		switch event.Type {
+		case "join_channel", "get_history", "list_files", "typing_start", "typing_stop", "user_joined", "user_left":
+			h.rejectUnknownChannel(client, event)
+			return
+		}
+	}
+
+	if ch := h.findChannel(event.Channel); ch != nil && ch.Archived {
+		switch event.Type {
+		case "join_channel", "send_message", "typing_start", "set_topic":
+			rejected := errorEvent("channel_archived", "#"+event.Channel+" has been archived")
+			rejected.Data["channel"] = event.Channel
+			if event.Type == "send_message" {
+				rejected.Data["id"] = event.ID
+			}
+			h.send(client, rejected)
+			return
+		}
+	}
+
 	switch event.Type {
 	case "join_channel":
-		h.joinChannel(event.Channel, event.From)
+		h.joinChannel(client, event.Channel)
 	case "leave_channel":
-		h.leaveChannel(event.Channel, event.From)
+		h.leaveChannel(client, event.Channel)
+	case "get_history":
+		ch := h.channels[event.Channel]
+		if ch == nil || !h.clients[client][event.Channel] {
+			rejected := errorEvent("not_joined", "Join #"+event.Channel+" to see its history")
+			rejected.Data["channel"] = event.Channel
+			h.send(client, rejected)
+			return
+		}
+		ch.replayHistory(client)
+	case "list_files":
+		h.listFiles(client, event)
+	case "set_topic":
+		h.setTopic(client, event)
+	case "set_display_name":
+		h.setDisplayName(client, event)
+	case "get_profile":
+		h.getProfile(client, event)
+	case "update_profile":
+		h.updateProfile(client, event)
+	case "subscribe":
+		h.subscribe(client, event)
+	case "register_command":
+		h.registerCommand(client, event)
+	case "unregister_command":
+		h.unregisterCommand(client, event)
+	case "get_commands":
+		h.send(client, models.Event{
+			Type:      "commands",
+			Timestamp: time.Now().Unix(),
+			Data:      map[string]interface{}{"commands": h.listCommands()},
+		})
 	case "send_message":
-		h.broadcastToChannel(event.Channel, rawMessage)
-	case "typing_start", "typing_stop":
-		h.broadcastToChannel(event.Channel, rawMessage)
-	case "user_joined", "user_left":
-		h.broadcastToChannel(event.Channel, rawMessage)
+		// A leading slash invokes a command; a doubled one posts the text
+		// with one slash
+		if line, ok := strings.CutPrefix(event.Content, "/"); ok {
+			if !strings.HasPrefix(line, "/") {
+				if h.runCommand(client, event, line) && ack {
+					h.send(client, ackEvent(event))
+				}
+				return
+			}
+			event.Content = line
+		}
+		if h.findChannel(event.Channel) == nil {
+			h.rejectUnknownChannel(client, event)
+			return
+		}
+		// Bots answer slash commands in the channel they came from, which
+		// the invoker must be in but the bot need not be. Clients are
+		// removed when draining starts, so their last messages are taken
+		// as they are.
+		if !client.Bot && !h.draining.Load() && !h.clients[client][event.Channel] {
+			rejected := errorEvent("not_joined", "Join #"+event.Channel+" to post there")
+			rejected.Data["channel"] = event.Channel
+			rejected.Data["id"] = event.ID
+			h.send(client, rejected)
+			return
+		}
+		h.postMessage(client, event)
+		if ack {
+			h.send(client, ackEvent(event))
+		}
+	case "typing_start", "typing_stop", "user_joined", "user_left":
+		h.broadcastToChannel(event)
 	}
 }
 
-// joinChannel adds a client to a channel
-func (h *Hub) joinChannel(channelID, username string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// rejectUnknownChannel tells a client the channel it used is not listed
+func (h *Hub) rejectUnknownChannel(client *models.Client, event models.Event) {
+	rejected := errorEvent("not_found", "There is no #"+event.Channel)
+	rejected.Data["channel"] = event.Channel
+	if event.Type == "send_message" {
+		rejected.Data["id"] = event.ID
+	}
+	h.send(client, rejected)
+}
 
-	if h.channels[channelID] == nil {
-		h.channels[channelID] = make(map[*models.Client]bool)
+// ackEvent confirms to its sender that a message was accepted
+func ackEvent(event models.Event) models.Event {
+	return models.Event{
+		ID:        event.ID,
+		Type:      "ack",
+		Channel:   event.Channel,
+		Timestamp: event.Timestamp,
 	}
+}
 
-	// Find client by username (in a real app, you'd have a better lookup)
-	for client := range h.clients {
-		if client.Username == username {
-			h.channels[channelID][client] = true
-			log.Printf("User %s joined channel %s", username, channelID)
-			break
-		}
+// joinChannel adds a client to a channel, starting the channel if needed
+func (h *Hub) joinChannel(client *models.Client, channelID string) {
+	joined := h.clients[client]
+	if joined == nil || joined[channelID] {
+		return
 	}
+
+	ch := h.channels[channelID]
+	if ch == nil {
+		ch = h.newChannel(channelID, nil)
+		h.channels[channelID] = ch
+	}
+
+	joined[channelID] = true
+	ch.join(client)
 }
 
-// leaveChannel removes a client from a channel
-func (h *Hub) leaveChannel(channelID, username string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// leaveChannel removes a client from a channel, stopping the channel if it
+// is left empty with no history
+func (h *Hub) leaveChannel(client *models.Client, channelID string) {
+	if joined := h.clients[client]; joined != nil {
+		delete(joined, channelID)
+	}
 
-	if clients, exists := h.channels[channelID]; exists {
-		for client := range clients {
-			if client.Username == username {
-				delete(clients, client)
-				log.Printf("User %s left channel %s", username, channelID)
-				break
-			}
-		}
+	ch := h.channels[channelID]
+	if ch == nil {
+		return
+	}
+	if ch.leave(client) {
+		ch.stop()
+		delete(h.channels, channelID)
+	}
+}
+
+// broadcastToChannel hands a locally received event to its channel for
+// fan-out, to the other nodes and to the observers. Events from other nodes
+// are not observed here, so each event is observed once per cluster.
+func (h *Hub) broadcastToChannel(event models.Event) {
+	if h.backplane != nil {
+		h.backplane.Publish(event)
+	}
+	for _, o := range h.observers {
+		o.Observe(event)
+	}
+	h.fanOut(event)
+}
 
-		if len(clients) == 0 {
-			delete(h.channels, channelID)
+// handleRemote fans out an event received from another node. It is not
+// published again; every node receives events directly from their origin.
+func (h *Hub) handleRemote(env backplane.Envelope) {
+	switch env.Event.Type {
+	case "send_message", "system_message", "user_renamed", "profile_updated", "typing_start", "typing_stop", "user_joined", "user_left":
+		h.fanOut(env.Event)
+	}
+}
+
+// fanOut hands an event to its channel. Events for channels nobody has
+// joined are dropped, except chat messages to listed channels, which start
+// the channel so they are kept in its history.
+func (h *Hub) fanOut(event models.Event) {
+	ch := h.channels[event.Channel]
+	if ch == nil {
+		if event.Type != "send_message" || h.findChannel(event.Channel) == nil {
+			slog.Debug("No clients in channel", "type", event.Type, "channel", event.Channel)
+			return
 		}
+		ch = h.newChannel(event.Channel, nil)
+		h.channels[event.Channel] = ch
+	}
+	ch.publish(event)
+}
+
+// SendTo queues an event for a single client, if it is still connected
+func (h *Hub) SendTo(client *models.Client, event models.Event) {
+	h.call(func() {
+		h.send(client, event)
+	})
+}
+
+// send queues an event for a client from the hub goroutine, evicting the
+// client if its outbox is full
+func (h *Hub) send(client *models.Client, event models.Event) {
+	if _, ok := h.clients[client]; !ok || !client.Wants(event.Type) {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Marshaling event", "type", event.Type, "err", err)
+		return
+	}
+	if err := client.Send.Push(outbox.Message{Data: data}); err == outbox.ErrOverflow {
+		metrics.DroppedMessages.WithLabelValues("overflow").Inc()
+		h.evictClient(client)
+	}
+}
+
+// errorEvent builds an error event carrying code in its data
+func errorEvent(code, content string) models.Event {
+	return models.Event{
+		Type:      "error",
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]interface{}{"code": code},
 	}
 }
 
-// broadcastToChannel sends a message to all clients in a specific channel
-func (h *Hub) broadcastToChannel(channelID string, message []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// SessionStats describes a connected session and its outbox
+type SessionStats struct {
+	ID          string       `json:"id"`
+	Username    string       `json:"username"`
+	DisplayName string       `json:"display_name,omitempty"`
+	Bot         bool         `json:"bot,omitempty"`
+	RemoteAddr  string       `json:"remote_addr,omitempty"`
+	ConnectedAt time.Time    `json:"connected_at"`
+	Channels    []string     `json:"channels"`
+	Outbox      outbox.Stats `json:"outbox"`
+}
 
-	if clients, exists := h.channels[channelID]; exists {
-		log.Printf("Broadcasting to %d clients in channel %s", len(clients), channelID)
-		for client := range clients {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-				delete(clients, client)
+// Sessions returns outbox statistics for every connected session
+func (h *Hub) Sessions() []SessionStats {
+	var sessions []SessionStats
+	h.call(func() {
+		for client, joined := range h.clients {
+			stats := SessionStats{
+				ID:          client.ID,
+				Username:    client.Username,
+				DisplayName: h.displayName(client.Username),
+				Bot:         client.Bot,
+				RemoteAddr:  client.RemoteAddr,
+				ConnectedAt: client.ConnectedAt,
+				Channels:    []string{},
+				Outbox:      client.Send.Stats(),
 			}
+			for channelID := range joined {
+				stats.Channels = append(stats.Channels, channelID)
+			}
+			sort.Strings(stats.Channels)
+			sessions = append(sessions, stats)
 		}
-	} else {
-		log.Printf("No clients in channel %s", channelID)
-	}
+	})
+	return sessions
+}
+
+// Channels returns the IDs of the active channels
+func (h *Hub) Channels() []string {
+	var ids []string
+	h.call(func() {
+		for channelID := range h.channels {
+			ids = append(ids, channelID)
+		}
+	})
+	return ids
 }
 
 // GetClientsInChannel returns all clients in a channel (for debugging/admin purposes)
 func (h *Hub) GetClientsInChannel(channelID string) []*models.Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
 	var clients []*models.Client
-	if channelClients, exists := h.channels[channelID]; exists {
-		for client := range channelClients {
-			clients = append(clients, client)
+	h.call(func() {
+		if ch := h.channels[channelID]; ch != nil {
+			clients, _ = ch.snapshot()
 		}
-	}
+	})
 	return clients
 }
@@ -0,0 +1,688 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"terminal-chat/server/backplane"
+	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+	"terminal-chat/server/storage"
+)
+
+// timeout bounds every wait in these tests
+const timeout = 10 * time.Second
+
+// testClient is a session with a writer that decodes what the hub queues
+// for it, standing in for a WebSocket connection
+type testClient struct {
+	*models.Client
+	events chan models.Event
+
+	// pending are events received while expecting another, in order
+	pending []models.Event
+
+	// resume lets a paused writer start draining
+	resume chan struct{}
+
+	// code and reason are the close frame the writer would have sent; they
+	// are set before Closed is closed
+	code   int
+	reason string
+}
+
+// marker is the type of the events sync pushes through a client's outbox
+const marker = "test_marker"
+
+// newTestClient creates a session whose outbox holds capacity messages.
+// A paused client queues messages without draining them until unpause.
+func newTestClient(t *testing.T, username string, capacity int, paused bool) *testClient {
+	t.Helper()
+	c := &testClient{
+		Client: &models.Client{
+			ID:          username + "-session",
+			Username:    username,
+			Send:        outbox.New(capacity, outbox.Disconnect),
+			Closed:      make(chan struct{}),
+			ConnectedAt: time.Now(),
+		},
+		events: make(chan models.Event, capacity+256),
+		resume: make(chan struct{}),
+	}
+	if !paused {
+		close(c.resume)
+	}
+	go c.write()
+	return c
+}
+
+// write drains the outbox like the WebSocket writer, until it is closed
+func (c *testClient) write() {
+	defer close(c.Closed)
+	<-c.resume
+	for range c.Send.Ready() {
+		batch := c.Send.Drain()
+		for _, data := range batch.Messages {
+			var event models.Event
+			if err := json.Unmarshal(data, &event); err != nil {
+				panic(err)
+			}
+			c.events <- event
+		}
+		if batch.Closed {
+			c.code, c.reason = batch.Code, batch.Reason
+			return
+		}
+	}
+}
+
+// unpause lets a paused client drain its outbox
+func (c *testClient) unpause() {
+	close(c.resume)
+}
+
+// send hands an event from the client to the hub, as the reader would
+func (c *testClient) send(h *Hub, event models.Event, ack bool) {
+	event.From = c.Username
+	event.Timestamp = time.Now().Unix()
+	h.Inbound <- Inbound{Client: c.Client, Event: event, Ack: ack}
+}
+
+// join joins a channel and waits until the channel has the client as a
+// member, shown by the history it sends on request
+func (c *testClient) join(t *testing.T, h *Hub, channelID string) {
+	t.Helper()
+	c.send(h, models.Event{Type: "join_channel", Channel: channelID}, false)
+	c.send(h, models.Event{Type: "get_history", Channel: channelID}, false)
+	c.expect(t, func(e models.Event) bool { return e.Type == "history" && e.Channel == channelID })
+}
+
+// post sends a chat message and waits for the hub to accept it
+func (c *testClient) post(t *testing.T, h *Hub, channelID, id, content string) {
+	t.Helper()
+	c.send(h, models.Event{ID: id, Type: "send_message", Channel: channelID, Content: content}, true)
+	c.expect(t, func(e models.Event) bool { return e.Type == "ack" && e.ID == id })
+}
+
+// next returns the next event received
+func (c *testClient) next(t *testing.T) models.Event {
+	t.Helper()
+	if len(c.pending) > 0 {
+		event := c.pending[0]
+		c.pending = c.pending[1:]
+		return event
+	}
+	select {
+	case event := <-c.events:
+		return event
+	case <-time.After(timeout):
+		t.Fatalf("%s: timed out waiting for an event", c.Username)
+		return models.Event{}
+	}
+}
+
+// expect returns the first event that matches, receiving more if needed.
+// The events before it are left for next.
+func (c *testClient) expect(t *testing.T, match func(models.Event) bool) models.Event {
+	t.Helper()
+	for i, event := range c.pending {
+		if match(event) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return event
+		}
+	}
+	skipped := c.pending
+	c.pending = nil
+	defer func() { c.pending = skipped }()
+	for {
+		event := c.next(t)
+		if match(event) {
+			return event
+		}
+		skipped = append(skipped, event)
+	}
+}
+
+// rest returns every event not yet taken, once the session has closed
+func (c *testClient) rest(t *testing.T) []models.Event {
+	t.Helper()
+	c.waitClosed(t)
+	events := c.pending
+	c.pending = nil
+	for len(c.events) > 0 {
+		events = append(events, <-c.events)
+	}
+	return events
+}
+
+// sync returns everything queued for the client so far. A marker is pushed
+// behind it, so nothing still in the outbox is missed.
+func (c *testClient) sync(t *testing.T) []models.Event {
+	t.Helper()
+	data, _ := json.Marshal(models.Event{Type: marker})
+	if err := c.Send.Push(outbox.Message{Data: data}); err != nil {
+		t.Fatalf("%s: pushing marker: %v", c.Username, err)
+	}
+
+	var events []models.Event
+	for {
+		event := c.next(t)
+		if event.Type == marker {
+			return events
+		}
+		events = append(events, event)
+	}
+}
+
+// waitClosed waits for the writer to send its close frame
+func (c *testClient) waitClosed(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.Closed:
+	case <-time.After(timeout):
+		t.Fatalf("%s: timed out waiting for the session to close", c.Username)
+	}
+}
+
+// startHub runs a hub listing channels and shuts it down when the test ends
+func startHub(t *testing.T, channels ...string) *Hub {
+	t.Helper()
+	return startNode(t, nil, channels...)
+}
+
+// startNode runs a hub connected to other nodes over bp, like startHub
+func startNode(t *testing.T, bp backplane.Backplane, channels ...string) *Hub {
+	t.Helper()
+	h := NewHub()
+	h.EnsureChannels(channels)
+	if bp != nil {
+		h.SetBackplane(bp)
+	}
+	go h.Run()
+	t.Cleanup(func() {
+		select {
+		case <-h.stopped:
+		default:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			h.Shutdown(ctx, "test finished")
+		}
+	})
+	return h
+}
+
+// register connects a new client to the hub
+func register(t *testing.T, h *Hub, username string) *testClient {
+	t.Helper()
+	c := newTestClient(t, username, 256, false)
+	h.Register <- c.Client
+	return c
+}
+
+// messages returns the contents of the chat messages among events
+func messages(events []models.Event, channelID string) []string {
+	var contents []string
+	for _, event := range events {
+		if event.Type == "send_message" && event.Channel == channelID {
+			contents = append(contents, event.Content)
+		}
+	}
+	return contents
+}
+
+func TestJoinSendLeave(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	bob := register(t, h, "bob")
+	alice.join(t, h, "general")
+	bob.join(t, h, "general")
+
+	alice.post(t, h, "general", "m1", "hello")
+	for _, c := range []*testClient{alice, bob} {
+		got := c.expect(t, func(e models.Event) bool { return e.Type == "send_message" })
+		if got.ID != "m1" || got.Content != "hello" || got.From != "alice" || got.Channel != "general" {
+			t.Errorf("%s received %+v", c.Username, got)
+		}
+	}
+
+	// The hub handles bob's leave before alice's message, whose ack
+	// shows it has been handed to the channel
+	bob.send(h, models.Event{Type: "leave_channel", Channel: "general"}, false)
+	alice.post(t, h, "general", "m2", "still there?")
+	if members := h.GetClientsInChannel("general"); len(members) != 1 || members[0] != alice.Client {
+		t.Fatalf("members after leaving = %v, want only alice", members)
+	}
+	if got := messages(alice.sync(t), "general"); len(got) != 1 || got[0] != "still there?" {
+		t.Errorf("alice received %q after bob left", got)
+	}
+	if got := messages(bob.sync(t), "general"); len(got) != 0 {
+		t.Errorf("bob received %q after leaving", got)
+	}
+
+	// Joining again replays what was missed
+	bob.send(h, models.Event{Type: "join_channel", Channel: "general"}, false)
+	history := bob.expect(t, func(e models.Event) bool { return e.Type == "history" })
+	if n := len(history.Data["messages"].([]interface{})); n != 2 {
+		t.Errorf("history has %d messages, want 2", n)
+	}
+}
+
+func TestUnregisterLeavesChannels(t *testing.T) {
+	h := startHub(t, "general", "random")
+	alice := register(t, h, "alice")
+	alice.join(t, h, "general")
+	alice.join(t, h, "random")
+
+	h.Unregister <- alice.Client
+	alice.waitClosed(t)
+	if alice.code != 0 {
+		t.Errorf("close code = %d, want a normal close", alice.code)
+	}
+	for _, channelID := range []string{"general", "random"} {
+		if members := h.GetClientsInChannel(channelID); len(members) != 0 {
+			t.Errorf("#%s still has %d members", channelID, len(members))
+		}
+	}
+	if sessions := h.Sessions(); len(sessions) != 0 {
+		t.Errorf("%d sessions left after unregistering", len(sessions))
+	}
+}
+
+func TestSlowConsumerEvicted(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	alice.join(t, h, "general")
+
+	// The slow client never drains its outbox until it has been evicted
+	slow := newTestClient(t, "slow", 4, true)
+	h.Register <- slow.Client
+	slow.send(h, models.Event{Type: "join_channel", Channel: "general"}, false)
+
+	const sent = 10
+	for i := range sent {
+		alice.post(t, h, "general", fmt.Sprint("m", i), fmt.Sprint("message ", i))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for len(h.Sessions()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client was not evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	slow.unpause()
+	slow.waitClosed(t)
+	if slow.code != models.CloseSlowConsumer || slow.reason != slowConsumerReason {
+		t.Errorf("closed with %d %q, want %d %q", slow.code, slow.reason, models.CloseSlowConsumer, slowConsumerReason)
+	}
+	var resync bool
+	for _, event := range slow.rest(t) {
+		resync = resync || event.Type == "resync" && event.Data["reason"] == "slow_consumer"
+	}
+	if !resync {
+		t.Error("slow client was not told to resync")
+	}
+
+	// Everyone else keeps receiving
+	if got := messages(alice.sync(t), "general"); len(got) != sent {
+		t.Errorf("alice received %d messages, want %d", len(got), sent)
+	}
+	if members := h.GetClientsInChannel("general"); len(members) != 1 {
+		t.Errorf("#general has %d members after eviction, want 1", len(members))
+	}
+}
+
+func TestShutdownDrainsClients(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	bob := register(t, h, "bob")
+	alice.join(t, h, "general")
+	bob.join(t, h, "general")
+	for i := range 3 {
+		alice.post(t, h, "general", fmt.Sprint("m", i), fmt.Sprint("message ", i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	snap := h.Shutdown(ctx, "server restarting")
+
+	if !h.Draining() {
+		t.Error("hub is not draining after shutdown")
+	}
+	for _, c := range []*testClient{alice, bob} {
+		c.waitClosed(t)
+		if c.code != models.CloseServerRestart || c.reason != "server restarting" {
+			t.Errorf("%s closed with %d %q", c.Username, c.code, c.reason)
+		}
+
+		// Everything queued before the close was written first
+		if got := messages(c.rest(t), "general"); len(got) != 3 {
+			t.Errorf("%s received %d messages before closing, want 3", c.Username, len(got))
+		}
+	}
+
+	if n := len(snap.History["general"]); n != 3 {
+		t.Errorf("snapshot has %d messages for #general, want 3", n)
+	}
+	if len(snap.Channels) != 1 || snap.Channels[0].ID != "general" {
+		t.Errorf("snapshot channels = %+v", snap.Channels)
+	}
+
+	// Calls made after the hub stopped return instead of blocking
+	if sessions := h.Sessions(); len(sessions) != 0 {
+		t.Errorf("stopped hub reports %d sessions", len(sessions))
+	}
+	if err := h.Ping(context.Background()); err != ErrStopped {
+		t.Errorf("Ping after shutdown = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestShutdownKeepsMessagesInFlight(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	alice.join(t, h, "general")
+	// Shutdown waits for carol, who is not reading, to flush. Register is
+	// unbuffered, so she is registered before Shutdown starts.
+	carol := newTestClient(t, "carol", 256, true)
+	h.Register <- carol.Client
+
+	snaps := make(chan *storage.Snapshot, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		snaps <- h.Shutdown(ctx, "server restarting")
+	}()
+	deadline := time.Now().Add(timeout)
+	for !h.Draining() {
+		if time.Now().After(deadline) {
+			t.Fatal("hub not draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Alice has been removed by now, but her message was sent before
+	alice.send(h, models.Event{ID: "late", Type: "send_message", Channel: "general", Content: "last words"}, true)
+	for {
+		history, err := h.History("general")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 1 && history[0].Content == "last words" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("history = %+v, want the message sent while draining", history)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	carol.unpause()
+	if snap := <-snaps; len(snap.History["general"]) != 1 || snap.History["general"][0].Content != "last words" {
+		t.Errorf("snapshot history = %+v", snap.History["general"])
+	}
+}
+
+// TestConcurrentClients drives many sessions at once while admin calls run
+// on the hub, for the race detector
+func TestConcurrentClients(t *testing.T) {
+	// Thousands of sessions spread over channels, so that each message fans
+	// out to a channel's members rather than to everyone
+	numClients, numChannels, perClient := 3000, 60, 1
+	if testing.Short() {
+		numClients, numChannels, perClient = 200, 4, 5
+	}
+	channelIDs := make([]string, numChannels)
+	for i := range channelIDs {
+		channelIDs[i] = fmt.Sprint("room", i)
+	}
+	members := numClients / numChannels
+	h := startHub(t, channelIDs...)
+
+	clients := make([]*testClient, numClients)
+	for i := range clients {
+		// Room for every message in the channel and the announcements made
+		// while the clients are not reading
+		clients[i] = newTestClient(t, fmt.Sprint("user", i), 2*members*perClient+256, false)
+	}
+	channelOf := func(i int) string { return channelIDs[i%numChannels] }
+
+	var wg sync.WaitGroup
+	each := func(f func(i int, c *testClient)) {
+		for i, c := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f(i, c)
+			}()
+		}
+		wg.Wait()
+	}
+
+	// Admin calls run alongside everything the clients do
+	stopCalls := make(chan struct{})
+	callsDone := make(chan struct{})
+	go func() {
+		defer close(callsDone)
+		for {
+			select {
+			case <-stopCalls:
+				return
+			default:
+			}
+			h.Sessions()
+			h.ListChannels()
+			h.ListUsers()
+			h.Snapshot()
+			h.Announce("hello everyone")
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	each(func(i int, c *testClient) {
+		h.Register <- c.Client
+		c.join(t, h, channelOf(i))
+	})
+
+	// The acks are counted with the messages below: waiting for each in turn
+	// would leave the last clients queued behind everyone else's posts
+	each(func(i int, c *testClient) {
+		for n := range perClient {
+			c.send(h, models.Event{ID: fmt.Sprint(c.Username, "-", n), Type: "send_message", Channel: channelOf(i), Content: "hi"}, true)
+		}
+	})
+
+	// Every member receives every message in its channel, its own included,
+	// and an ack for each of its own
+	each(func(i int, c *testClient) {
+		received, acked := 0, 0
+		for received < members*perClient || acked < perClient {
+			switch event := c.next(t); event.Type {
+			case "send_message":
+				if event.Channel != channelOf(i) {
+					t.Errorf("%s in #%s received a message for #%s", c.Username, channelOf(i), event.Channel)
+				}
+				received++
+			case "ack":
+				acked++
+			case "error":
+				t.Errorf("%s: %s", c.Username, event.Content)
+			}
+		}
+	})
+
+	// Half the clients leave and the rest disconnect, all at once
+	each(func(i int, c *testClient) {
+		if i%2 == 0 {
+			c.send(h, models.Event{Type: "leave_channel", Channel: channelOf(i)}, false)
+		}
+		h.Unregister <- c.Client
+		c.waitClosed(t)
+	})
+	close(stopCalls)
+	<-callsDone
+
+	if sessions := h.Sessions(); len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+	for _, channelID := range channelIDs {
+		if left := h.GetClientsInChannel(channelID); len(left) != 0 {
+			t.Errorf("#%s has %d members left", channelID, len(left))
+		}
+		history, err := h.History(channelID)
+		if err != nil || len(history) != min(members*perClient, historyLimit) {
+			t.Errorf("#%s history = %d messages, %v; want %d", channelID, len(history), err, min(members*perClient, historyLimit))
+		}
+	}
+}
+
+func TestUnknownChannelRejected(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+
+	for _, channelID := range []string{"nowhere", "Not A Channel!"} {
+		alice.send(h, models.Event{Type: "join_channel", Channel: channelID}, false)
+		rejected := alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+		if rejected.Data["code"] != "not_found" || rejected.Data["channel"] != channelID {
+			t.Errorf("joining %q: got %+v", channelID, rejected)
+		}
+
+		alice.send(h, models.Event{ID: "m1", Type: "send_message", Channel: channelID, Content: "hi"}, true)
+		rejected = alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+		if rejected.Data["code"] != "not_found" || rejected.Data["id"] != "m1" {
+			t.Errorf("posting to %q: got %+v", channelID, rejected)
+		}
+	}
+
+	if channels := h.Channels(); len(channels) != 0 {
+		t.Errorf("active channels = %v, want none", channels)
+	}
+}
+
+func TestDisconnectBot(t *testing.T) {
+	h := startHub(t, "general")
+	deploybot := newTestClient(t, "deploybot", 256, false)
+	deploybot.Bot = true
+	h.Register <- deploybot.Client
+	// A person who happens to share the name is left alone
+	person := register(t, h, "DeployBot")
+	person.sync(t)
+
+	if n := h.DisconnectBot("DEPLOYBOT", "bot token rotated"); n != 1 {
+		t.Errorf("DisconnectBot closed %d sessions, want 1", n)
+	}
+	deploybot.waitClosed(t)
+	if deploybot.code != models.CloseKicked || deploybot.reason != "bot token rotated" {
+		t.Errorf("bot closed with %d %q", deploybot.code, deploybot.reason)
+	}
+	if sessions := h.Sessions(); len(sessions) != 1 || sessions[0].Username != "DeployBot" {
+		t.Errorf("sessions after disconnect = %+v", sessions)
+	}
+}
+
+func TestPostRequiresJoin(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	bob := register(t, h, "bob")
+	bob.join(t, h, "general")
+	deploybot := newTestClient(t, "deploybot", 256, false)
+	deploybot.Bot = true
+	h.Register <- deploybot.Client
+
+	alice.send(h, models.Event{ID: "m1", Type: "send_message", Channel: "general", Content: "hi"}, true)
+	rejected := alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+	if rejected.Data["code"] != "not_joined" || rejected.Data["id"] != "m1" || rejected.Data["channel"] != "general" {
+		t.Errorf("posting without joining: got %+v", rejected)
+	}
+
+	// Nor can she reach the channel through a bot's command
+	deploybot.send(h, models.Event{Type: "register_command", Data: map[string]interface{}{"command": "deploy"}}, false)
+	deploybot.expect(t, func(e models.Event) bool { return e.Type == "command_registered" })
+	alice.send(h, models.Event{ID: "m2", Type: "send_message", Channel: "general", Content: "/deploy api"}, true)
+	rejected = alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+	if rejected.Data["code"] != "not_joined" || rejected.Data["id"] != "m2" {
+		t.Errorf("invoking a bot command without joining: got %+v", rejected)
+	}
+
+	// A bot replies where its command was invoked without joining
+	bob.send(h, models.Event{ID: "m3", Type: "send_message", Channel: "general", Content: "/deploy api"}, false)
+	invoked := deploybot.expect(t, func(e models.Event) bool { return e.Type == "command" })
+	deploybot.post(t, h, invoked.Channel, "r1", "deploying api")
+
+	// The first message bob sees is the reply: neither of alice's got through
+	reply := bob.expect(t, func(e models.Event) bool { return e.Type == "send_message" })
+	if reply.From != "deploybot" || reply.Content != "deploying api" {
+		t.Errorf("bob received %+v, want the bot's reply", reply)
+	}
+}
+
+func TestTopicRejectsControlCharacters(t *testing.T) {
+	h := startHub(t, "general")
+	alice := register(t, h, "alice")
+	alice.join(t, h, "general")
+
+	for _, data := range []map[string]interface{}{
+		{"topic": "release \x1b[2Jfriday"},
+		{"description": "line one\nline two"},
+	} {
+		alice.send(h, models.Event{Type: "set_topic", Channel: "general", Data: data}, false)
+		rejected := alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+		if rejected.Data["code"] != "invalid_topic" {
+			t.Errorf("set_topic %q: got %+v", data, rejected)
+		}
+	}
+
+	alice.send(h, models.Event{Type: "send_message", Channel: "general", Content: "/topic bell\a"}, false)
+	rejected := alice.expect(t, func(e models.Event) bool { return e.Type == "error" })
+	if rejected.Data["code"] != "invalid_topic" {
+		t.Errorf("/topic: got %+v", rejected)
+	}
+
+	if topics := h.ChannelTopics(); len(topics) != 0 {
+		t.Errorf("topics = %+v, want none", topics)
+	}
+}
+
+func TestClusterSharesChannels(t *testing.T) {
+	bus := backplane.NewBus()
+	h1 := startNode(t, bus.Join("node1"), "general")
+	h2 := startNode(t, bus.Join("node2"), "general")
+	alice := register(t, h1, "alice")
+	bob := register(t, h2, "bob")
+	alice.join(t, h1, "general")
+	bob.join(t, h2, "general")
+
+	// Presence and typing from one node reach members on the other
+	bob.send(h2, models.Event{Type: "user_joined", Channel: "general"}, false)
+	bob.send(h2, models.Event{Type: "typing_start", Channel: "general"}, false)
+	alice.expect(t, func(e models.Event) bool { return e.Type == "user_joined" && e.From == "bob" })
+	alice.expect(t, func(e models.Event) bool { return e.Type == "typing_start" && e.From == "bob" })
+
+	// So do messages, which both nodes keep in their history
+	alice.post(t, h1, "general", "m1", "hello from node1")
+	bob.expect(t, func(e models.Event) bool { return e.Type == "send_message" && e.Content == "hello from node1" })
+	bob.post(t, h2, "general", "m2", "hello from node2")
+
+	// Each arrives once: a node does not publish what it received, so the
+	// first message is not echoed back ahead of the second
+	var received []string
+	for len(received) == 0 || received[len(received)-1] != "hello from node2" {
+		if event := alice.next(t); event.Type == "send_message" {
+			received = append(received, event.Content)
+		}
+	}
+	if want := []string{"hello from node1", "hello from node2"}; !slices.Equal(received, want) {
+		t.Errorf("alice received %q, want %q", received, want)
+	}
+	for _, h := range []*Hub{h1, h2} {
+		history, err := h.History("general")
+		if err != nil || len(history) != 2 || history[0].Content != "hello from node1" || history[1].Content != "hello from node2" {
+			t.Errorf("history = %+v, %v", history, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	connectedClientsDesc = prometheus.NewDesc("chat_connected_clients",
+		"Sessions connected to this node.", nil, nil)
+	connectedUsersDesc = prometheus.NewDesc("chat_connected_users",
+		"Distinct usernames with at least one session on this node.", nil, nil)
+	channelsDesc = prometheus.NewDesc("chat_channels",
+		"Active channels on this node.", nil, nil)
+	channelSubscribersDesc = prometheus.NewDesc("chat_channel_subscribers",
+		"Local sessions subscribed to a channel, by channel.", []string{"channel"}, nil)
+	inboundQueueDesc = prometheus.NewDesc("chat_inbound_queue_depth",
+		"Client events waiting for the hub.", nil, nil)
+)
+
+// Describe implements prometheus.Collector
+func (h *Hub) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectedClientsDesc
+	ch <- connectedUsersDesc
+	ch <- channelsDesc
+	ch <- channelSubscribersDesc
+	ch <- inboundQueueDesc
+}
+
+// Collect implements prometheus.Collector, reading the hub's state on the
+// hub goroutine. Nothing is reported once the hub has stopped.
+func (h *Hub) Collect(ch chan<- prometheus.Metric) {
+	var (
+		clients     int
+		users       = make(map[string]bool)
+		channels    int
+		subscribers = make(map[string]int)
+	)
+	ok := h.call(func() {
+		clients = len(h.clients)
+		channels = len(h.channels)
+		for client, joined := range h.clients {
+			users[client.Username] = true
+			for channelID := range joined {
+				subscribers[h.metricLabel(channelID)]++
+			}
+		}
+	})
+	if !ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(connectedClientsDesc, prometheus.GaugeValue, float64(clients))
+	ch <- prometheus.MustNewConstMetric(connectedUsersDesc, prometheus.GaugeValue, float64(len(users)))
+	ch <- prometheus.MustNewConstMetric(channelsDesc, prometheus.GaugeValue, float64(channels))
+	for channelID, n := range subscribers {
+		ch <- prometheus.MustNewConstMetric(channelSubscribersDesc, prometheus.GaugeValue, float64(n), channelID)
+	}
+	ch <- prometheus.MustNewConstMetric(inboundQueueDesc, prometheus.GaugeValue, float64(len(h.Inbound)))
+}
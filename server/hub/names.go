@@ -0,0 +1,73 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/models"
+)
+
+// maxDisplayNameLength bounds a display name in characters
+const maxDisplayNameLength = 32
+
+// checkDisplayName describes what is wrong with a display name, if anything
+func checkDisplayName(name string) string {
+	if utf8.RuneCountInString(name) > maxDisplayNameLength || strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Sprintf("Display names are at most %d characters, without control characters", maxDisplayNameLength)
+	}
+	return ""
+}
+
+// setDisplayName handles a set_display_name request. An empty name goes
+// back to showing the username.
+func (h *Hub) setDisplayName(client *models.Client, event models.Event) {
+	if client.Bot {
+		h.send(client, errorEvent("forbidden", "Bots cannot change their display name"))
+		return
+	}
+	name, _ := event.Data["display_name"].(string)
+	name = strings.TrimSpace(name)
+	if problem := checkDisplayName(name); problem != "" {
+		h.send(client, errorEvent("invalid_name", problem))
+		return
+	}
+	h.renameUser(client, name)
+}
+
+// renameUser sets the display name of client's user. Each of their
+// sessions is told with a user_renamed event without a channel, and every
+// channel any of them is in with one naming the channel.
+func (h *Hub) renameUser(client *models.Client, name string) {
+	profile := h.userProfile(client.Username)
+	old := profile.DisplayName
+	profile.DisplayName = name
+	h.dropEmptyProfile(client.Username)
+
+	shownOld, shownNew := old, name
+	if shownOld == "" {
+		shownOld = client.Username
+	}
+	if shownNew == "" {
+		shownNew = client.Username
+	}
+	renamed := models.Event{
+		Type:      "user_renamed",
+		From:      client.Username,
+		Content:   shownOld + " is now known as " + shownNew,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"username":         client.Username,
+			"display_name":     name,
+			"old_display_name": old,
+		},
+	}
+	if old != name {
+		h.markChanged()
+		logging.ForClient(client).Info("Display name changed", "display_name", name)
+	}
+	h.notifyUser(client.Username, renamed, old != name)
+}
@@ -0,0 +1,251 @@
+package hub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/models"
+)
+
+const (
+	// maxPronounsLength bounds pronouns in characters
+	maxPronounsLength = 32
+
+	// maxStatusEmojiLength bounds a status emoji in characters, leaving room
+	// for modifiers and joined sequences
+	maxStatusEmojiLength = 8
+
+	// maxStatusTextLength bounds a status in characters
+	maxStatusTextLength = 100
+)
+
+// profileFields are the fields update_profile accepts, in the order they are
+// checked
+var profileFields = []string{"display_name", "pronouns", "status_emoji", "status_text", "timezone", "color"}
+
+// userProfile returns the profile of username, creating an empty one if
+// they have none
+func (h *Hub) userProfile(username string) *models.User {
+	key := models.BanKey(username)
+	user := h.profiles[key]
+	if user == nil {
+		user = &models.User{Username: username}
+		h.profiles[key] = user
+	}
+	return user
+}
+
+// dropEmptyProfile forgets the profile of username if nothing is set in it
+func (h *Hub) dropEmptyProfile(username string) {
+	key := models.BanKey(username)
+	if user := h.profiles[key]; user != nil && user.Profile == (models.Profile{}) {
+		delete(h.profiles, key)
+	}
+}
+
+// displayName returns the display name username chose, or "" if none
+func (h *Hub) displayName(username string) string {
+	if user := h.profiles[models.BanKey(username)]; user != nil {
+		return user.DisplayName
+	}
+	return ""
+}
+
+// savedProfiles returns the profiles to persist, sorted by username
+func (h *Hub) savedProfiles() []models.User {
+	profiles := []models.User{}
+	for _, user := range h.profiles {
+		profiles = append(profiles, *user)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Username < profiles[j].Username })
+	return profiles
+}
+
+// getProfile answers a get_profile request with a profile event for the user
+// named in the event's data, or the caller if none is named. Users without
+// a profile get an empty one.
+func (h *Hub) getProfile(client *models.Client, event models.Event) {
+	username, _ := event.Data["username"].(string)
+	username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+	if username == "" {
+		username = client.Username
+	}
+
+	var profile models.Profile
+	if user := h.profiles[models.BanKey(username)]; user != nil {
+		profile = user.Profile
+	}
+	online, bot := false, false
+	for c := range h.clients {
+		if strings.EqualFold(c.Username, username) {
+			username = c.Username
+			online = true
+			bot = bot || c.Bot
+		}
+	}
+
+	h.send(client, models.Event{
+		Type:      "profile",
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"username": username,
+			"profile":  profile,
+			"online":   online,
+			"bot":      bot,
+		},
+	})
+}
+
+// updateProfile handles an update_profile request
+func (h *Hub) updateProfile(client *models.Client, event models.Event) {
+	if field, code, problem := h.changeProfile(client, event.Data); problem != "" {
+		rejected := errorEvent(code, problem)
+		if field != "" {
+			rejected.Data["field"] = field
+		}
+		h.send(client, rejected)
+	}
+}
+
+// changeProfile changes the fields of client's profile that are present in
+// data, clearing empty ones. A new display name is announced with
+// user_renamed, anything else with profile_updated. If a field is refused
+// nothing is changed and the field, an error code and the problem are
+// returned.
+func (h *Hub) changeProfile(client *models.Client, data map[string]interface{}) (field, code, problem string) {
+	current := models.Profile{}
+	if user := h.profiles[models.BanKey(client.Username)]; user != nil {
+		current = user.Profile
+	}
+	updated := current
+	fields := map[string]*string{
+		"display_name": &updated.DisplayName,
+		"pronouns":     &updated.Pronouns,
+		"status_emoji": &updated.StatusEmoji,
+		"status_text":  &updated.StatusText,
+		"timezone":     &updated.Timezone,
+		"color":        &updated.Color,
+	}
+
+	given := 0
+	for _, field := range profileFields {
+		raw, ok := data[field]
+		if !ok {
+			continue
+		}
+		value, ok := raw.(string)
+		if !ok {
+			return field, "invalid_profile", field + " must be a string"
+		}
+		value = strings.TrimSpace(value)
+		if field == "display_name" && client.Bot && value != current.DisplayName {
+			return field, "forbidden", "Bots cannot change their display name"
+		}
+		if field == "color" {
+			value = strings.ToLower(value)
+		}
+		if problem := checkProfileField(field, value); problem != "" {
+			return field, "invalid_profile", problem
+		}
+		*fields[field] = value
+		given++
+	}
+	if given == 0 {
+		return "", "invalid_profile", "Give at least one of " + strings.Join(profileFields, ", ")
+	}
+
+	if updated.DisplayName != current.DisplayName {
+		h.renameUser(client, updated.DisplayName)
+	}
+	profile := h.userProfile(client.Username)
+	profile.Profile = updated
+	h.dropEmptyProfile(client.Username)
+
+	changed := updated != current
+	if changed {
+		h.markChanged()
+		logging.ForClient(client).Info("Profile updated")
+	}
+	h.notifyUser(client.Username, models.Event{
+		Type:      "profile_updated",
+		From:      client.Username,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"username": client.Username,
+			"profile":  updated,
+		},
+	}, changed)
+	return "", "", ""
+}
+
+// checkProfileField describes what is wrong with a profile field's value, if
+// anything. Empty values clear a field and are always allowed.
+func checkProfileField(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.ContainsFunc(value, unicode.IsControl) {
+		return field + " cannot contain control characters"
+	}
+	length := utf8.RuneCountInString(value)
+	switch field {
+	case "display_name":
+		return checkDisplayName(value)
+	case "pronouns":
+		if length > maxPronounsLength {
+			return fmt.Sprintf("Pronouns are limited to %d characters", maxPronounsLength)
+		}
+	case "status_emoji":
+		if length > maxStatusEmojiLength {
+			return "The status emoji should be a single emoji"
+		}
+	case "status_text":
+		if length > maxStatusTextLength {
+			return fmt.Sprintf("Statuses are limited to %d characters", maxStatusTextLength)
+		}
+	case "timezone":
+		if value == "Local" {
+			return "Timezones are IANA names such as Europe/Berlin"
+		}
+		if _, err := time.LoadLocation(value); err != nil {
+			return "Unknown timezone " + value + "; use an IANA name such as Europe/Berlin"
+		}
+	case "color":
+		if len(value) != 7 || value[0] != '#' {
+			return "Colors are written #rrggbb"
+		}
+		if _, err := hex.DecodeString(value[1:]); err != nil {
+			return "Colors are written #rrggbb"
+		}
+	}
+	return ""
+}
+
+// notifyUser sends event without a channel to every session of username
+// and, if broadcast is set, to every channel any of those sessions is in
+func (h *Hub) notifyUser(username string, event models.Event, broadcast bool) {
+	key := models.BanKey(username)
+	channels := make(map[string]bool)
+	for c, joined := range h.clients {
+		if models.BanKey(c.Username) != key {
+			continue
+		}
+		h.send(c, event)
+		for channelID := range joined {
+			channels[channelID] = true
+		}
+	}
+	if !broadcast {
+		return
+	}
+	for channelID := range channels {
+		event.Channel = channelID
+		h.broadcastToChannel(event)
+	}
+}
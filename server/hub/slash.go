@@ -0,0 +1,406 @@
+package hub
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/models"
+)
+
+// shrug is appended by /shrug
+const shrug = `¯\_(ツ)_/¯`
+
+// Builtin is a slash command the server runs itself. Built-in names cannot
+// be registered by bots.
+type Builtin struct {
+	Name        string
+	Description string
+	Usage       string
+
+	// Member requires the caller to have joined the channel the command
+	// was sent to
+	Member bool
+
+	// People restricts the command to people's sessions
+	People bool
+
+	// Run handles an invocation on the hub goroutine
+	Run func(inv *Invocation)
+}
+
+// Invocation is a built-in command sent by a client
+type Invocation struct {
+	Client *models.Client
+
+	// Event is the send_message that invoked the command
+	Event models.Event
+
+	// Name is the command without its slash and Args the rest of the line
+	Name string
+	Args string
+
+	hub    *Hub
+	failed bool
+}
+
+// HandleCommand adds a built-in slash command, replacing any with the same
+// name. It must be called before Run.
+func (h *Hub) HandleCommand(b Builtin) {
+	h.builtins[b.Name] = &b
+}
+
+// Reply sends a notice only the caller sees
+func (inv *Invocation) Reply(content string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["command"] = inv.Name
+	inv.hub.send(inv.Client, models.Event{
+		Type:      "notice",
+		Channel:   inv.Event.Channel,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
+// Fail rejects the invocation with an error only the caller sees
+func (inv *Invocation) Fail(code, content string) {
+	inv.failed = true
+	inv.hub.send(inv.Client, slashError(inv.Event, inv.Name, code, content))
+}
+
+// Post sends content to the channel as a message from the caller, keeping
+// the invoking message's ID
+func (inv *Invocation) Post(content string, data map[string]interface{}) {
+	event := inv.Event
+	event.Content = content
+	event.Data = make(map[string]interface{})
+	for k, v := range inv.Event.Data {
+		event.Data[k] = v
+	}
+	for k, v := range data {
+		event.Data[k] = v
+	}
+	inv.hub.postMessage(inv.Client, event)
+}
+
+// Announce sends a system message to everyone in a channel
+func (inv *Invocation) Announce(channelID, content string, data map[string]interface{}) {
+	inv.hub.broadcastToChannel(models.Event{
+		Type:      "system_message",
+		Channel:   channelID,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
+// slashError builds an error for a slash command, carrying the message ID so
+// clients waiting for an ack can match it
+func slashError(event models.Event, name, code, content string) models.Event {
+	rejected := errorEvent(code, content)
+	rejected.Data["id"] = event.ID
+	rejected.Data["command"] = name
+	return rejected
+}
+
+// runCommand handles a message starting with a slash. line is the message
+// without the slash. It reports whether the command was accepted.
+func (h *Hub) runCommand(client *models.Client, event models.Event, line string) bool {
+	name, args, _ := strings.Cut(line, " ")
+	name = strings.ToLower(name)
+	args = strings.TrimSpace(args)
+
+	if b := h.builtins[name]; b != nil {
+		inv := &Invocation{Client: client, Event: event, Name: name, Args: args, hub: h}
+		switch {
+		case b.People && client.Bot:
+			inv.Fail("forbidden", "Bots cannot use /"+name)
+		case b.Member && !h.clients[client][event.Channel]:
+			inv.Fail("not_joined", "Join #"+event.Channel+" to use /"+name+" there")
+		default:
+			b.Run(inv)
+		}
+		return !inv.failed
+	}
+
+	if cmd := h.commands[name]; cmd != nil {
+		if !h.clients[client][event.Channel] {
+			h.send(client, slashError(event, name, "not_joined", "Join #"+event.Channel+" to use /"+name+" there"))
+			return false
+		}
+		h.routeCommand(cmd, client, event, args)
+		return true
+	}
+
+	h.send(client, slashError(event, name, "unknown_command", "Unknown command /"+name+"; /help lists commands"))
+	return false
+}
+
+// postMessage sends a chat message from a client to its channel, with the
+// display name and name color from the sender's profile
+func (h *Hub) postMessage(client *models.Client, event models.Event) {
+	if p := h.profiles[models.BanKey(client.Username)]; p != nil && (p.DisplayName != "" || p.Color != "") {
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		if p.DisplayName != "" {
+			event.Data["display_name"] = p.DisplayName
+		}
+		if p.Color != "" {
+			event.Data["color"] = p.Color
+		}
+	}
+	metrics.MessagesIn.WithLabelValues(h.metricLabel(event.Channel)).Inc()
+	h.broadcastToChannel(event)
+}
+
+// builtinCommands returns the slash commands every hub starts with
+func (h *Hub) builtinCommands() []Builtin {
+	return []Builtin{
+		{Name: "help", Description: "List slash commands", Usage: "/help", Run: h.helpCommand},
+		{Name: "me", Description: "Describe an action", Usage: "/me <action>", Member: true, Run: meCommand},
+		{Name: "shrug", Description: "Append " + shrug + " to a message", Usage: "/shrug [message]", Member: true, Run: shrugCommand},
+		{Name: "topic", Description: "Show or set the channel topic; /topic - clears it", Usage: "/topic [topic]", Member: true, Run: h.topicCommand},
+		{Name: "nick", Description: "Set or clear your display name", Usage: "/nick [name]", People: true, Run: h.nickCommand},
+		{Name: "profile", Description: "Show your profile or set a field of it; - clears the field", Usage: "/profile [field value]", People: true, Run: h.profileCommand},
+		{Name: "join", Description: "Join a channel", Usage: "/join <#channel>", Run: h.joinCommand},
+		{Name: "leave", Description: "Leave a channel", Usage: "/leave [#channel]", Run: h.leaveCommand},
+		{Name: "invite", Description: "Invite a user to a channel", Usage: "/invite <user> [#channel]", Run: h.inviteCommand},
+		{Name: "who", Description: "List who is in a channel", Usage: "/who [#channel]", Run: h.whoCommand},
+	}
+}
+
+// usage rejects an invocation with the command's usage
+func (h *Hub) usage(inv *Invocation) {
+	inv.Fail("usage", "Usage: "+h.builtins[inv.Name].Usage)
+}
+
+func (h *Hub) helpCommand(inv *Invocation) {
+	var lines []string
+	for _, cmd := range h.listCommands() {
+		line := cmd.Usage
+		if line == "" {
+			line = "/" + cmd.Name
+		}
+		if cmd.Description != "" {
+			line += " — " + cmd.Description
+		}
+		lines = append(lines, line)
+	}
+	inv.Reply(strings.Join(lines, "\n"), nil)
+}
+
+func meCommand(inv *Invocation) {
+	if inv.Args == "" {
+		inv.hub.usage(inv)
+		return
+	}
+	inv.Post(inv.Args, map[string]interface{}{"action": true})
+}
+
+func shrugCommand(inv *Invocation) {
+	inv.Post(strings.TrimSpace(inv.Args+" "+shrug), nil)
+}
+
+func (h *Hub) topicCommand(inv *Invocation) {
+	ch := h.findChannel(inv.Event.Channel)
+	if ch == nil {
+		inv.Fail("not_found", "#"+inv.Event.Channel+" is not a listed channel")
+		return
+	}
+	if inv.Args == "" {
+		if ch.Topic == "" {
+			inv.Reply("#"+ch.ID+" has no topic", nil)
+		} else {
+			inv.Reply("Topic of #"+ch.ID+": "+ch.Topic, map[string]interface{}{"topic": ch.Topic})
+		}
+		return
+	}
+	topic := inv.Args
+	if topic == "-" {
+		topic = ""
+	}
+	if code, problem := checkTopic(topic, ch.Description); problem != "" {
+		inv.Fail(code, problem)
+		return
+	}
+	h.changeTopic(inv.Client, ch, topic, ch.Description)
+}
+
+func (h *Hub) nickCommand(inv *Invocation) {
+	if problem := checkDisplayName(inv.Args); problem != "" {
+		inv.Fail("invalid_name", problem)
+		return
+	}
+	h.renameUser(inv.Client, inv.Args)
+
+	shown := inv.Args
+	if shown == "" {
+		shown = inv.Client.Username
+	}
+	inv.Reply("You are now known as "+shown, map[string]interface{}{"display_name": inv.Args})
+}
+
+// profileAliases are short names /profile accepts for profile fields
+var profileAliases = map[string]string{
+	"name":   "display_name",
+	"emoji":  "status_emoji",
+	"status": "status_text",
+	"tz":     "timezone",
+}
+
+func (h *Hub) profileCommand(inv *Invocation) {
+	if inv.Args == "" {
+		var profile models.Profile
+		if user := h.profiles[models.BanKey(inv.Client.Username)]; user != nil {
+			profile = user.Profile
+		}
+		lines := []string{"Your profile:"}
+		for _, field := range []struct{ name, value string }{
+			{"display_name", profile.DisplayName},
+			{"pronouns", profile.Pronouns},
+			{"status_emoji", profile.StatusEmoji},
+			{"status_text", profile.StatusText},
+			{"timezone", profile.Timezone},
+			{"color", profile.Color},
+		} {
+			value := field.value
+			if value == "" {
+				value = "-"
+			}
+			lines = append(lines, "  "+field.name+": "+value)
+		}
+		inv.Reply(strings.Join(lines, "\n"), map[string]interface{}{"profile": profile})
+		return
+	}
+
+	field, value, _ := strings.Cut(inv.Args, " ")
+	field = strings.ToLower(field)
+	if alias, ok := profileAliases[field]; ok {
+		field = alias
+	}
+	if !slices.Contains(profileFields, field) {
+		inv.Fail("usage", "Profile fields are "+strings.Join(profileFields, ", "))
+		return
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		h.usage(inv)
+		return
+	}
+	if value == "-" {
+		value = ""
+	}
+	if _, _, problem := h.changeProfile(inv.Client, map[string]interface{}{field: value}); problem != "" {
+		inv.Fail("invalid_profile", problem)
+		return
+	}
+	if value == "" {
+		inv.Reply("Cleared your "+field, nil)
+	} else {
+		inv.Reply("Set your "+field+" to "+value, nil)
+	}
+}
+
+// channelArg returns the channel named by arg, or the one the command was
+// sent to if arg is empty
+func channelArg(inv *Invocation, arg string) string {
+	if arg == "" {
+		return inv.Event.Channel
+	}
+	return strings.TrimPrefix(arg, "#")
+}
+
+func (h *Hub) joinCommand(inv *Invocation) {
+	if inv.Args == "" {
+		h.usage(inv)
+		return
+	}
+	channelID := channelArg(inv, inv.Args)
+	switch ch := h.findChannel(channelID); {
+	case ch == nil:
+		inv.Fail("not_found", "There is no #"+channelID)
+	case ch.Archived:
+		inv.Fail("channel_archived", "#"+channelID+" has been archived")
+	default:
+		h.joinChannel(inv.Client, channelID)
+		inv.Reply("Joined #"+channelID, map[string]interface{}{"joined": channelID})
+	}
+}
+
+func (h *Hub) leaveCommand(inv *Invocation) {
+	channelID := channelArg(inv, inv.Args)
+	if !h.clients[inv.Client][channelID] {
+		inv.Fail("not_joined", "You are not in #"+channelID)
+		return
+	}
+	h.leaveChannel(inv.Client, channelID)
+	inv.Reply("Left #"+channelID, map[string]interface{}{"left": channelID})
+}
+
+func (h *Hub) inviteCommand(inv *Invocation) {
+	user, arg, _ := strings.Cut(inv.Args, " ")
+	if user == "" {
+		h.usage(inv)
+		return
+	}
+	user = strings.TrimPrefix(user, "@")
+	channelID := channelArg(inv, strings.TrimSpace(arg))
+	if !h.clients[inv.Client][channelID] {
+		inv.Fail("not_joined", "Join #"+channelID+" before inviting others to it")
+		return
+	}
+
+	invited := 0
+	for client := range h.clients {
+		if !strings.EqualFold(client.Username, user) {
+			continue
+		}
+		h.send(client, models.Event{
+			Type:      "notice",
+			Channel:   channelID,
+			Content:   inv.Client.Username + " invited you to #" + channelID + "; /join #" + channelID + " to accept",
+			Timestamp: time.Now().Unix(),
+			Data:      map[string]interface{}{"command": "invite", "invite": channelID, "from": inv.Client.Username},
+		})
+		invited++
+	}
+	if invited == 0 {
+		inv.Fail("not_found", user+" is not online")
+		return
+	}
+	inv.Reply("Invited "+user+" to #"+channelID, nil)
+}
+
+func (h *Hub) whoCommand(inv *Invocation) {
+	channelID := channelArg(inv, inv.Args)
+	if !h.clients[inv.Client][channelID] {
+		inv.Fail("not_joined", "Join #"+channelID+" to see who is there")
+		return
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	if ch := h.channels[channelID]; ch != nil {
+		members, _ := ch.snapshot()
+		for _, client := range members {
+			name := client.Username
+			if displayName := h.displayName(client.Username); displayName != "" {
+				name += " (" + displayName + ")"
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	inv.Reply(fmt.Sprintf("%d in #%s: %s", len(names), channelID, strings.Join(names, ", ")),
+		map[string]interface{}{"members": names})
+}
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"terminal-chat/server/logging"
+	"terminal-chat/server/models"
+)
+
+const (
+	// maxTopicLength bounds a channel topic in characters
+	maxTopicLength = 250
+
+	// maxDescriptionLength bounds a channel description in characters
+	maxDescriptionLength = 1000
+)
+
+// setTopic changes a channel's topic, description or both for a member.
+// Fields missing from the event's data are left alone.
+func (h *Hub) setTopic(client *models.Client, event models.Event) {
+	fail := func(code, content string) {
+		rejected := errorEvent(code, content)
+		rejected.Data["channel"] = event.Channel
+		h.send(client, rejected)
+	}
+
+	ch := h.findChannel(event.Channel)
+	if ch == nil {
+		fail("not_found", "#"+event.Channel+" is not a listed channel")
+		return
+	}
+	if !h.clients[client][ch.ID] {
+		fail("not_joined", "Join #"+ch.ID+" to change its topic")
+		return
+	}
+
+	topic, hasTopic := event.Data["topic"].(string)
+	description, hasDescription := event.Data["description"].(string)
+	if !hasTopic && !hasDescription {
+		fail("invalid_topic", "set_topic needs a topic or description")
+		return
+	}
+	if !hasTopic {
+		topic = ch.Topic
+	}
+	if !hasDescription {
+		description = ch.Description
+	}
+	if code, problem := checkTopic(topic, description); problem != "" {
+		fail(code, problem)
+		return
+	}
+	h.changeTopic(client, ch, topic, description)
+}
+
+// checkTopic enforces the length limits and keeps control characters out,
+// returning an error code and the problem if there is one
+func checkTopic(topic, description string) (code, problem string) {
+	if strings.ContainsFunc(topic, unicode.IsControl) || strings.ContainsFunc(description, unicode.IsControl) {
+		return "invalid_topic", "Topics and descriptions cannot contain control characters"
+	}
+	if utf8.RuneCountInString(topic) > maxTopicLength {
+		return "topic_too_long", fmt.Sprintf("Topics are limited to %d characters", maxTopicLength)
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "topic_too_long", fmt.Sprintf("Descriptions are limited to %d characters", maxDescriptionLength)
+	}
+	return "", ""
+}
+
+// changeTopic stores a channel's topic and description and announces the
+// change to the channel with a system message
+func (h *Hub) changeTopic(client *models.Client, ch *models.Channel, topic, description string) {
+	var content string
+	switch {
+	case topic != ch.Topic && topic == "":
+		content = client.Username + " cleared the topic"
+	case topic != ch.Topic:
+		content = client.Username + " set the topic to: " + topic
+	case description != ch.Description:
+		content = client.Username + " changed the channel description"
+	default:
+		return
+	}
+
+	ch.Topic, ch.Description = topic, description
+	h.markChanged()
+	logging.ForClient(client).Info("Topic changed", "channel", ch.ID)
+	h.broadcastToChannel(models.Event{
+		Type:      "system_message",
+		Channel:   ch.ID,
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"topic":       topic,
+			"description": description,
+			"by":          client.Username,
+		},
+	})
+}
@@ -0,0 +1,85 @@
+// Package logging sets up the server's structured logger. Message content
+// is redacted unless payload logging is explicitly enabled, so private
+// conversations never end up in the logs by default.
+//
+// Content is recognized by its type or its key: log it as a Payload, under
+// any key, or as a string under one of the payload keys. Content logged any
+// other way is written as is.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"terminal-chat/server/config"
+	"terminal-chat/server/models"
+)
+
+// Attribute keys that carry message content. Their values are replaced
+// unless payload logging is on. New call sites should prefer Payload, which
+// does not depend on the key.
+var payloadKeys = map[string]bool{
+	"content": true,
+	"payload": true,
+}
+
+// Attribute keys that carry credentials. Their values are always replaced.
+var secretKeys = map[string]bool{
+	"secret":   true,
+	"token":    true,
+	"password": true,
+}
+
+// Payload is message content, such as an encoded event. It is redacted
+// under any key unless payload logging is on, and is only copied into a
+// string when a record is actually written.
+type Payload []byte
+
+// New creates a logger writing to w in the configured format and level
+func New(w io.Writer, cfg config.Log) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactor(cfg.Payloads),
+	}
+
+	switch cfg.Format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+}
+
+// redactor returns a ReplaceAttr function hiding content and credentials
+func redactor(payloads bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindAny {
+			if p, ok := a.Value.Any().(Payload); ok {
+				if payloads {
+					return slog.String(a.Key, string(p))
+				}
+				return slog.String(a.Key, fmt.Sprintf("[redacted %d bytes]", len(p)))
+			}
+		}
+
+		switch {
+		case secretKeys[a.Key]:
+			return slog.String(a.Key, "[redacted]")
+		case payloadKeys[a.Key] && !payloads:
+			return slog.String(a.Key, fmt.Sprintf("[redacted %d bytes]", len(a.Value.String())))
+		}
+		return a
+	}
+}
+
+// ForClient returns a logger tagged with a client's session ID and username
+func ForClient(client *models.Client) *slog.Logger {
+	return slog.With("session", client.ID, "user", client.Username)
+}
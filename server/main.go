@@ -1,28 +1,241 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	// Profile timezones are validated against the embedded zone database,
+	// so the server does not depend on the image having one
+	_ "time/tzdata"
+
+	"terminal-chat/server/admin"
+	"terminal-chat/server/backplane"
+	"terminal-chat/server/bots"
+	"terminal-chat/server/certs"
+	"terminal-chat/server/config"
+	"terminal-chat/server/files"
+	"terminal-chat/server/health"
 	"terminal-chat/server/hub"
+	"terminal-chat/server/logging"
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/storage"
+	"terminal-chat/server/webhook"
 	"terminal-chat/server/ws"
 )
 
 func main() {
+	// Load configuration from file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// Load state saved by the previous run
+	store, err := storage.NewFileStore(cfg.StoragePath)
+	if err != nil {
+		fatal("Opening storage", err)
+	}
+	snap, err := store.Load()
+	if err != nil {
+		fatal("Loading state", err)
+	}
+
 	// Create the chat hub
 	h := hub.NewHub()
+	h.Restore(snap)
+	h.EnsureChannels(cfg.DefaultChannels)
+
+	// Deliver channel events to registered webhooks
+	webhooks, err := webhook.NewDispatcher(store)
+	if err != nil {
+		fatal("Starting webhooks", err)
+	}
+	h.AddObserver(webhooks)
+	incoming, err := webhook.NewIncoming(h, store, cfg.Limits.MaxContentLength)
+	if err != nil {
+		fatal("Loading incoming webhooks", err)
+	}
+
+	// Bot accounts, which connect with API tokens
+	registry, err := bots.NewRegistry(store)
+	if err != nil {
+		fatal("Loading bots", err)
+	}
+
+	// Files uploaded by clients, kept under the storage directory
+	fileStore, err := files.NewStore(filepath.Join(cfg.StoragePath, "files"), store, cfg.Limits.MaxFileSize)
+	if err != nil {
+		fatal("Opening file storage", err)
+	}
+	h.SetFiles(fileStore)
+
+	// Join the other nodes, if clustered
+	var mesh *backplane.Mesh
+	if cfg.Cluster.Enabled() {
+		mesh, err = backplane.NewMesh(cfg.Cluster.NodeID, cfg.Cluster.Listen, cfg.Cluster.Peers, cfg.Cluster.Secret)
+		if err != nil {
+			fatal("Starting backplane", err)
+		}
+		h.SetBackplane(mesh)
+	}
 
 	// Start the hub in a goroutine
 	go h.Run()
 
+	// Save state while running, so a crash loses little of it
+	persistCtx, stopPersist := context.WithCancel(context.Background())
+	persisted := make(chan struct{})
+	go func() {
+		defer close(persisted)
+		persistState(persistCtx, h, store, cfg.SaveInterval)
+	}()
+
 	// Set up WebSocket endpoint
-	http.HandleFunc("/ws", ws.HandleWebSocket(h))
+	http.HandleFunc("/ws", ws.HandleWebSocket(h, cfg, registry, fileStore))
+
+	// Downloads of uploaded files
+	http.Handle("/files/", fileStore.Handler())
+
+	// Expose Prometheus metrics
+	metrics.Register(h)
+	http.Handle("/metrics", metrics.Handler())
+
+	// Probes for orchestrators, and the admin endpoints
+	checker := health.NewChecker(h, store)
+	http.HandleFunc("/healthz", checker.Healthz)
+	http.HandleFunc("/readyz", checker.Readyz)
+	tokens, err := admin.NewTokens(cfg.Admin.Token, filepath.Join(cfg.StoragePath, "admin_token.sha256"))
+	if err != nil {
+		fatal("Loading admin token", err)
+	}
+	http.Handle("/debug/hub", admin.RequireToken(tokens, admin.DebugHub(h)))
+	http.Handle("/admin/", admin.RequireToken(tokens, admin.NewAPI(h, store, tokens, webhooks, incoming, registry)))
+
+	// Let other tools post into channels
+	http.Handle("/hooks/", incoming.Handler())
 
 	// Serve static files (for development)
-	fs := http.FileServer(http.Dir("./static"))
+	fs := http.FileServer(http.Dir(cfg.StaticDir))
 	http.Handle("/", fs)
 
-	log.Println("Chat server starting on :8080")
-	log.Println("WebSocket endpoint: ws://localhost:8080/ws?username=yourname")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	server := &http.Server{Addr: cfg.Listen}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(server, cfg)
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("Serving HTTP", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Let clients drain while HTTP stays up, so /readyz reports the node as
+	// draining and new WebSocket handshakes are turned away, then stop
+	// serving and save state
+	stopPersist()
+	<-persisted
+	state := h.Shutdown(shutdownCtx, "server restarting")
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP shutdown", "err", err)
+	}
+	webhooks.Close()
+	if err := store.Save(state); err != nil {
+		fatal("Saving state", err)
+	}
+	if mesh != nil {
+		mesh.Close()
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs an error that prevents the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// persistState saves the hub's state soon after its channels, bans, topics
+// or profiles change, and every interval for the message history, until ctx
+// is done
+func persistState(ctx context.Context, h *hub.Hub, store *storage.FileStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.Changed():
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := store.Save(h.Snapshot()); err != nil {
+			slog.Error("Saving state", "err", err)
+		}
+	}
+}
+
+// serve runs the HTTP server until it fails or is shut down
+func serve(server *http.Server, cfg *config.Config) error {
+	var err error
+	if !cfg.TLS.Enabled() {
+		slog.Info("Chat server starting", "listen", cfg.Listen, "endpoint", "ws://"+cfg.Listen+"/ws?username=yourname")
+		err = server.ListenAndServe()
+	} else {
+		err = serveTLS(server, cfg)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveTLS runs the HTTP server with the configured or self-signed certificate
+func serveTLS(server *http.Server, cfg *config.Config) error {
+	certFile, keyFile := cfg.TLS.CertFile, cfg.TLS.KeyFile
+	if cfg.TLS.SelfSigned {
+		var err error
+		certFile, keyFile, err = certs.EnsureSelfSigned(filepath.Join(cfg.StoragePath, "tls"), cfg.TLS.Hosts)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Certificates are re-read from disk when they change, so renewals need no restart
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	slog.Info("Chat server starting with TLS", "listen", cfg.Listen, "endpoint", "wss://"+cfg.Listen+"/ws?username=yourname",
+		"fingerprint", reloader.Fingerprint())
+	return server.ListenAndServeTLS("", "")
 }
@@ -0,0 +1,109 @@
+// Package metrics defines the Prometheus metrics exported on /metrics.
+// Gauges describing the hub's current state are collected from the hub
+// itself at scrape time; this package holds the counters and histograms
+// that are updated as events happen.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chat"
+
+var (
+	// MessagesIn counts chat messages received from local clients per channel
+	MessagesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_in_total",
+		Help:      "Chat messages received from clients, by channel.",
+	}, []string{"channel"})
+
+	// MessagesOut counts events queued for subscribers per channel
+	MessagesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_out_total",
+		Help:      "Events queued for channel subscribers, by channel.",
+	}, []string{"channel"})
+
+	// DroppedMessages counts messages that never reached a client, either
+	// discarded by the slow-consumer policy or rejected by a full outbox
+	DroppedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_messages_total",
+		Help:      "Messages not delivered because a client's send buffer was full, by reason.",
+	}, []string{"reason"})
+
+	// Evictions counts sessions disconnected for being too slow
+	Evictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slow_consumer_evictions_total",
+		Help:      "Sessions disconnected because their send buffer overflowed.",
+	})
+
+	// HubLoopDuration measures how long the hub spends on each operation
+	HubLoopDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "hub_loop_duration_seconds",
+		Help:      "Time the hub goroutine spends handling one operation, by operation.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5},
+	}, []string{"op"})
+
+	// WriteErrors counts failed writes to client connections
+	WriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "write_errors_total",
+		Help:      "Errors writing to client WebSocket connections.",
+	})
+
+	// HandshakeFailures counts rejected or failed WebSocket handshakes
+	HandshakeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handshake_failures_total",
+		Help:      "WebSocket handshakes that were rejected or failed, by reason.",
+	}, []string{"reason"})
+
+	// WebhookDeliveries counts outgoing webhook delivery attempts
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Outgoing webhook delivery attempts, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		MessagesIn,
+		MessagesOut,
+		DroppedMessages,
+		Evictions,
+		HubLoopDuration,
+		WriteErrors,
+		HandshakeFailures,
+		WebhookDeliveries,
+	)
+
+	// Start the counters alerts are written against at zero so they exist
+	// before the first failure
+	for _, reason := range []string{"policy", "overflow"} {
+		DroppedMessages.WithLabelValues(reason)
+	}
+	for _, reason := range []string{"rate_limited", "draining", "subprotocol", "banned", "unauthorized", "origin", "upgrade"} {
+		HandshakeFailures.WithLabelValues(reason)
+	}
+	for _, result := range []string{"delivered", "retried", "failed"} {
+		WebhookDeliveries.WithLabelValues(result)
+	}
+}
+
+// Register adds collectors, such as the hub, to the exported metrics
+func Register(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(collectors...)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -1,6 +1,32 @@
 package models
 
-import "time"
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"terminal-chat/server/outbox"
+)
+
+// File is an uploaded attachment. Messages reference files by ID in
+// data.files, and the server replaces the IDs with the files' metadata.
+type File struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	MIME string `json:"mime"`
+
+	// SHA256 is the hex digest of the contents, which are stored once
+	// however often they are uploaded
+	SHA256 string `json:"sha256"`
+
+	// Channel is where the file may be posted and listed
+	Channel   string    `json:"channel"`
+	Uploader  string    `json:"uploader"`
+	CreatedAt time.Time `json:"created_at"`
+}
 
 // Message represents a chat message
 type Message struct {
@@ -15,25 +41,136 @@ type Message struct {
 type Channel struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+
+	// Topic is a one-line summary shown in the channel header, and
+	// Description a longer explanation; members change both with set_topic
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Archived channels keep their history but can no longer be joined
+	Archived  bool      `json:"archived,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var channelIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidChannelID reports whether id is lowercase letters, digits, - or _,
+// starting with a letter or digit, and at most 32 characters
+func ValidChannelID(id string) bool {
+	return channelIDPattern.MatchString(id)
+}
+
+// Ban keeps a username from connecting
+type Ban struct {
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is zero for a permanent ban
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Active reports whether the ban is still in force at now
+func (b Ban) Active(now time.Time) bool {
+	return b.ExpiresAt.IsZero() || now.Before(b.ExpiresAt)
+}
+
+// BanKey normalizes a username for ban lookups
+func BanKey(username string) string {
+	return strings.ToLower(username)
 }
 
 // User represents a chat user
 type User struct {
-	ID       string `json:"id"`
+	ID       string `json:"id,omitempty"`
 	Username string `json:"username"`
+	Profile
+}
+
+// Profile is what a user chooses to tell others about themselves. Every
+// field is optional.
+type Profile struct {
+	// DisplayName is shown instead of the username
+	DisplayName string `json:"display_name,omitempty"`
+	Pronouns    string `json:"pronouns,omitempty"`
+	StatusEmoji string `json:"status_emoji,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+
+	// Timezone is an IANA name such as Europe/Berlin
+	Timezone string `json:"timezone,omitempty"`
+
+	// Color is the preferred color of the user's name, as #rrggbb
+	Color string `json:"color,omitempty"`
 }
 
+// Close codes sent to clients when the server ends their session
+const (
+	CloseServerRestart = 1012
+	CloseSlowConsumer  = 1013
+
+	// Application codes: the session was ended by an administrator
+	CloseKicked = 4000
+	CloseBanned = 4003
+)
+
 // Client represents a connected WebSocket client
 type Client struct {
 	ID       string
 	UserID   string
 	Username string
 	Conn     interface{} // WebSocket connection
-	Send     chan []byte
+	Send     *outbox.Outbox
+
+	// RemoteAddr and ConnectedAt describe the connection for admins
+	RemoteAddr  string
+	ConnectedAt time.Time
+
+	// Bot is set for sessions authenticated with a bot token
+	Bot bool
+
+	// events holds the event types a bot subscribed to; nil delivers
+	// everything. It is read by channel actors, so it is swapped atomically.
+	events atomic.Pointer[map[string]bool]
+
+	// Closed is closed by the writer after the close frame has been sent
+	Closed chan struct{}
+}
+
+// SubscribableEvents are the event types a bot receives only after
+// subscribing. Anything else, such as errors and commands, is always sent.
+var SubscribableEvents = []string{
+	"send_message", "system_message", "user_renamed", "profile_updated", "typing_start", "typing_stop", "user_joined", "user_left",
+	"announcement", "channels_updated", "channel_archived",
+}
+
+// Subscribe limits the subscribable events sent to the client to types
+func (c *Client) Subscribe(types []string) {
+	events := make(map[string]bool, len(types))
+	for _, t := range types {
+		events[t] = true
+	}
+	c.events.Store(&events)
+}
+
+// Wants reports whether an event of type t should be sent to the client.
+// History replays follow the send_message subscription.
+func (c *Client) Wants(t string) bool {
+	events := c.events.Load()
+	if events == nil {
+		return true
+	}
+	if t == "history" {
+		t = "send_message"
+	}
+	if !slices.Contains(SubscribableEvents, t) {
+		return true
+	}
+	return (*events)[t]
 }
 
 // Event represents messages exchanged between client and server
 type Event struct {
+	ID        string                 `json:"id,omitempty"`
 	Type      string                 `json:"type"`
 	Channel   string                 `json:"channel,omitempty"`
 	From      string                 `json:"from,omitempty"`
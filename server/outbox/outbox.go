@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Policy decides what happens when a message is pushed to a full outbox
+type Policy string
+
+const (
+	// Disconnect rejects the message; the session is expected to be evicted
+	Disconnect Policy = "disconnect"
+
+	// DropOldest discards the oldest queued message to make room
+	DropOldest Policy = "drop-oldest"
+
+	// DropTyping discards the oldest expendable message (typing events) to
+	// make room, and rejects the message if there is none
+	DropTyping Policy = "drop-typing"
+
+	// Coalesce collapses queued messages sharing a key down to the newest
+	// one, and rejects the message if that frees no room
+	Coalesce Policy = "coalesce"
+)
+
+// ParsePolicy validates a policy name
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case Disconnect, DropOldest, DropTyping, Coalesce:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q (want disconnect, drop-oldest, drop-typing or coalesce)", name)
+}
+
+var (
+	// ErrOverflow means the outbox is full and the policy could not make room
+	ErrOverflow = errors.New("outbox full")
+
+	// ErrClosed means the outbox no longer accepts messages
+	ErrClosed = errors.New("outbox closed")
+)
+
+// Message is an encoded event queued for a session
+type Message struct {
+	Data []byte
+
+	// Key identifies messages that supersede each other under Coalesce;
+	// empty means the message is never coalesced
+	Key string
+
+	// Expendable messages are dropped first under DropTyping
+	Expendable bool
+}
+
+// Batch is everything a writer should send since its last drain
+type Batch struct {
+	Messages [][]byte
+
+	// Dropped counts messages discarded by the policy since the last drain
+	Dropped int
+
+	// Closed is set once the outbox is closed and fully drained, with the
+	// close code and reason to send
+	Closed bool
+	Code   int
+	Reason string
+}
+
+// Stats describes an outbox for metrics
+type Stats struct {
+	Depth     int    `json:"depth"`
+	HighWater int    `json:"high_water"`
+	Capacity  int    `json:"capacity"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// Outbox is a bounded per-session queue of outgoing messages. Any number of
+// goroutines may push; a single writer drains it when Ready fires.
+type Outbox struct {
+	capacity int
+	policy   Policy
+	ready    chan struct{}
+
+	mu           sync.Mutex
+	messages     []Message
+	closed       bool
+	code         int
+	reason       string
+	dropped      int
+	totalDropped uint64
+	highWater    int
+}
+
+// New creates an outbox holding up to capacity messages
+func New(capacity int, policy Policy) *Outbox {
+	return &Outbox{
+		capacity: capacity,
+		policy:   policy,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Ready fires when there are messages to drain or the outbox was closed
+func (o *Outbox) Ready() <-chan struct{} {
+	return o.ready
+}
+
+// signal wakes the writer without blocking
+func (o *Outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Push queues a message, applying the policy if the outbox is full
+func (o *Outbox) Push(msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return ErrClosed
+	}
+	if len(o.messages) >= o.capacity && !o.makeRoom() {
+		return ErrOverflow
+	}
+
+	o.append(msg)
+	return nil
+}
+
+// Notify queues a control message regardless of capacity, such as a
+// notice sent just before closing
+func (o *Outbox) Notify(data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return ErrClosed
+	}
+	o.append(Message{Data: data})
+	return nil
+}
+
+// append adds a message and wakes the writer. The caller must hold o.mu.
+func (o *Outbox) append(msg Message) {
+	o.messages = append(o.messages, msg)
+	if len(o.messages) > o.highWater {
+		o.highWater = len(o.messages)
+	}
+	o.signal()
+}
+
+// makeRoom frees at least one slot according to the policy. The caller
+// must hold o.mu.
+func (o *Outbox) makeRoom() bool {
+	switch o.policy {
+	case DropOldest:
+		o.discard(0)
+		return true
+
+	case DropTyping:
+		for i, msg := range o.messages {
+			if msg.Expendable {
+				o.discard(i)
+				return true
+			}
+		}
+
+	case Coalesce:
+		// Keep only the newest message for each key
+		latest := make(map[string]int)
+		for i, msg := range o.messages {
+			if msg.Key != "" {
+				latest[msg.Key] = i
+			}
+		}
+		kept := o.messages[:0]
+		for i, msg := range o.messages {
+			if msg.Key == "" || latest[msg.Key] == i {
+				kept = append(kept, msg)
+			} else {
+				o.dropped++
+				o.totalDropped++
+			}
+		}
+		o.messages = kept
+		return len(o.messages) < o.capacity
+	}
+	return false
+}
+
+// discard removes the message at i. The caller must hold o.mu.
+func (o *Outbox) discard(i int) {
+	o.messages = append(o.messages[:i], o.messages[i+1:]...)
+	o.dropped++
+	o.totalDropped++
+}
+
+// Close stops accepting messages. Messages already queued are still
+// delivered, then the writer sees Closed with code and reason. A zero code
+// means a normal close.
+func (o *Outbox) Close(code int, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return
+	}
+	o.closed = true
+	o.code = code
+	o.reason = reason
+	o.signal()
+}
+
+// Drain takes everything queued
+func (o *Outbox) Drain() Batch {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batch := Batch{
+		Dropped: o.dropped,
+		Closed:  o.closed,
+		Code:    o.code,
+		Reason:  o.reason,
+	}
+	for _, msg := range o.messages {
+		batch.Messages = append(batch.Messages, msg.Data)
+	}
+	o.messages = nil
+	o.dropped = 0
+	return batch
+}
+
+// Stats reports the current depth, high-water mark and drop count
+func (o *Outbox) Stats() Stats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return Stats{
+		Depth:     len(o.messages),
+		HighWater: o.highWater,
+		Capacity:  o.capacity,
+		Dropped:   o.totalDropped,
+	}
+}
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// policyCase pushes messages into an outbox of capacity and expects the
+// messages kept, the pushes rejected and the drop count
+type policyCase struct {
+	name     string
+	capacity int
+	push     []Message
+	kept     []string
+	rejected []string
+	dropped  int
+}
+
+// msg is a plain message
+func msg(data string) Message { return Message{Data: []byte(data)} }
+
+// typing is an expendable typing event from user, coalesced per user
+func typing(data, user string) Message {
+	return Message{Data: []byte(data), Key: "typing:" + user, Expendable: true}
+}
+
+func runPolicy(t *testing.T, policy Policy, cases []policyCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o := New(c.capacity, policy)
+			var rejected []string
+			for _, m := range c.push {
+				switch err := o.Push(m); {
+				case errors.Is(err, ErrOverflow):
+					rejected = append(rejected, string(m.Data))
+				case err != nil:
+					t.Fatalf("pushing %s: %v", m.Data, err)
+				}
+			}
+
+			if stats := o.Stats(); stats.Depth > c.capacity || stats.Dropped != uint64(c.dropped) {
+				t.Errorf("stats = %+v, want depth at most %d and %d dropped", stats, c.capacity, c.dropped)
+			}
+			batch := o.Drain()
+			var kept []string
+			for _, data := range batch.Messages {
+				kept = append(kept, string(data))
+			}
+			if !slices.Equal(kept, c.kept) {
+				t.Errorf("kept %s, want %s", strings.Join(kept, " "), strings.Join(c.kept, " "))
+			}
+			if !slices.Equal(rejected, c.rejected) {
+				t.Errorf("rejected %s, want %s", strings.Join(rejected, " "), strings.Join(c.rejected, " "))
+			}
+			if batch.Dropped != c.dropped {
+				t.Errorf("batch dropped %d, want %d", batch.Dropped, c.dropped)
+			}
+		})
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	runPolicy(t, Disconnect, []policyCase{{
+		name:     "room to spare",
+		capacity: 3,
+		push:     []Message{msg("a"), msg("b")},
+		kept:     []string{"a", "b"},
+	}, {
+		name:     "full",
+		capacity: 2,
+		push:     []Message{msg("a"), msg("b"), msg("c"), typing("t1", "bob")},
+		kept:     []string{"a", "b"},
+		rejected: []string{"c", "t1"},
+	}, {
+		name:     "typing is not dropped",
+		capacity: 2,
+		push:     []Message{typing("t1", "bob"), typing("t2", "bob"), msg("a")},
+		kept:     []string{"t1", "t2"},
+		rejected: []string{"a"},
+	}})
+}
+
+func TestDropOldest(t *testing.T) {
+	runPolicy(t, DropOldest, []policyCase{{
+		name:     "room to spare",
+		capacity: 3,
+		push:     []Message{msg("a"), msg("b")},
+		kept:     []string{"a", "b"},
+	}, {
+		name:     "full",
+		capacity: 2,
+		push:     []Message{msg("a"), msg("b"), msg("c"), msg("d")},
+		kept:     []string{"c", "d"},
+		dropped:  2,
+	}, {
+		name:     "oldest regardless of kind",
+		capacity: 2,
+		push:     []Message{msg("a"), typing("t1", "bob"), msg("b")},
+		kept:     []string{"t1", "b"},
+		dropped:  1,
+	}})
+}
+
+func TestDropTyping(t *testing.T) {
+	runPolicy(t, DropTyping, []policyCase{{
+		name:     "room to spare",
+		capacity: 3,
+		push:     []Message{msg("a"), typing("t1", "bob")},
+		kept:     []string{"a", "t1"},
+	}, {
+		name:     "oldest typing event goes first",
+		capacity: 3,
+		push:     []Message{msg("a"), typing("t1", "bob"), typing("t2", "carol"), msg("b"), msg("c")},
+		kept:     []string{"a", "b", "c"},
+		dropped:  2,
+	}, {
+		name:     "nothing expendable",
+		capacity: 2,
+		push:     []Message{msg("a"), msg("b"), msg("c")},
+		kept:     []string{"a", "b"},
+		rejected: []string{"c"},
+	}})
+}
+
+func TestCoalesce(t *testing.T) {
+	runPolicy(t, Coalesce, []policyCase{{
+		name:     "room to spare",
+		capacity: 3,
+		push:     []Message{typing("t1", "bob"), typing("t2", "bob")},
+		kept:     []string{"t1", "t2"},
+	}, {
+		name:     "newest per key is kept",
+		capacity: 4,
+		push:     []Message{typing("t1", "bob"), msg("a"), typing("t2", "carol"), typing("t3", "bob"), msg("b")},
+		kept:     []string{"a", "t2", "t3", "b"},
+		dropped:  1,
+	}, {
+		name:     "still full after coalescing",
+		capacity: 3,
+		push:     []Message{typing("t1", "bob"), msg("a"), typing("t2", "carol"), msg("b")},
+		kept:     []string{"t1", "a", "t2"},
+		rejected: []string{"b"},
+	}})
+}
@@ -0,0 +1,9 @@
+// Package protocol holds what the server and its Go clients must agree on
+// about the WebSocket protocol. It imports nothing, so the SDK can share it
+// without depending on the server.
+package protocol
+
+// Subprotocol is the Sec-WebSocket-Protocol value of the event protocol.
+// The server accepts only clients that request it; it is bumped when the
+// event protocol changes incompatibly.
+const Subprotocol = "terminal-chat.v1"
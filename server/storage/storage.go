@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"terminal-chat/server/models"
+)
+
+// Snapshot is the server state persisted across restarts
+type Snapshot struct {
+	SavedAt time.Time `json:"saved_at"`
+
+	// History holds the most recent messages per channel
+	History map[string][]models.Event `json:"history"`
+
+	// Channels lists the channels offered to clients, in order
+	Channels []models.Channel `json:"channels,omitempty"`
+
+	// Bans lists the usernames that may not connect
+	Bans []models.Ban `json:"bans,omitempty"`
+
+	// Profiles lists the users who filled in a profile
+	Profiles []models.User `json:"profiles,omitempty"`
+}
+
+// FileStore keeps a snapshot as a JSON file in a directory
+type FileStore struct {
+	path string
+}
+
+// Backup describes a saved copy of the state
+type Backup struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// NewFileStore creates the storage directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating storage directory: %w", err)
+	}
+	return &FileStore{path: filepath.Join(dir, "state.json")}, nil
+}
+
+// Load reads the last saved snapshot. A missing file yields an empty snapshot.
+func (s *FileStore) Load() (*Snapshot, error) {
+	snap := &Snapshot{History: make(map[string][]models.Event)}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("parsing state %s: %w", s.path, err)
+	}
+	if snap.History == nil {
+		snap.History = make(map[string][]models.Event)
+	}
+	return snap, nil
+}
+
+// Ping checks that the storage directory is still writable
+func (s *FileStore) Ping() error {
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".ping-*")
+	if err != nil {
+		return fmt.Errorf("storage not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Save atomically replaces the stored snapshot
+func (s *FileStore) Save(snap *Snapshot) error {
+	snap.SavedAt = time.Now()
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+	if err := writeAtomic(s.path, data); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	return nil
+}
+
+// writeAtomic replaces path with data through a temporary file. The file
+// and then its directory are synced, so after a crash path holds either
+// the old data or the new.
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory's entries, such as a rename, to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ReadJSON decodes the named file in the storage directory into v. A
+// missing file is reported as os.ErrNotExist.
+func (s *FileStore) ReadJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(s.path), name))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return nil
+}
+
+// WriteJSON atomically replaces the named file in the storage directory
+// with v encoded as JSON
+func (s *FileStore) WriteJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", name, err)
+	}
+	if err := writeAtomic(filepath.Join(filepath.Dir(s.path), name), data); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// AppendJSONLine appends v as one line of JSON to the named file in the
+// storage directory
+func (s *FileStore) AppendJSONLine(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", name, err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(s.path), name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadJSONLines decodes each line of the named file in the storage
+// directory with decode. A missing file has no lines.
+func (s *FileStore) ReadJSONLines(name string, decode func(line []byte) error) error {
+	f, err := os.Open(filepath.Join(filepath.Dir(s.path), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := decode(scanner.Bytes()); err != nil {
+			return fmt.Errorf("parsing %s: %w", name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// backupDir is where backups are kept
+func (s *FileStore) backupDir() string {
+	return filepath.Join(filepath.Dir(s.path), "backups")
+}
+
+// SaveBackup writes snap as a new timestamped backup next to the state
+func (s *FileStore) SaveBackup(snap *Snapshot) (Backup, error) {
+	snap.SavedAt = time.Now()
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return Backup{}, fmt.Errorf("encoding backup: %w", err)
+	}
+	if err := os.MkdirAll(s.backupDir(), 0o700); err != nil {
+		return Backup{}, fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	name := "state-" + snap.SavedAt.UTC().Format("20060102T150405.000Z") + ".json"
+	if err := writeAtomic(filepath.Join(s.backupDir(), name), data); err != nil {
+		return Backup{}, fmt.Errorf("writing backup: %w", err)
+	}
+	return Backup{Name: name, Size: int64(len(data)), SavedAt: snap.SavedAt}, nil
+}
+
+// Backups lists the saved backups, oldest first
+func (s *FileStore) Backups() ([]Backup, error) {
+	entries, err := os.ReadDir(s.backupDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+
+	backups := []Backup{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: entry.Name(), Size: info.Size(), SavedAt: info.ModTime()})
+	}
+	return backups, nil
+}
+
+// OpenBackup opens a backup by name for reading
+func (s *FileStore) OpenBackup(name string) (*os.File, error) {
+	if name != filepath.Base(name) || filepath.Ext(name) != ".json" {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(s.backupDir(), name))
+}
@@ -0,0 +1,365 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"terminal-chat/server/hub"
+	"terminal-chat/server/models"
+)
+
+const (
+	incomingFile = "incoming_webhooks.json"
+
+	// maxIncomingBody bounds the body of a post to an incoming webhook
+	maxIncomingBody = 256 * 1024
+
+	// maxAttachments is how many attachments one post may carry
+	maxAttachments = 10
+
+	// maxNameLength bounds bot names and username overrides
+	maxNameLength = 32
+
+	// DefaultBotName is shown for posts from a webhook created without a name
+	DefaultBotName = "webhook"
+)
+
+// IncomingHook lets other tools post into one channel
+type IncomingHook struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel"`
+
+	// Name is shown as the sender unless a post overrides it
+	Name string `json:"name"`
+
+	// TokenHash is the hex SHA-256 of the secret token; the token itself is
+	// only returned when the hook is created
+	TokenHash string `json:"token_hash,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Attachment is a simple card shown below a posted message
+type Attachment struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Text  string `json:"text,omitempty"`
+
+	// Color hints at severity, such as "good", "warning", "danger" or a
+	// hex color
+	Color string `json:"color,omitempty"`
+}
+
+// Post is the body accepted by an incoming webhook. Alertmanager's webhook
+// payload is understood as well when Text is empty.
+type Post struct {
+	Text        string       `json:"text"`
+	Username    string       `json:"username"`
+	Avatar      string       `json:"avatar"`
+	Attachments []Attachment `json:"attachments"`
+
+	// Alertmanager fields
+	Status string  `json:"status"`
+	Alerts []alert `json:"alerts"`
+}
+
+// alert is one alert in an Alertmanager notification
+type alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+// Incoming holds the incoming webhooks and posts what they receive to the hub
+type Incoming struct {
+	hub        *hub.Hub
+	store      Store
+	maxContent int
+
+	mu    sync.Mutex
+	hooks []*IncomingHook
+}
+
+// NewIncoming loads the saved incoming webhooks. Posted text is limited to
+// maxContent characters, like messages from clients.
+func NewIncoming(h *hub.Hub, store Store, maxContent int) (*Incoming, error) {
+	in := &Incoming{hub: h, store: store, maxContent: maxContent}
+	if err := store.ReadJSON(incomingFile, &in.hooks); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loading incoming webhooks: %w", err)
+	}
+	return in, nil
+}
+
+// Create adds an incoming webhook for a channel and returns it with its
+// secret token
+func (in *Incoming) Create(channel, name string) (IncomingHook, string, error) {
+	if name == "" {
+		name = DefaultBotName
+	}
+	if !validName(name) {
+		return IncomingHook{}, "", fmt.Errorf("%w: name must be 1 to %d printable characters", ErrInvalid, maxNameLength)
+	}
+	// Posts are only accepted for listed channels that are not archived
+	switch info, err := in.hub.Channel(channel); {
+	case err != nil:
+		return IncomingHook{}, "", err
+	case !info.Listed:
+		return IncomingHook{}, "", hub.ErrNoChannel
+	case info.Archived:
+		return IncomingHook{}, "", hub.ErrChannelArchived
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return IncomingHook{}, "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	hook := &IncomingHook{
+		ID:        uuid.New().String(),
+		Channel:   channel,
+		Name:      name,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now(),
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.hooks = append(in.hooks, hook)
+	if err := in.store.WriteJSON(incomingFile, in.hooks); err != nil {
+		in.hooks = in.hooks[:len(in.hooks)-1]
+		return IncomingHook{}, "", err
+	}
+	return hook.redacted(), token, nil
+}
+
+// Delete removes an incoming webhook
+func (in *Incoming) Delete(id string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	i := slices.IndexFunc(in.hooks, func(h *IncomingHook) bool { return h.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	removed := in.hooks[i]
+	in.hooks = slices.Delete(in.hooks, i, i+1)
+	if err := in.store.WriteJSON(incomingFile, in.hooks); err != nil {
+		in.hooks = slices.Insert(in.hooks, i, removed)
+		return err
+	}
+	return nil
+}
+
+// List returns the incoming webhooks, optionally only those for channel
+func (in *Incoming) List(channel string) []IncomingHook {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	hooks := []IncomingHook{}
+	for _, hook := range in.hooks {
+		if channel == "" || hook.Channel == channel {
+			hooks = append(hooks, hook.redacted())
+		}
+	}
+	return hooks
+}
+
+// find returns the hook with id if token is its secret
+func (in *Incoming) find(id, token string) (IncomingHook, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for _, hook := range in.hooks {
+		if hook.ID == id {
+			ok := subtle.ConstantTimeCompare([]byte(hook.TokenHash), []byte(hashToken(token))) == 1
+			return *hook, ok
+		}
+	}
+	return IncomingHook{}, false
+}
+
+func (h *IncomingHook) redacted() IncomingHook {
+	hook := *h
+	hook.TokenHash = ""
+	return hook
+}
+
+// Handler serves posts to incoming webhooks. The token is taken from the
+// URL, /hooks/{id}/{token}, or from an Authorization: Bearer header on
+// /hooks/{id}.
+func (in *Incoming) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hooks/{id}/{token}", func(w http.ResponseWriter, r *http.Request) {
+		in.post(w, r, r.PathValue("token"))
+	})
+	mux.HandleFunc("POST /hooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		in.post(w, r, token)
+	})
+	return mux
+}
+
+// post checks the token, turns the body into a message and posts it
+func (in *Incoming) post(w http.ResponseWriter, r *http.Request, token string) {
+	hook, ok := in.find(r.PathValue("id"), token)
+	if !ok || token == "" {
+		// Unknown hooks and wrong tokens look the same
+		http.Error(w, "unknown webhook or wrong token", http.StatusNotFound)
+		return
+	}
+
+	var post Post
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncomingBody)).Decode(&post); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := in.message(hook, post)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err := in.hub.Post(event); {
+	case errors.Is(err, hub.ErrNoChannel), errors.Is(err, hub.ErrChannelArchived):
+		http.Error(w, "#"+hook.Channel+": "+err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	slog.Debug("Incoming webhook posted", "webhook", hook.ID, "channel", hook.Channel, "from", event.From)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": event.ID})
+}
+
+// message builds the chat message for a post
+func (in *Incoming) message(hook IncomingHook, post Post) (models.Event, error) {
+	if post.Text == "" && len(post.Alerts) > 0 {
+		post.Text, post.Attachments = alertMessage(post)
+	}
+	post.Text = strings.ReplaceAll(post.Text, "\r\n", "\n")
+	if strings.TrimSpace(post.Text) == "" && len(post.Attachments) == 0 {
+		return models.Event{}, errors.New("text or attachments are required")
+	}
+	if n := utf8.RuneCountInString(post.Text); n > in.maxContent {
+		return models.Event{}, fmt.Errorf("text is %d characters, the limit is %d", n, in.maxContent)
+	}
+	// Clients print messages as they are, so escape sequences would reach
+	// every member's terminal
+	if !printable(post.Text, "\n\t") {
+		return models.Event{}, errors.New("text cannot contain control characters other than newlines and tabs")
+	}
+	if !printable(post.Avatar, "") {
+		return models.Event{}, errors.New("avatar cannot contain control characters")
+	}
+	if len(post.Attachments) > maxAttachments {
+		return models.Event{}, fmt.Errorf("at most %d attachments are allowed", maxAttachments)
+	}
+	for i := range post.Attachments {
+		a := &post.Attachments[i]
+		a.Text = strings.ReplaceAll(a.Text, "\r\n", "\n")
+		if utf8.RuneCountInString(a.Title)+utf8.RuneCountInString(a.Text) > in.maxContent {
+			return models.Event{}, fmt.Errorf("attachments are limited to %d characters each", in.maxContent)
+		}
+		if !printable(a.Title, "") || !printable(a.URL, "") || !printable(a.Color, "") || !printable(a.Text, "\n\t") {
+			return models.Event{}, errors.New("attachments cannot contain control characters other than newlines and tabs in text")
+		}
+	}
+
+	from := hook.Name
+	if post.Username != "" {
+		if !validName(post.Username) {
+			return models.Event{}, fmt.Errorf("username must be 1 to %d printable characters", maxNameLength)
+		}
+		from = post.Username
+	}
+
+	data := map[string]interface{}{"bot": true, "webhook": hook.ID}
+	if post.Avatar != "" {
+		data["avatar"] = post.Avatar
+	}
+	if len(post.Attachments) > 0 {
+		data["attachments"] = post.Attachments
+	}
+
+	return models.Event{
+		ID:        uuid.New().String(),
+		Type:      "send_message",
+		Channel:   hook.Channel,
+		From:      from,
+		Timestamp: time.Now().Unix(),
+		Content:   post.Text,
+		Data:      data,
+	}, nil
+}
+
+// alertMessage summarizes an Alertmanager notification, with one
+// attachment per alert
+func alertMessage(post Post) (string, []Attachment) {
+	name := post.Alerts[0].Labels["alertname"]
+	text := fmt.Sprintf("[%s:%d] %s", strings.ToUpper(post.Status), len(post.Alerts), name)
+
+	var attachments []Attachment
+	for _, a := range post.Alerts {
+		if len(attachments) == maxAttachments {
+			break
+		}
+		color := "danger"
+		if a.Status == "resolved" {
+			color = "good"
+		}
+		title := a.Annotations["summary"]
+		if title == "" {
+			title = a.Labels["alertname"]
+		}
+		attachments = append(attachments, Attachment{
+			Title: title,
+			URL:   a.GeneratorURL,
+			Text:  a.Annotations["description"],
+			Color: color,
+		})
+	}
+	return text, attachments
+}
+
+// validName reports whether name can be shown as a sender
+func validName(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return false
+	}
+	return printable(name, "")
+}
+
+// printable reports whether s has no control characters but those in allowed
+func printable(s, allowed string) bool {
+	return !strings.ContainsFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) && !strings.ContainsRune(allowed, r)
+	})
+}
+
+// hashToken returns the hex SHA-256 of an incoming webhook token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
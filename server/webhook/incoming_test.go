@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIncomingMessage(t *testing.T) {
+	in := &Incoming{maxContent: 20}
+	hook := IncomingHook{ID: "hook", Channel: "general", Name: "ci"}
+
+	for _, c := range []struct {
+		name string
+		post Post
+		want string
+	}{
+		{"empty", Post{Text: " "}, "text or attachments are required"},
+		{"too long", Post{Text: strings.Repeat("a", 21)}, "the limit is 20"},
+		{"escape in text", Post{Text: "\x1b[2Jgone"}, "text cannot contain control characters"},
+		{"carriage return in text", Post{Text: "over\rwritten"}, "text cannot contain control characters"},
+		{"escape in avatar", Post{Text: "hi", Avatar: "\x1b]0;title\a"}, "avatar cannot contain control characters"},
+		{"newline in username", Post{Text: "hi", Username: "ci\nroot"}, "username must be"},
+		{"escape in attachment", Post{Attachments: []Attachment{{Title: "\x1b[31mred"}}}, "attachments cannot contain control characters"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := in.message(hook, c.post); err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("message = %v, want an error containing %q", err, c.want)
+			}
+		})
+	}
+
+	event, err := in.message(hook, Post{Text: "line one\r\n\tline two", Avatar: ":robot:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Content != "line one\n\tline two" || event.From != "ci" || event.Data["avatar"] != ":robot:" {
+		t.Errorf("event = %+v", event)
+	}
+}
@@ -0,0 +1,580 @@
+// Package webhook delivers channel events to HTTP endpoints registered by
+// admins. Each delivery is a POST of a JSON body signed with the
+// subscription's secret, retried with exponential backoff and written to a
+// dead-letter log once it has failed for good.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/models"
+)
+
+const (
+	// queueSize is the number of deliveries waiting for a worker
+	queueSize = 1024
+
+	// workers is the number of concurrent deliveries
+	workers = 4
+
+	// maxAttempts is how often a delivery is tried before it is dead-lettered
+	maxAttempts = 6
+
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 10 * time.Second
+
+	// historySize is the number of recent deliveries kept per subscription
+	historySize = 50
+
+	subscriptionsFile = "webhooks.json"
+	deadLetterFile    = "webhooks-dead-letter.jsonl"
+)
+
+// minBackoff and maxBackoff bound the delay between attempts. They are
+// variables so tests can shorten them.
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// DefaultEvents are delivered when a subscription does not list any
+var DefaultEvents = []string{"send_message"}
+
+var (
+	// ErrNotFound is returned for an unknown subscription
+	ErrNotFound = errors.New("no such webhook")
+
+	// ErrInvalid is returned for a subscription that cannot be registered
+	ErrInvalid = errors.New("invalid webhook")
+)
+
+// Store persists subscriptions and dead letters
+type Store interface {
+	ReadJSON(name string, v interface{}) error
+	WriteJSON(name string, v interface{}) error
+	AppendJSONLine(name string, v interface{}) error
+	ReadJSONLines(name string, decode func(line []byte) error) error
+}
+
+// Subscription sends matching events to URL
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Secret signs every delivery; it is only shown when the subscription
+	// is created
+	Secret string `json:"secret,omitempty"`
+
+	// Channels limits deliveries to these channels; empty means all
+	Channels []string `json:"channels"`
+
+	// Events lists the event types delivered
+	Events []string `json:"events"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether event should be delivered to the subscription
+func (s *Subscription) Matches(event models.Event) bool {
+	if !slices.Contains(s.Events, event.Type) {
+		return false
+	}
+	return len(s.Channels) == 0 || slices.Contains(s.Channels, event.Channel)
+}
+
+// Redacted returns a copy without the secret
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Delivery records one event sent to one subscription
+type Delivery struct {
+	ID        string `json:"id"`
+	Webhook   string `json:"webhook"`
+	EventType string `json:"event_type"`
+	Channel   string `json:"channel,omitempty"`
+
+	// Status is pending, retrying, delivered or failed
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Payload is the JSON body POSTed for a delivery
+type Payload struct {
+	Delivery string       `json:"delivery"`
+	Webhook  string       `json:"webhook"`
+	Event    models.Event `json:"event"`
+}
+
+// DeadLetter is a delivery that failed for good, with what was sent
+type DeadLetter struct {
+	Delivery
+	URL     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// job is a delivery in flight
+type job struct {
+	sub      Subscription
+	delivery *Delivery
+	body     []byte
+}
+
+// Dispatcher matches events against subscriptions and delivers them
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	queue  chan *job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	subs    []*Subscription
+	history map[string][]*Delivery
+	retries map[*job]*time.Timer
+	closed  bool
+
+	// letters are dead letters waiting to be written; letterReady wakes
+	// the writer, which closes lettersDone once it has written the last
+	letters     []DeadLetter
+	letterReady chan struct{}
+	lettersDone chan struct{}
+}
+
+// NewDispatcher loads the saved subscriptions and starts the workers
+func NewDispatcher(store Store) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: requestTimeout},
+		queue:   make(chan *job, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		history: make(map[string][]*Delivery),
+		retries: make(map[*job]*time.Timer),
+
+		letterReady: make(chan struct{}, 1),
+		lettersDone: make(chan struct{}),
+	}
+
+	var subs []*Subscription
+	if err := store.ReadJSON(subscriptionsFile, &subs); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cancel()
+		return nil, fmt.Errorf("loading webhooks: %w", err)
+	}
+	d.subs = subs
+
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	go d.writeDeadLetters()
+	return d, nil
+}
+
+// Observe queues deliveries of event to every matching subscription. It
+// never blocks; deliveries that cannot be queued are dead-lettered.
+func (d *Dispatcher) Observe(event models.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+	for _, sub := range d.subs {
+		if !sub.Matches(event) {
+			continue
+		}
+
+		j, err := d.newJob(*sub, event)
+		if err != nil {
+			slog.Error("Webhook: encoding payload", "webhook", sub.ID, "err", err)
+			continue
+		}
+		d.record(j.delivery)
+
+		select {
+		case d.queue <- j:
+		default:
+			d.fail(j, "delivery queue full")
+		}
+	}
+}
+
+// newJob builds the payload for a delivery
+func (d *Dispatcher) newJob(sub Subscription, event models.Event) (*job, error) {
+	now := time.Now()
+	delivery := &Delivery{
+		ID:        uuid.New().String(),
+		Webhook:   sub.ID,
+		EventType: event.Type,
+		Channel:   event.Channel,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	body, err := json.Marshal(Payload{Delivery: delivery.ID, Webhook: sub.ID, Event: event})
+	if err != nil {
+		return nil, err
+	}
+	return &job{sub: sub, delivery: delivery, body: body}, nil
+}
+
+// work delivers queued jobs until the queue is closed
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		d.attempt(j)
+	}
+}
+
+// attempt sends a delivery once and schedules a retry or records the outcome
+func (d *Dispatcher) attempt(j *job) {
+	code, err := d.post(j)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j.delivery.Attempts++
+	j.delivery.StatusCode = code
+	j.delivery.UpdatedAt = time.Now()
+
+	if err == nil {
+		j.delivery.Status = "delivered"
+		j.delivery.Error = ""
+		metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		return
+	}
+	j.delivery.Error = err.Error()
+
+	if d.closed {
+		d.fail(j, "server shutting down: "+err.Error())
+		return
+	}
+	if !retryable(code) || j.delivery.Attempts >= maxAttempts {
+		d.fail(j, err.Error())
+		return
+	}
+
+	j.delivery.Status = "retrying"
+	metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+	delay := backoff(j.delivery.Attempts)
+	d.retries[j] = time.AfterFunc(delay, func() { d.retry(j) })
+	slog.Warn("Webhook delivery failed, retrying", "webhook", j.sub.ID, "delivery", j.delivery.ID,
+		"attempt", j.delivery.Attempts, "retry_in", delay, "err", err)
+}
+
+// retry queues a delivery again once its backoff has passed
+func (d *Dispatcher) retry(j *job) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.retries[j]; !ok {
+		// Cancelled by Close or Delete
+		return
+	}
+	delete(d.retries, j)
+
+	select {
+	case d.queue <- j:
+	default:
+		d.fail(j, "delivery queue full")
+	}
+}
+
+// post sends the delivery, returning the response status and an error for
+// anything but a 2xx response
+func (d *Dispatcher) post(j *job) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, j.sub.URL, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "terminal-chat-webhook")
+	req.Header.Set("X-Chat-Webhook", j.sub.ID)
+	req.Header.Set("X-Chat-Delivery", j.delivery.ID)
+	req.Header.Set("X-Chat-Event", j.delivery.EventType)
+	req.Header.Set("X-Chat-Timestamp", timestamp)
+	req.Header.Set("X-Chat-Signature", "sha256="+Sign(j.sub.Secret, timestamp, j.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" with secret.
+// Receivers recompute it to check X-Chat-Signature, and should reject old
+// timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryable reports whether a failed attempt may succeed later. Network
+// errors, 408, 429 and 5xx responses are retried; other statuses are not.
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoff returns the delay before the attempt after the given one
+func backoff(attempts int) time.Duration {
+	delay := minBackoff << (attempts - 1)
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// fail marks a delivery failed and queues it for the dead-letter log. The
+// caller must hold d.mu; the log is written by writeDeadLetters, so Observe
+// never waits for the disk.
+func (d *Dispatcher) fail(j *job, reason string) {
+	j.delivery.Status = "failed"
+	j.delivery.Error = reason
+	j.delivery.UpdatedAt = time.Now()
+	metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+
+	d.letters = append(d.letters, DeadLetter{Delivery: *j.delivery, URL: j.sub.URL, Payload: j.body})
+	select {
+	case d.letterReady <- struct{}{}:
+	default:
+	}
+	slog.Warn("Webhook delivery failed", "webhook", j.sub.ID, "delivery", j.delivery.ID,
+		"attempts", j.delivery.Attempts, "err", reason)
+}
+
+// writeDeadLetters appends queued dead letters to the log until Close has
+// closed letterReady, then writes any that are left
+func (d *Dispatcher) writeDeadLetters() {
+	defer close(d.lettersDone)
+	for {
+		_, open := <-d.letterReady
+
+		d.mu.Lock()
+		letters := d.letters
+		d.letters = nil
+		d.mu.Unlock()
+
+		for _, letter := range letters {
+			if err := d.store.AppendJSONLine(deadLetterFile, letter); err != nil {
+				slog.Error("Webhook: writing dead letter", "webhook", letter.Webhook, "delivery", letter.ID, "err", err)
+			}
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// record adds a delivery to its subscription's history. The caller must
+// hold d.mu.
+func (d *Dispatcher) record(delivery *Delivery) {
+	history := append(d.history[delivery.Webhook], delivery)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	d.history[delivery.Webhook] = history
+}
+
+// Create registers a subscription, generating its ID and, if none is
+// given, its secret. The returned copy includes the secret.
+func (d *Dispatcher) Create(sub Subscription) (Subscription, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("%w: url must be an http or https URL", ErrInvalid)
+	}
+	for _, ch := range sub.Channels {
+		if !models.ValidChannelID(ch) {
+			return Subscription{}, fmt.Errorf("%w: bad channel %q", ErrInvalid, ch)
+		}
+	}
+	if len(sub.Events) == 0 {
+		sub.Events = DefaultEvents
+	}
+	if sub.Channels == nil {
+		sub.Channels = []string{}
+	}
+	if sub.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Subscription{}, err
+		}
+		sub.Secret = hex.EncodeToString(secret)
+	}
+	sub.ID = uuid.New().String()
+	sub.CreatedAt = time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.subs = append(d.subs, &sub)
+	if err := d.save(); err != nil {
+		d.subs = d.subs[:len(d.subs)-1]
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Delete removes a subscription and cancels its pending retries
+func (d *Dispatcher) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.subs, func(s *Subscription) bool { return s.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	removed := d.subs[i]
+	d.subs = slices.Delete(d.subs, i, i+1)
+	if err := d.save(); err != nil {
+		d.subs = slices.Insert(d.subs, i, removed)
+		return err
+	}
+
+	for j, timer := range d.retries {
+		if j.sub.ID == id {
+			timer.Stop()
+			delete(d.retries, j)
+		}
+	}
+	delete(d.history, id)
+	return nil
+}
+
+// save writes the subscriptions. The caller must hold d.mu.
+func (d *Dispatcher) save() error {
+	return d.store.WriteJSON(subscriptionsFile, d.subs)
+}
+
+// List returns the subscriptions without their secrets
+func (d *Dispatcher) List() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs := []Subscription{}
+	for _, sub := range d.subs {
+		subs = append(subs, sub.Redacted())
+	}
+	return subs
+}
+
+// Get returns one subscription without its secret
+func (d *Dispatcher) Get(id string) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, sub := range d.subs {
+		if sub.ID == id {
+			return sub.Redacted(), nil
+		}
+	}
+	return Subscription{}, ErrNotFound
+}
+
+// Deliveries returns a subscription's recent deliveries, newest first
+func (d *Dispatcher) Deliveries(id string) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !slices.ContainsFunc(d.subs, func(s *Subscription) bool { return s.ID == id }) {
+		return nil, ErrNotFound
+	}
+	deliveries := []Delivery{}
+	for _, delivery := range d.history[id] {
+		deliveries = append(deliveries, *delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries, nil
+}
+
+// DeadLetters returns the most recent failed deliveries, newest first
+func (d *Dispatcher) DeadLetters(limit int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := d.store.ReadJSONLines(deadLetterFile, func(line []byte) error {
+		var letter DeadLetter
+		if err := json.Unmarshal(line, &letter); err != nil {
+			return err
+		}
+		letters = append(letters, letter)
+		if len(letters) > limit {
+			letters = letters[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(letters)
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	return letters, nil
+}
+
+// Close stops delivering. Attempts in flight are aborted and they, queued
+// deliveries and pending retries are dead-lettered. It returns once the dead
+// letters are written.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	d.cancel()
+	for j, timer := range d.retries {
+		timer.Stop()
+		delete(d.retries, j)
+		d.fail(j, "server shutting down")
+	}
+	close(d.queue)
+	d.mu.Unlock()
+
+	// Once the workers are done nothing else fails
+	d.wg.Wait()
+	close(d.letterReady)
+	<-d.lettersDone
+}
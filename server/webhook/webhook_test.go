@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"terminal-chat/server/models"
+	"terminal-chat/server/storage"
+)
+
+const timeout = 5 * time.Second
+
+// request is what an endpoint received
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// endpoint is an HTTP server answering deliveries with the given statuses in
+// turn, repeating the last, and recording what it receives
+type endpoint struct {
+	*httptest.Server
+	requests chan request
+
+	mu       sync.Mutex
+	statuses []int
+}
+
+func newEndpoint(t *testing.T, statuses ...int) *endpoint {
+	t.Helper()
+	e := &endpoint{requests: make(chan request, 16), statuses: statuses}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		status := e.statuses[0]
+		if len(e.statuses) > 1 {
+			e.statuses = e.statuses[1:]
+		}
+		e.mu.Unlock()
+		w.WriteHeader(status)
+		e.requests <- request{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// next returns the next request the endpoint received
+func (e *endpoint) next(t *testing.T) request {
+	t.Helper()
+	select {
+	case r := <-e.requests:
+		return r
+	case <-time.After(timeout):
+		t.Fatal("no delivery received")
+		return request{}
+	}
+}
+
+// newDispatcher starts a dispatcher with backoff shortened to milliseconds
+func newDispatcher(t *testing.T) *Dispatcher {
+	t.Helper()
+	oldMin, oldMax := minBackoff, maxBackoff
+	minBackoff, maxBackoff = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { minBackoff, maxBackoff = oldMin, oldMax })
+
+	store, err := storage.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// subscribe registers a subscription to send_message events in #general
+func subscribe(t *testing.T, d *Dispatcher, url string) Subscription {
+	t.Helper()
+	sub, err := d.Create(Subscription{URL: url, Secret: "s3cret", Channels: []string{"general"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+var message = models.Event{Type: "send_message", Channel: "general", From: "alice", Content: "hello"}
+
+func TestDeliverySigned(t *testing.T) {
+	e := newEndpoint(t, http.StatusNoContent)
+	d := newDispatcher(t)
+	sub := subscribe(t, d, e.URL)
+
+	// Events for other channels or of other types are not delivered
+	d.Observe(models.Event{Type: "send_message", Channel: "random", Content: "elsewhere"})
+	d.Observe(models.Event{Type: "typing_start", Channel: "general"})
+	d.Observe(message)
+
+	r := e.next(t)
+	timestamp := r.header.Get("X-Chat-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(r.body)
+	if got, want := r.header.Get("X-Chat-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("X-Chat-Signature = %q, want %q", got, want)
+	}
+	if sec, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sec, 0)).Abs() > time.Minute {
+		t.Errorf("X-Chat-Timestamp = %q, want the current Unix time", timestamp)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Webhook != sub.ID || payload.Event.Content != "hello" || payload.Delivery != r.header.Get("X-Chat-Delivery") {
+		t.Errorf("payload = %+v, headers = %v", payload, r.header)
+	}
+	if r.header.Get("X-Chat-Webhook") != sub.ID || r.header.Get("X-Chat-Event") != "send_message" {
+		t.Errorf("headers = %v", r.header)
+	}
+
+	select {
+	case r := <-e.requests:
+		t.Errorf("unexpected delivery %s", r.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// deliveries waits until the subscription's latest delivery has status
+func deliveries(t *testing.T, d *Dispatcher, id, status string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		history, err := d.Deliveries(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) > 0 && history[0].Status == status {
+			return history[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries = %+v, want one %s", history, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	e := newEndpoint(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	d := newDispatcher(t)
+	sub := subscribe(t, d, e.URL)
+	d.Observe(message)
+
+	// Every attempt is the same delivery, with the same body
+	first := e.next(t)
+	for range 2 {
+		if r := e.next(t); r.header.Get("X-Chat-Delivery") != first.header.Get("X-Chat-Delivery") || string(r.body) != string(first.body) {
+			t.Errorf("retry %s differs from the first attempt %s", r.body, first.body)
+		}
+	}
+	if delivery := deliveries(t, d, sub.ID, "delivered"); delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK || delivery.Error != "" {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if letters, err := d.DeadLetters(10); err != nil || len(letters) != 0 {
+		t.Errorf("dead letters = %+v, %v", letters, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		5:  16 * time.Second,
+		7:  time.Minute,
+		70: time.Minute,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// deadLetters waits for n dead letters and returns them
+func deadLetters(t *testing.T, d *Dispatcher, n int) []DeadLetter {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		letters, err := d.DeadLetters(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) >= n {
+			return letters
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dead letters = %+v, want %d", letters, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	e := newEndpoint(t, http.StatusInternalServerError)
+	d := newDispatcher(t)
+	sub := subscribe(t, d, e.URL)
+	d.Observe(message)
+
+	for range maxAttempts {
+		e.next(t)
+	}
+	letter := deadLetters(t, d, 1)[0]
+	if letter.Webhook != sub.ID || letter.Status != "failed" || letter.Attempts != maxAttempts ||
+		letter.StatusCode != http.StatusInternalServerError || letter.URL != e.URL {
+		t.Errorf("dead letter = %+v", letter)
+	}
+	var payload Payload
+	if err := json.Unmarshal(letter.Payload, &payload); err != nil || payload.Delivery != letter.ID || payload.Event.Content != "hello" {
+		t.Errorf("dead letter payload = %s, %v", letter.Payload, err)
+	}
+	if delivery := deliveries(t, d, sub.ID, "failed"); delivery.Attempts != maxAttempts {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestClientErrorNotRetried(t *testing.T) {
+	e := newEndpoint(t, http.StatusBadRequest)
+	d := newDispatcher(t)
+	subscribe(t, d, e.URL)
+	d.Observe(message)
+
+	e.next(t)
+	if letter := deadLetters(t, d, 1)[0]; letter.Attempts != 1 || letter.StatusCode != http.StatusBadRequest {
+		t.Errorf("dead letter = %+v", letter)
+	}
+	select {
+	case r := <-e.requests:
+		t.Errorf("retried %s", r.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"terminal-chat/server/metrics"
+	"terminal-chat/server/protocol"
+)
+
+// originChecker returns an Upgrader.CheckOrigin function. Requests without an
+// Origin header (non-browser clients) and same-origin requests are allowed;
+// anything else must be in the allowlist.
+func originChecker(allowed []string) func(r *http.Request) bool {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, origin := range allowed {
+		allowedSet[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		if allowedSet[strings.ToLower(origin)] {
+			return true
+		}
+
+		requestLog(r).Warn("Rejected WebSocket handshake: origin not allowed", "origin", origin)
+		return false
+	}
+}
+
+// upgradeError is the Upgrader's error handler. It counts the failure by
+// cause before writing the usual plain-text response.
+func upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	cause := "upgrade"
+	if status == http.StatusForbidden {
+		cause = "origin"
+	}
+	metrics.HandshakeFailures.WithLabelValues(cause).Inc()
+
+	w.Header().Set("Sec-Websocket-Version", "13")
+	http.Error(w, http.StatusText(status), status)
+}
+
+type requestLogKey struct{}
+
+// withRequestLog tags a handshake with a new request ID, returning the
+// request carrying a logger for it. The ID is echoed in X-Request-Id so a
+// rejected client can quote it.
+func withRequestLog(w http.ResponseWriter, r *http.Request) (*http.Request, *slog.Logger) {
+	id := uuid.New().String()
+	w.Header().Set("X-Request-Id", id)
+
+	logger := slog.With("request_id", id, "remote", clientIP(r))
+	return r.WithContext(context.WithValue(r.Context(), requestLogKey{}, logger)), logger
+}
+
+// requestLog returns the logger attached by withRequestLog
+func requestLog(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(requestLogKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.With("remote", clientIP(r))
+}
+
+// hasSubprotocol reports whether the client requested our protocol version
+func hasSubprotocol(r *http.Request) bool {
+	for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if strings.TrimSpace(p) == protocol.Subprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// handshakeLimiter caps the number of in-progress handshakes per IP
+type handshakeLimiter struct {
+	max      int
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newHandshakeLimiter(max int) *handshakeLimiter {
+	return &handshakeLimiter{
+		max:      max,
+		inFlight: make(map[string]int),
+	}
+}
+
+// acquire reserves a handshake slot for ip, returning false if none are free
+func (l *handshakeLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[ip] >= l.max {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+// release frees a slot taken by acquire
+func (l *handshakeLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight[ip]--
+	if l.inFlight[ip] <= 0 {
+		delete(l.inFlight, ip)
+	}
+}
+
+// clientIP returns the remote IP of a request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
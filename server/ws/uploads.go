@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"terminal-chat/server/files"
+	"terminal-chat/server/hub"
+	"terminal-chat/server/models"
+)
+
+const (
+	// maxUploads is how many uploads one session may have in progress
+	maxUploads = 4
+
+	// maxFilesPerMessage bounds the files one message may carry
+	maxFilesPerMessage = 10
+
+	// maxUploadIDLength bounds the IDs clients choose for uploads
+	maxUploadIDLength = 64
+)
+
+// chunkSize returns how many bytes of a file an upload_chunk may carry so
+// that, base64 encoded, the event fits in a frame of maxMessageSize
+func chunkSize(maxMessageSize int64) int {
+	n := (maxMessageSize - 1024) / 4 * 3
+	return int(max(n, 1024) / 1024 * 1024)
+}
+
+// uploads receives the files a session sends in chunks. It is only used by
+// the session's read pump.
+type uploads struct {
+	store  *files.Store
+	hub    *hub.Hub
+	client *models.Client
+	chunk  int
+	log    *slog.Logger
+
+	// active holds the uploads in progress by the ID the client chose
+	active map[string]*files.Upload
+}
+
+func newUploads(store *files.Store, h *hub.Hub, client *models.Client, maxMessageSize int64, log *slog.Logger) *uploads {
+	return &uploads{
+		store:  store,
+		hub:    h,
+		client: client,
+		chunk:  chunkSize(maxMessageSize),
+		log:    log,
+		active: make(map[string]*files.Upload),
+	}
+}
+
+// handle processes an upload event, reporting false for other events
+func (u *uploads) handle(event models.Event) bool {
+	id, _ := event.Data["upload_id"].(string)
+	switch event.Type {
+	case "upload_start":
+		u.start(id, event)
+	case "upload_chunk":
+		u.write(id, event)
+	case "upload_finish":
+		u.finish(id, event)
+	case "upload_cancel":
+		if up := u.active[id]; up != nil {
+			up.Abort()
+			delete(u.active, id)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// start begins an upload of the file named in the event's data to its
+// channel and tells the client what chunk size to use
+func (u *uploads) start(id string, event models.Event) {
+	switch {
+	case id == "" || len(id) > maxUploadIDLength:
+		u.fail(id, "invalid_upload", "upload_start needs an upload_id of at most 64 characters", nil)
+		return
+	case u.active[id] != nil:
+		u.fail(id, "invalid_upload", "Upload "+id+" is already in progress", nil)
+		return
+	case len(u.active) >= maxUploads:
+		u.fail(id, "too_many_uploads", fmt.Sprintf("At most %d uploads may be in progress", maxUploads),
+			map[string]interface{}{"limit": maxUploads})
+		return
+	case !models.ValidChannelID(event.Channel):
+		u.fail(id, "invalid_upload", "upload_start needs the channel the file will be posted to", nil)
+		return
+	}
+
+	name, _ := event.Data["name"].(string)
+	size, _ := event.Data["size"].(float64)
+	up, err := u.store.Begin(name, int64(size), event.Channel, u.client.Username)
+	switch {
+	case errors.Is(err, files.ErrTooLarge):
+		u.fail(id, "file_too_large", fmt.Sprintf("Files are limited to %d bytes", u.store.MaxSize()),
+			map[string]interface{}{"limit": u.store.MaxSize()})
+		return
+	case errors.Is(err, files.ErrInvalidName):
+		u.fail(id, "invalid_upload", err.Error(), nil)
+		return
+	case err != nil:
+		u.log.Error("Starting upload", "err", err)
+		u.fail(id, "upload_failed", "The server could not store the file", nil)
+		return
+	}
+
+	u.active[id] = up
+	u.reply("upload_ready", event.Channel, map[string]interface{}{
+		"upload_id":  id,
+		"chunk_size": u.chunk,
+	})
+}
+
+// write adds a chunk to an upload. A bad chunk ends the upload.
+func (u *uploads) write(id string, event models.Event) {
+	up := u.active[id]
+	if up == nil {
+		u.fail(id, "unknown_upload", "No upload "+id+" is in progress", nil)
+		return
+	}
+	encoded, _ := event.Data["data"].(string)
+	offset, _ := event.Data["offset"].(float64)
+	chunk, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil && len(chunk) > u.chunk {
+		err = fmt.Errorf("chunks are limited to %d bytes", u.chunk)
+	}
+	if err == nil {
+		err = up.Write(int64(offset), chunk)
+	}
+	if err != nil {
+		up.Abort()
+		delete(u.active, id)
+		u.fail(id, "invalid_upload", "Upload "+id+" failed: "+err.Error(),
+			map[string]interface{}{"received": up.Received()})
+	}
+}
+
+// finish stores a complete upload and sends the client the file's metadata
+func (u *uploads) finish(id string, event models.Event) {
+	up := u.active[id]
+	if up == nil {
+		u.fail(id, "unknown_upload", "No upload "+id+" is in progress", nil)
+		return
+	}
+	delete(u.active, id)
+
+	sum, _ := event.Data["sha256"].(string)
+	f, err := up.Finish(sum)
+	switch {
+	case errors.Is(err, files.ErrIncomplete), errors.Is(err, files.ErrChecksum):
+		u.fail(id, "invalid_upload", "Upload "+id+" failed: "+err.Error(), nil)
+		return
+	case err != nil:
+		u.log.Error("Finishing upload", "err", err)
+		u.fail(id, "upload_failed", "The server could not store the file", nil)
+		return
+	}
+
+	u.log.Info("File uploaded", "file", f.ID, "size", f.Size, "mime", f.MIME, "channel", f.Channel)
+	u.reply("upload_complete", f.Channel, map[string]interface{}{
+		"upload_id": id,
+		"file":      f,
+	})
+}
+
+// close discards the uploads left unfinished when the session ends
+func (u *uploads) close() {
+	for id, up := range u.active {
+		up.Abort()
+		delete(u.active, id)
+	}
+}
+
+func (u *uploads) reply(eventType, channel string, data map[string]interface{}) {
+	u.hub.SendTo(u.client, models.Event{
+		Type:      eventType,
+		Channel:   channel,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
+func (u *uploads) fail(id, code, content string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["upload_id"] = id
+	sendError(u.hub, u.client, code, content, data)
+}
+
+// attachFiles replaces the file IDs in a message's data.files with the
+// files' metadata. Files must have been uploaded to the message's channel.
+func attachFiles(store *files.Store, event *models.Event) error {
+	raw, ok := event.Data["files"]
+	if !ok {
+		return nil
+	}
+	ids, _ := raw.([]interface{})
+	if len(ids) == 0 || len(ids) > maxFilesPerMessage {
+		return fmt.Errorf("data.files must list 1 to %d file IDs", maxFilesPerMessage)
+	}
+
+	attached := make([]models.File, 0, len(ids))
+	for _, v := range ids {
+		id, _ := v.(string)
+		f, ok := store.Get(id)
+		if !ok || f.Channel != event.Channel {
+			return fmt.Errorf("no file %q was uploaded to #%s", id, event.Channel)
+		}
+		attached = append(attached, f)
+	}
+	event.Data["files"] = attached
+	return nil
+}
@@ -2,37 +2,62 @@ package ws
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	"terminal-chat/server/bots"
+	"terminal-chat/server/config"
+	"terminal-chat/server/files"
 	"terminal-chat/server/hub"
+	"terminal-chat/server/logging"
+	"terminal-chat/server/metrics"
 	"terminal-chat/server/models"
+	"terminal-chat/server/outbox"
+	"terminal-chat/server/protocol"
 )
 
+// hardReadLimit is how many multiples of MaxMessageSize a single frame may
+// reach before the connection is dropped instead of the frame being discarded
+const hardReadLimit = 8
+
 // readPump handles incoming messages from the WebSocket connection
-func readPump(client *models.Client, h *hub.Hub) {
+func readPump(client *models.Client, h *hub.Hub, store *files.Store, limits config.Limits, log *slog.Logger) {
+	uploads := newUploads(store, h, client, limits.MaxMessageSize, log)
 	defer func() {
+		uploads.close()
 		h.Unregister <- client
 		client.Conn.(*websocket.Conn).Close()
 	}()
 
 	conn := client.Conn.(*websocket.Conn)
-	conn.SetReadLimit(512)
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetReadLimit(limits.MaxMessageSize * hardReadLimit)
+	conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := conn.ReadMessage()
+		message, err := readMessage(conn, limits.MaxMessageSize)
+		if err == errMessageTooLarge {
+			sendError(h, client, "message_too_large",
+				fmt.Sprintf("Message exceeds the %d byte limit", limits.MaxMessageSize),
+				map[string]interface{}{"limit": limits.MaxMessageSize})
+			continue
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				log.Warn("WebSocket read failed", "err", err)
 			}
 			break
 		}
@@ -40,85 +65,284 @@ func readPump(client *models.Client, h *hub.Hub) {
 		// Parse the message to add sender info
 		var event models.Event
 		if err := json.Unmarshal(message, &event); err != nil {
-			log.Printf("Error parsing message: %v", err)
+			log.Warn("Parsing message", "err", err, "bytes", len(message))
+			continue
+		}
+
+		// Files are received here rather than on the hub, which must not
+		// wait for the disk
+		if uploads.handle(event) {
+			continue
+		}
+
+		if n := utf8.RuneCountInString(event.Content); n > limits.MaxContentLength {
+			data := map[string]interface{}{"limit": limits.MaxContentLength, "length": n}
+			if event.ID != "" {
+				data["id"] = event.ID
+			}
+			sendError(h, client, "content_too_long",
+				fmt.Sprintf("Message is %d characters, the limit is %d", n, limits.MaxContentLength), data)
 			continue
 		}
 
+		// Clients print messages as they are, so escape sequences would
+		// reach every member's terminal
+		if event.Type == "send_message" {
+			event.Content = strings.ReplaceAll(event.Content, "\r\n", "\n")
+			if strings.ContainsFunc(event.Content, unprintable) {
+				data := map[string]interface{}{}
+				if event.ID != "" {
+					data["id"] = event.ID
+				}
+				sendError(h, client, "invalid_content",
+					"Messages cannot contain control characters other than newlines and tabs", data)
+				continue
+			}
+		}
+
 		// Add sender information
 		event.From = client.Username
 		event.Timestamp = time.Now().Unix()
 
-		// Re-encode with sender info
-		modifiedMessage, err := json.Marshal(event)
-		if err != nil {
-			log.Printf("Error marshaling modified message: %v", err)
-			continue
+		// Messages keep the ID the client chose so it can match the echo
+		// against its local copy; anything unusable is replaced
+		if event.Type == "send_message" && (event.ID == "" || len(event.ID) > 64) {
+			event.ID = uuid.New().String()
+		}
+
+		// Only the server says who is a bot, what a sender's display name
+		// and color are and what the attached files are. Clients may ask
+		// for an ack, which is not part of the message.
+		ack := false
+		if event.Type == "send_message" {
+			ack, _ = event.Data["ack"].(bool)
+			delete(event.Data, "ack")
+			delete(event.Data, "bot")
+			delete(event.Data, "webhook")
+			delete(event.Data, "display_name")
+			delete(event.Data, "color")
+			delete(event.Data, "action")
+			if client.Bot {
+				if event.Data == nil {
+					event.Data = make(map[string]interface{})
+				}
+				event.Data["bot"] = true
+			}
+			if err := attachFiles(store, &event); err != nil {
+				sendError(h, client, "unknown_file", err.Error(), map[string]interface{}{"id": event.ID})
+				continue
+			}
 		}
 
-		// Send to hub for broadcasting
-		h.Broadcast <- modifiedMessage
+		// Send to hub for routing
+		h.Inbound <- hub.Inbound{Client: client, Event: event, Ack: ack}
 	}
 }
 
+// unprintable reports whether r is a control character other than a
+// newline or tab
+func unprintable(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\t'
+}
+
+var errMessageTooLarge = errors.New("message too large")
+
+// readMessage reads the next frame, discarding it if it is larger than limit
+func readMessage(conn *websocket.Conn, limit int64) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > limit {
+		// Drain the rest of the frame so the connection stays usable
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+		return nil, errMessageTooLarge
+	}
+	return message, nil
+}
+
+// sendEvent queues an event for a client that is not yet registered with the hub
+func sendEvent(client *models.Client, event models.Event, log *slog.Logger) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error("Marshaling event", "type", event.Type, "err", err)
+		return
+	}
+
+	if err := client.Send.Push(outbox.Message{Data: data}); err != nil {
+		log.Warn("Dropping event", "type", event.Type, "err", err)
+	}
+}
+
+// sendError tells a client its request was rejected
+func sendError(h *hub.Hub, client *models.Client, code, content string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["code"] = code
+
+	h.SendTo(client, models.Event{
+		Type:      "error",
+		Content:   content,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
+
 // writePump handles outgoing messages to the WebSocket connection
-func writePump(client *models.Client) {
-	ticker := time.NewTicker(54 * time.Second)
+func writePump(client *models.Client, limits config.Limits, log *slog.Logger) {
+	ticker := time.NewTicker(limits.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		client.Conn.(*websocket.Conn).Close()
+		close(client.Closed)
 	}()
 
 	conn := client.Conn.(*websocket.Conn)
 
 	for {
 		select {
-		case message, ok := <-client.Send:
-			log.Printf("writePump: sending message to %s: %s", client.Username, string(message))
-			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-client.Send.Ready():
+			batch := client.Send.Drain()
+
+			if batch.Dropped > 0 {
+				// Tell the client its view is incomplete so it can refetch history
+				log.Warn("Dropped messages for slow client", "dropped", batch.Dropped)
+				metrics.DroppedMessages.WithLabelValues("policy").Add(float64(batch.Dropped))
+				notice, _ := json.Marshal(models.Event{
+					Type:      "resync",
+					Content:   fmt.Sprintf("%d messages were dropped because the connection fell behind", batch.Dropped),
+					Timestamp: time.Now().Unix(),
+					Data:      map[string]interface{}{"reason": "dropped", "dropped": batch.Dropped},
+				})
+				batch.Messages = append(batch.Messages, notice)
+			}
+
+			for _, message := range batch.Messages {
+				log.Debug("Sending message", "payload", logging.Payload(message))
+				conn.SetWriteDeadline(time.Now().Add(limits.WriteWait))
+				if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					log.Warn("Writing message", "err", err)
+					metrics.WriteErrors.Inc()
+					return
+				}
 			}
 
-			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("Error writing message to %s: %v", client.Username, err)
+			if batch.Closed {
+				// The hub closed the outbox; everything queued has been written
+				closeMessage := []byte{}
+				if batch.Code != 0 {
+					closeMessage = websocket.FormatCloseMessage(batch.Code, batch.Reason)
+				}
+				conn.SetWriteDeadline(time.Now().Add(limits.WriteWait))
+				conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
-			log.Printf("writePump: message sent successfully to %s", client.Username)
 
 		case <-ticker.C:
-			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			conn.SetWriteDeadline(time.Now().Add(limits.WriteWait))
 			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				metrics.WriteErrors.Inc()
 				return
 			}
 		}
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin in development
-		// In production, you'd want to restrict this
-		return true
-	},
-}
-
 // HandleWebSocket upgrades HTTP connection to WebSocket and manages the client
-func HandleWebSocket(h *hub.Hub) http.HandlerFunc {
+func HandleWebSocket(h *hub.Hub, cfg *config.Config, registry *bots.Registry, store *files.Store) http.HandlerFunc {
+	limits := cfg.Limits
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  limits.ReadBufferSize,
+		WriteBufferSize: limits.WriteBufferSize,
+		Subprotocols:    []string{protocol.Subprotocol},
+		CheckOrigin:     originChecker(cfg.AllowedOrigins),
+		Error:           upgradeError,
+	}
+	handshakes := newHandshakeLimiter(limits.MaxHandshakesPerIP)
+	policy := outbox.Policy(limits.SlowConsumerPolicy)
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("Failed to upgrade connection: %v", err)
+		r, log := withRequestLog(w, r)
+		ip := clientIP(r)
+		if !handshakes.acquire(ip) {
+			log.Warn("Rejected WebSocket handshake: too many concurrent handshakes")
+			metrics.HandshakeFailures.WithLabelValues("rate_limited").Inc()
+			http.Error(w, "too many concurrent handshakes", http.StatusTooManyRequests)
 			return
 		}
 
-		// For now, we'll use a simple username from query params
-		// In a real app, you'd authenticate the user properly
-		username := r.URL.Query().Get("username")
-		if username == "" {
-			username = "Anonymous"
+		if h.Draining() {
+			handshakes.release(ip)
+			log.Info("Rejected WebSocket handshake: server is shutting down")
+			metrics.HandshakeFailures.WithLabelValues("draining").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !hasSubprotocol(r) {
+			handshakes.release(ip)
+			log.Warn("Rejected WebSocket handshake: missing subprotocol", "want", protocol.Subprotocol)
+			metrics.HandshakeFailures.WithLabelValues("subprotocol").Inc()
+			http.Error(w, "unsupported protocol version, expected "+protocol.Subprotocol, http.StatusBadRequest)
+			return
+		}
+
+		// Bots authenticate with their API token. People still pick a
+		// username in the query, but not one belonging to a bot.
+		var (
+			username string
+			isBot    bool
+		)
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			bot, ok := registry.Authenticate(token)
+			if !ok {
+				handshakes.release(ip)
+				log.Warn("Rejected WebSocket handshake: invalid bot token")
+				metrics.HandshakeFailures.WithLabelValues("unauthorized").Inc()
+				http.Error(w, "invalid bot token", http.StatusUnauthorized)
+				return
+			}
+			username, isBot = bot.Name, true
+		} else {
+			username = r.URL.Query().Get("username")
+			if username == "" {
+				username = "Anonymous"
+			}
+			if registry.IsBot(username) {
+				handshakes.release(ip)
+				log.Warn("Rejected WebSocket handshake: username belongs to a bot", "user", username)
+				metrics.HandshakeFailures.WithLabelValues("unauthorized").Inc()
+				http.Error(w, "username "+username+" belongs to a bot", http.StatusForbidden)
+				return
+			}
+		}
+
+		if ban, banned := h.IsBanned(username); banned {
+			handshakes.release(ip)
+			log.Info("Rejected WebSocket handshake: user is banned", "user", username)
+			metrics.HandshakeFailures.WithLabelValues("banned").Inc()
+			msg := "banned"
+			if ban.Reason != "" {
+				msg += ": " + ban.Reason
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		handshakes.release(ip)
+		if err != nil {
+			log.Warn("Failed to upgrade connection", "err", err)
+			return
 		}
 
 		client := &models.Client{
@@ -126,14 +350,41 @@ func HandleWebSocket(h *hub.Hub) http.HandlerFunc {
 			UserID:   uuid.New().String(), // In real app, this would come from auth
 			Username: username,
 			Conn:     conn,
-			Send:     make(chan []byte, 256),
+			Send:     outbox.New(limits.SendBufferSize, policy),
+			Closed:   make(chan struct{}),
+
+			RemoteAddr:  ip,
+			ConnectedAt: time.Now(),
+			Bot:         isBot,
+		}
+		if isBot {
+			// Bots get only commands and replies until they subscribe
+			client.Subscribe(nil)
 		}
 
+		log = log.With("session", client.ID, "user", client.Username)
+		log.Info("WebSocket connection accepted")
+
+		// Advertise limits and channels so the client can enforce and show them
+		sendEvent(client, models.Event{
+			Type:      "welcome",
+			Timestamp: time.Now().Unix(),
+			Data: map[string]interface{}{
+				"max_message_size":   limits.MaxMessageSize,
+				"max_content_length": limits.MaxContentLength,
+				"max_file_size":      limits.MaxFileSize,
+				"channels":           h.ChannelNames(),
+				"topics":             h.ChannelTopics(),
+				"username":           client.Username,
+				"bot":                client.Bot,
+			},
+		}, log)
+
 		// Register client with hub
 		h.Register <- client
 
 		// Start goroutines for reading and writing
-		go readPump(client, h)
-		go writePump(client)
+		go readPump(client, h, store, limits, log)
+		go writePump(client, limits, log)
 	}
 }